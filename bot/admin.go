@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot/admin"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// StreamCursor is the last notification acked on a stream.
+type StreamCursor struct {
+	SequenceID uint64    `json:"sequence_id"`
+	Acked      time.Time `json:"acked"`
+}
+
+func (b *Bot) setCursor(kind string, seq uint64) {
+	defer b.cursorsMtx.Unlock()
+	b.cursorsMtx.Lock()
+	b.cursors[kind] = StreamCursor{SequenceID: seq, Acked: time.Now()}
+}
+
+// StreamCursors returns the last acked notification of every stream that
+// received one, keyed by the Record constant of the stream.
+func (b *Bot) StreamCursors() map[string]StreamCursor {
+	defer b.cursorsMtx.Unlock()
+	b.cursorsMtx.Lock()
+
+	res := make(map[string]StreamCursor, len(b.cursors))
+	for k, v := range b.cursors {
+		res[k] = v
+	}
+	return res
+}
+
+// Admin returns the admin API server. Callers may register their own methods
+// in it to have them served by Run on the admin socket.
+func (b *Bot) Admin() *admin.Server {
+	return b.admin
+}
+
+// WhitelistEntry is a whitelisted user as returned by the admin API.
+type WhitelistEntry struct {
+	ID   string `json:"id"`
+	Nick string `json:"nick,omitempty"`
+}
+
+type adminUserParams struct {
+	User string `json:"user"`
+}
+
+type adminSendParams struct {
+	To  string `json:"to"`
+	Msg string `json:"msg"`
+}
+
+func (b *Bot) adminResolveUser(params json.RawMessage) (zkidentity.ShortID, error) {
+	var p adminUserParams
+	if err := admin.DecodeParams(params, &p); err != nil {
+		return zkidentity.ShortID{}, err
+	}
+	if p.User == "" {
+		return zkidentity.ShortID{}, errors.New("user is required")
+	}
+	return b.ResolveUser(ParseUserRef(p.User))
+}
+
+func (b *Bot) adminSendParams(params json.RawMessage) (adminSendParams, error) {
+	var p adminSendParams
+	if err := admin.DecodeParams(params, &p); err != nil {
+		return p, err
+	}
+	if p.To == "" || p.Msg == "" {
+		return p, errors.New("to and msg are required")
+	}
+	return p, nil
+}
+
+// registerAdminMethods adds the bot methods to the admin API.
+func (b *Bot) registerAdminMethods() {
+	a := b.admin
+
+	a.Handle("whitelist.list", "List the whitelisted users.",
+		func(context.Context, json.RawMessage) (interface{}, error) {
+			ids := b.WhitelistEntries()
+			sort.Strings(ids)
+			res := make([]WhitelistEntry, 0, len(ids))
+			for _, s := range ids {
+				e := WhitelistEntry{ID: s}
+				var id zkidentity.ShortID
+				if id.FromString(s) == nil {
+					if u, ok := b.LookupUser(id); ok {
+						e.Nick = u.Nick
+					}
+				}
+				res = append(res, e)
+			}
+			return res, nil
+		})
+
+	a.Handle("whitelist.add", "Whitelist a user. Params: user (ID or nick).",
+		func(_ context.Context, params json.RawMessage) (interface{}, error) {
+			id, err := b.adminResolveUser(params)
+			if err != nil {
+				return nil, err
+			}
+			return nil, b.WhitelistAdd(id)
+		})
+
+	a.Handle("whitelist.remove", "Remove a user from the whitelist. Params: user (ID or nick).",
+		func(_ context.Context, params json.RawMessage) (interface{}, error) {
+			id, err := b.adminResolveUser(params)
+			if err != nil {
+				return nil, err
+			}
+			return nil, b.WhitelistRemove(id)
+		})
+
+	a.Handle("gcs.list", "List the GCs the bot is a member of.",
+		func(ctx context.Context, _ json.RawMessage) (interface{}, error) {
+			return b.CachedGCs(ctx)
+		})
+
+	a.Handle("send.pm", "Send a PM. Params: to (user ID or nick), msg.",
+		func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+			p, err := b.adminSendParams(params)
+			if err != nil {
+				return nil, err
+			}
+			return nil, b.SendPMUser(ctx, ParseUserRef(p.To), p.Msg)
+		})
+
+	a.Handle("send.gc", "Send a GC message. Params: to (GC), msg.",
+		func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+			p, err := b.adminSendParams(params)
+			if err != nil {
+				return nil, err
+			}
+			return nil, b.SendGC(ctx, p.To, p.Msg)
+		})
+
+	a.Handle("streams", "Show the last acked notification of every stream.",
+		func(context.Context, json.RawMessage) (interface{}, error) {
+			return b.StreamCursors(), nil
+		})
+
+	a.Handle("health", "Show the health checks.",
+		func(context.Context, json.RawMessage) (interface{}, error) {
+			return b.healthReg.Report(false), nil
+		})
+}
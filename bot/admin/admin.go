@@ -0,0 +1,300 @@
+// Package admin implements a local control API served over a Unix socket.
+//
+// Requests and responses are newline delimited JSON objects. A connection may
+// issue any number of requests, which are answered in order.
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/decred/slog"
+)
+
+// Request is a call to a method registered in a Server.
+type Request struct {
+	ID     uint64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is the reply to a Request with the same ID. Error is set when the
+// call failed.
+type Response struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// HandlerFunc handles a method call. params is nil when the request had
+// none. The returned result is encoded as JSON.
+type HandlerFunc func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+type method struct {
+	help string
+	h    HandlerFunc
+}
+
+// MethodInfo describes a registered method.
+type MethodInfo struct {
+	Name string `json:"name"`
+	Help string `json:"help"`
+}
+
+// Server dispatches requests received on a Unix socket to the registered
+// methods.
+type Server struct {
+	log slog.Logger
+
+	mtx     sync.Mutex
+	methods map[string]method
+}
+
+// NewServer creates a server with only the "methods" method registered.
+func NewServer(log slog.Logger) *Server {
+	s := &Server{
+		log:     log,
+		methods: make(map[string]method),
+	}
+	s.Handle("methods", "List the available methods.",
+		func(context.Context, json.RawMessage) (interface{}, error) {
+			return s.Methods(), nil
+		})
+	return s
+}
+
+// Handle registers h as the handler of name, replacing any previous one.
+func (s *Server) Handle(name, help string, h HandlerFunc) {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	s.methods[name] = method{help: help, h: h}
+}
+
+// Methods returns the registered methods sorted by name.
+func (s *Server) Methods() []MethodInfo {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	res := make([]MethodInfo, 0, len(s.methods))
+	for name, m := range s.methods {
+		res = append(res, MethodInfo{Name: name, Help: m.help})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+func (s *Server) call(ctx context.Context, req *Request) Response {
+	res := Response{ID: req.ID}
+
+	s.mtx.Lock()
+	m, ok := s.methods[req.Method]
+	s.mtx.Unlock()
+	if !ok {
+		res.Error = fmt.Sprintf("unknown method %q", req.Method)
+		return res
+	}
+
+	v, err := m.h(ctx, req.Params)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if v != nil {
+		res.Result, err = json.Marshal(v)
+		if err != nil {
+			res.Error = fmt.Sprintf("unable to encode result: %v", err)
+		}
+	}
+	return res
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	enc := json.NewEncoder(conn)
+	for {
+		var req Request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		res := s.call(ctx, &req)
+		if res.Error != "" {
+			s.log.Debugf("Admin call %v failed: %v", req.Method, res.Error)
+		}
+		if err := enc.Encode(&res); err != nil {
+			return
+		}
+	}
+}
+
+// listen creates the socket at path. A leftover socket from a previous run
+// is removed, unless another process is still listening on it.
+//
+// The socket is bound in a private directory next to path and only moved to
+// path once it is restricted to the current user, so it is never reachable
+// with the permissions of the umask.
+func listen(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("admin socket %v is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".admin-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// The socket is moved, so it is removed by ListenAndServe instead.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// ListenAndServe serves the registered methods on the Unix socket at path
+// until ctx is done. The socket is only accessible to the current user.
+func (s *Server) ListenAndServe(ctx context.Context, path string) error {
+	l, err := listen(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	s.log.Infof("Serving admin API on %v", path)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.log.Warnf("Unable to accept admin connection: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go s.serveConn(ctx, conn)
+	}
+}
+
+// Client calls methods of a Server. Calls are serialized.
+type Client struct {
+	mtx    sync.Mutex
+	conn   net.Conn
+	dec    *json.Decoder
+	enc    *json.Encoder
+	nextID uint64
+}
+
+// Dial connects to the admin socket at path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		conn: conn,
+		dec:  json.NewDecoder(bufio.NewReader(conn)),
+		enc:  json.NewEncoder(conn),
+	}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Call calls method with params and decodes its result into result, unless
+// result is nil. Errors returned by the method are returned as a *CallError.
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	req := Request{Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = raw
+	}
+
+	defer c.mtx.Unlock()
+	c.mtx.Lock()
+
+	c.nextID++
+	req.ID = c.nextID
+
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	if err := c.enc.Encode(&req); err != nil {
+		return err
+	}
+	var res Response
+	if err := c.dec.Decode(&res); err != nil {
+		return err
+	}
+	if res.ID != req.ID {
+		return fmt.Errorf("got response to request %d, want %d", res.ID, req.ID)
+	}
+	if res.Error != "" {
+		return &CallError{Method: method, Msg: res.Error}
+	}
+	if result == nil || len(res.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(res.Result, result)
+}
+
+// CallError is an error returned by a method.
+type CallError struct {
+	Method string
+	Msg    string
+}
+
+func (e *CallError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Method, e.Msg)
+}
+
+// DecodeParams decodes the params of a request into v. Missing params leave
+// v untouched.
+func DecodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return fmt.Errorf("invalid params: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/decred/slog"
+)
+
+func TestListenAndServe(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "admin.sock")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := NewServer(slog.Disabled)
+	errChan := make(chan error, 1)
+	go func() { errChan <- s.ListenAndServe(ctx, path) }()
+
+	var c *Client
+	for c == nil {
+		var err error
+		if c, err = Dial(path); err != nil {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("socket not served: %v", err)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	defer c.Close()
+
+	// Only the socket is left in the directory, accessible to the
+	// current user only.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("socket permissions %v", perm)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+
+	var methods []MethodInfo
+	if err := c.Call(ctx, "methods", nil, &methods); err != nil {
+		t.Fatal(err)
+	}
+	if len(methods) != 1 || methods[0].Name != "methods" {
+		t.Fatalf("unexpected methods %v", methods)
+	}
+
+	// A second server does not take over the socket in use.
+	if err := NewServer(slog.Disabled).ListenAndServe(ctx, path); err == nil {
+		t.Fatal("socket in use was replaced")
+	}
+
+	cancel()
+	<-errChan
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket not removed: %v", err)
+	}
+}
@@ -0,0 +1,873 @@
+// Package alerts receives monitoring alerts over HTTP and delivers them to
+// GCs and users.
+//
+// Two payloads are accepted:
+//
+//	POST /alertmanager  the Prometheus Alertmanager webhook payload
+//	POST /alert         {"status": "firing"|"resolved", "name": "<name>",
+//	                     "labels": {...}, "summary": "...", "description": "..."}
+//
+// Alerts are routed by their labels, grouped per destination for a short
+// while so bursts arrive as a single message, and deduplicated per
+// destination: a firing alert is only repeated after RepeatInterval and a
+// resolved alert is only sent if it was sent as firing. Whitelisted users
+// may silence alerts everywhere with the !alerts PM command, and the users
+// alerts are routed to may silence the alerts sent to them.
+package alerts
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+const (
+	defaultGroupWait      = 10 * time.Second
+	defaultRepeatInterval = 4 * time.Hour
+	defaultSilence        = 2 * time.Hour
+	maxBody               = 1 << 20
+	flushInterval         = time.Second
+	stateTTL              = 7 * 24 * time.Hour
+)
+
+// Route sends the alerts matching every label in Match to GCs and users. A
+// value starting with "~" is matched as a regular expression against the
+// whole label value. An empty Match matches every alert.
+type Route struct {
+	Match map[string]string
+
+	GCs []string
+
+	// Users are the IDs or nicks of the users alerts are sent to by PM.
+	Users []string
+
+	// Continue keeps looking for matching routes after this one.
+	Continue bool
+}
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot bot.API
+
+	// Addr is the address Run listens on.
+	Addr string
+
+	// Token, when set, must be sent as a bearer token in the
+	// Authorization header of every request.
+	Token string
+
+	// Routes are tried in order. Alerts matching no route are logged and
+	// dropped.
+	Routes []Route
+
+	// GroupWait is how long alerts for the same destination are
+	// collected before being sent together. Defaults to 10 seconds.
+	GroupWait time.Duration
+
+	// RepeatInterval is how long to wait before sending again an alert
+	// that is still firing. Defaults to 4 hours.
+	RepeatInterval time.Duration
+}
+
+// Alert is a single alert.
+type Alert struct {
+	Fingerprint string            `json:"fingerprint"`
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// ShortID is the identifier of the alert used in messages and commands.
+func (a *Alert) ShortID() string {
+	if len(a.Fingerprint) > 8 {
+		return a.Fingerprint[:8]
+	}
+	return a.Fingerprint
+}
+
+func (a *Alert) name() string {
+	if name := a.Labels["alertname"]; name != "" {
+		return name
+	}
+	return "alert"
+}
+
+// fingerprint returns a stable identifier computed from the labels.
+func fingerprint(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s\xff%s\xff", k, labels[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// amPayload is the Alertmanager webhook payload.
+type amPayload struct {
+	Version string  `json:"version"`
+	Status  string  `json:"status"`
+	Alerts  []Alert `json:"alerts"`
+}
+
+// genericPayload is the payload of /alert.
+type genericPayload struct {
+	Status      string            `json:"status"`
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels"`
+	Summary     string            `json:"summary"`
+	Description string            `json:"description"`
+}
+
+type route struct {
+	Route
+	match map[string]*regexp.Regexp
+}
+
+func (r *route) matches(labels map[string]string) bool {
+	for k, v := range r.Match {
+		if re := r.match[k]; re != nil {
+			if !re.MatchString(labels[k]) {
+				return false
+			}
+			continue
+		}
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// dest is a GC or a user alerts are sent to.
+type dest struct {
+	gc   string
+	user string
+}
+
+func (d dest) String() string {
+	if d.gc != "" {
+		return "gc " + d.gc
+	}
+	return "user " + d.user
+}
+
+// stateKey is the key of the state of the alert with fingerprint fp sent to
+// d.
+func (d dest) stateKey(fp string) string {
+	return d.String() + "/" + fp
+}
+
+type group struct {
+	first  time.Time
+	alerts map[string]*Alert
+}
+
+// alertState is what was last sent about an alert to a destination.
+type alertState struct {
+	Fingerprint string    `json:"fingerprint"`
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	Sent        time.Time `json:"sent"`
+}
+
+// Silence mutes an alert until it expires.
+type Silence struct {
+	Fingerprint string    `json:"fingerprint"`
+	Name        string    `json:"name"`
+	By          string    `json:"by"`
+	Until       time.Time `json:"until"`
+
+	// Dest is the destination the alert is muted for. The alert is
+	// muted everywhere when empty.
+	Dest string `json:"dest,omitempty"`
+}
+
+type state struct {
+	// Alerts is keyed by destination and fingerprint, see
+	// dest.stateKey.
+	Alerts map[string]*alertState `json:"alerts"`
+
+	// Silences is keyed by fingerprint for the silences of every
+	// destination and by dest.stateKey for the others.
+	Silences map[string]*Silence `json:"silences"`
+}
+
+// Receiver receives alerts and sends them to the routed destinations.
+type Receiver struct {
+	bot            bot.API
+	log            slog.Logger
+	addr           string
+	token          string
+	routes         []*route
+	groupWait      time.Duration
+	repeatInterval time.Duration
+
+	mtx    sync.Mutex
+	groups map[dest]*group
+	state  state
+	file   string
+}
+
+func New(cfg Config) (*Receiver, error) {
+	routes := make([]*route, 0, len(cfg.Routes))
+	for i, cr := range cfg.Routes {
+		if len(cr.GCs) == 0 && len(cr.Users) == 0 {
+			return nil, fmt.Errorf("route %d: no gcs or users", i)
+		}
+		r := &route{Route: cr, match: make(map[string]*regexp.Regexp)}
+		for k, v := range cr.Match {
+			if !strings.HasPrefix(v, "~") {
+				continue
+			}
+			re, err := regexp.Compile("^(?:" + v[1:] + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("route %d: label %v: %w", i, k, err)
+			}
+			r.match[k] = re
+		}
+		routes = append(routes, r)
+	}
+
+	groupWait := cfg.GroupWait
+	if groupWait <= 0 {
+		groupWait = defaultGroupWait
+	}
+	repeatInterval := cfg.RepeatInterval
+	if repeatInterval <= 0 {
+		repeatInterval = defaultRepeatInterval
+	}
+
+	st := state{
+		Alerts:   make(map[string]*alertState),
+		Silences: make(map[string]*Silence),
+	}
+	file := filepath.Join(cfg.DataDir, "alerts.json")
+	raw, err := os.ReadFile(file)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(raw, &st); err != nil {
+			return nil, err
+		}
+		if st.Alerts == nil {
+			st.Alerts = make(map[string]*alertState)
+		}
+		if st.Silences == nil {
+			st.Silences = make(map[string]*Silence)
+		}
+	}
+
+	return &Receiver{
+		bot:            cfg.Bot,
+		log:            cfg.Log,
+		addr:           cfg.Addr,
+		token:          cfg.Token,
+		routes:         routes,
+		groupWait:      groupWait,
+		repeatInterval: repeatInterval,
+
+		groups: make(map[dest]*group),
+		state:  st,
+		file:   file,
+	}, nil
+}
+
+// save writes the alert state and silences to disk.
+// Must be called with the mutex held.
+func (r *Receiver) save() error {
+	raw, err := json.Marshal(&r.state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.file, raw, 0o600)
+}
+
+// Run serves the receiver on the configured address and sends the grouped
+// alerts until the context is canceled.
+func (r *Receiver) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", r.addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           r.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	go r.flushLoop(ctx)
+
+	r.log.Infof("Receiving alerts on http://%v", l.Addr())
+	err = srv.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Handler returns the HTTP handler of the receiver.
+func (r *Receiver) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/alertmanager", r.handle(func(body []byte) ([]Alert, error) {
+		var p amPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, err
+		}
+		return p.Alerts, nil
+	}))
+	mux.HandleFunc("/alert", r.handle(func(body []byte) ([]Alert, error) {
+		var p genericPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, err
+		}
+		if p.Name == "" {
+			return nil, errors.New("name is required")
+		}
+		labels := make(map[string]string, len(p.Labels)+1)
+		for k, v := range p.Labels {
+			labels[k] = v
+		}
+		labels["alertname"] = p.Name
+		return []Alert{{
+			Status: p.Status,
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary":     p.Summary,
+				"description": p.Description,
+			},
+		}}, nil
+	}))
+	return mux
+}
+
+func (r *Receiver) handle(decode func([]byte) ([]Alert, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.token != "" {
+			auth := []byte(req.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(auth, []byte("Bearer "+r.token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxBody))
+		if err != nil {
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		alerts, err := decode(body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+			return
+		}
+		for i := range alerts {
+			a := &alerts[i]
+			if a.Status != StatusFiring && a.Status != StatusResolved {
+				http.Error(w, fmt.Sprintf("invalid status %q", a.Status),
+					http.StatusBadRequest)
+				return
+			}
+			if a.Fingerprint == "" {
+				a.Fingerprint = fingerprint(a.Labels)
+			}
+		}
+		r.add(alerts, time.Now())
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// add queues the alerts in the groups of their destinations.
+func (r *Receiver) add(alerts []Alert, now time.Time) {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	for i := range alerts {
+		a := alerts[i]
+		var routed bool
+		for _, rt := range r.routes {
+			if !rt.matches(a.Labels) {
+				continue
+			}
+			routed = true
+			for _, gc := range rt.GCs {
+				r.queue(dest{gc: gc}, &a, now)
+			}
+			for _, u := range rt.Users {
+				r.queue(dest{user: u}, &a, now)
+			}
+			if !rt.Continue {
+				break
+			}
+		}
+		if !routed {
+			r.log.Warnf("Alert %v (%v) matches no route", a.ShortID(), a.name())
+		}
+	}
+}
+
+// queue adds a to the group of d.
+// Must be called with the mutex held.
+func (r *Receiver) queue(d dest, a *Alert, now time.Time) {
+	g, ok := r.groups[d]
+	if !ok {
+		g = &group{first: now, alerts: make(map[string]*Alert)}
+		r.groups[d] = g
+	}
+	g.alerts[a.Fingerprint] = a
+}
+
+func (r *Receiver) flushLoop(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.flush(ctx, now)
+		}
+	}
+}
+
+type outgoing struct {
+	dest   dest
+	msg    string
+	alerts []*Alert
+}
+
+// flush sends the groups that waited for GroupWait. The state of an alert is
+// kept per destination and only updated once it was sent there. The alerts
+// of a failed send are queued again, so they are retried after GroupWait and
+// do not hold back the other destinations.
+func (r *Receiver) flush(ctx context.Context, now time.Time) {
+	var out []outgoing
+
+	r.mtx.Lock()
+	for d, g := range r.groups {
+		if now.Sub(g.first) < r.groupWait {
+			continue
+		}
+		delete(r.groups, d)
+		alerts := r.filter(d, g, now)
+		if msg := r.render(alerts, now); msg != "" {
+			out = append(out, outgoing{dest: d, msg: msg, alerts: alerts})
+		}
+	}
+	if r.expire(now) {
+		if err := r.save(); err != nil {
+			r.log.Errorf("failed to save alert state: %v", err)
+		}
+	}
+	r.mtx.Unlock()
+
+	for _, o := range out {
+		var err error
+		if o.dest.gc != "" {
+			err = r.bot.SendGC(ctx, o.dest.gc, o.msg)
+		} else {
+			err = r.bot.SendPMUser(ctx, bot.ParseUserRef(o.dest.user), o.msg)
+		}
+		if err != nil {
+			r.log.Errorf("failed to send alerts to %v: %v", o.dest, err)
+			r.requeue(o.dest, o.alerts, now)
+			continue
+		}
+		r.sent(o.dest, o.alerts, now)
+	}
+}
+
+// requeue adds alerts that could not be sent back to the group of d. Alerts
+// received again meanwhile are more recent and are kept instead.
+func (r *Receiver) requeue(d dest, alerts []*Alert, now time.Time) {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	for _, a := range alerts {
+		if g := r.groups[d]; g != nil && g.alerts[a.Fingerprint] != nil {
+			continue
+		}
+		r.queue(d, a, now)
+	}
+}
+
+// sent records that alerts were sent to d.
+func (r *Receiver) sent(d dest, alerts []*Alert, now time.Time) {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	for _, a := range alerts {
+		r.state.Alerts[d.stateKey(a.Fingerprint)] = &alertState{
+			Fingerprint: a.Fingerprint,
+			Name:        a.name(),
+			Status:      a.Status,
+			Sent:        now,
+		}
+	}
+	if err := r.save(); err != nil {
+		r.log.Errorf("failed to save alert state: %v", err)
+	}
+}
+
+// filter returns the alerts of the group of d that are neither silenced nor
+// repeated.
+// Must be called with the mutex held.
+func (r *Receiver) filter(d dest, g *group, now time.Time) []*Alert {
+	var res []*Alert
+	for fp, a := range g.alerts {
+		if r.silenced(d, fp, now) {
+			continue
+		}
+		st := r.state.Alerts[d.stateKey(fp)]
+		switch a.Status {
+		case StatusFiring:
+			if st != nil && st.Status == StatusFiring && now.Sub(st.Sent) < r.repeatInterval {
+				continue
+			}
+		case StatusResolved:
+			if st == nil || st.Status != StatusFiring {
+				continue
+			}
+		}
+		res = append(res, a)
+	}
+	return res
+}
+
+// silenced returns whether the alert with fingerprint fp is silenced for d.
+// Must be called with the mutex held.
+func (r *Receiver) silenced(d dest, fp string, now time.Time) bool {
+	for _, key := range []string{fp, d.stateKey(fp)} {
+		if s := r.state.Silences[key]; s != nil && now.Before(s.Until) {
+			return true
+		}
+	}
+	return false
+}
+
+// expire removes expired silences and the state of alerts not sent in a
+// long time. It returns whether anything was removed.
+// Must be called with the mutex held.
+func (r *Receiver) expire(now time.Time) bool {
+	var changed bool
+	for k, s := range r.state.Silences {
+		if !now.Before(s.Until) {
+			delete(r.state.Silences, k)
+			changed = true
+		}
+	}
+	for k, st := range r.state.Alerts {
+		if now.Sub(st.Sent) > stateTTL {
+			delete(r.state.Alerts, k)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (r *Receiver) render(alerts []*Alert, now time.Time) string {
+	if len(alerts) == 0 {
+		return ""
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Status != alerts[j].Status {
+			return alerts[i].Status == StatusFiring
+		}
+		return alerts[i].name() < alerts[j].name()
+	})
+
+	var firing, resolved int
+	for _, a := range alerts {
+		if a.Status == StatusFiring {
+			firing++
+		} else {
+			resolved++
+		}
+	}
+
+	var sb strings.Builder
+	switch {
+	case firing > 0 && resolved > 0:
+		fmt.Fprintf(&sb, "[FIRING:%d RESOLVED:%d]\n", firing, resolved)
+	case firing > 0:
+		fmt.Fprintf(&sb, "[FIRING:%d]\n", firing)
+	default:
+		fmt.Fprintf(&sb, "[RESOLVED:%d]\n", resolved)
+	}
+	for _, a := range alerts {
+		fmt.Fprintf(&sb, "%s %s %s", strings.ToUpper(a.Status), a.ShortID(), a.name())
+		if labels := formatLabels(a.Labels); labels != "" {
+			fmt.Fprintf(&sb, " {%s}", labels)
+		}
+		summary := a.Annotations["summary"]
+		if summary == "" {
+			summary = a.Annotations["description"]
+		}
+		if summary != "" {
+			fmt.Fprintf(&sb, ": %s", summary)
+		}
+		if a.Status == StatusFiring && !a.StartsAt.IsZero() {
+			fmt.Fprintf(&sb, " (for %v)", now.Sub(a.StartsAt).Truncate(time.Second))
+		}
+		sb.WriteString("\n")
+	}
+	if firing > 0 {
+		sb.WriteString("Silence with: !alerts silence <id> [duration]")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// formatLabels formats the labels other than alertname.
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		if k != "alertname" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+labels[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// scope returns the destinations of the alerts uid may silence. Whitelisted
+// users may silence alerts everywhere, which is reported by all. Other users
+// may only silence the alerts sent to them, for themselves.
+func (r *Receiver) scope(uid zkidentity.ShortID) (dests []dest, all bool) {
+	if r.bot.IsWhitelisted(uid) {
+		return nil, true
+	}
+	seen := make(map[dest]bool)
+	for _, rt := range r.routes {
+		for _, u := range rt.Users {
+			d := dest{user: u}
+			if seen[d] {
+				continue
+			}
+			id, err := r.bot.ResolveUser(bot.ParseUserRef(u))
+			if err == nil && id == uid {
+				seen[d] = true
+				dests = append(dests, d)
+			}
+		}
+	}
+	return dests, false
+}
+
+// inScope returns whether key, a key of the alert state or of the silences,
+// belongs to one of dests.
+func inScope(key, fp string, dests []dest) bool {
+	for _, d := range dests {
+		if key == d.stateKey(fp) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchID returns the fingerprint among fps that starts with the short ID
+// id. It returns an empty fingerprint when none does and fails when several
+// distinct fingerprints do.
+func matchID(id string, fps []string) (string, error) {
+	var match string
+	for _, fp := range fps {
+		if fp == "" || !strings.HasPrefix(fp, id) {
+			continue
+		}
+		if match != "" && match != fp {
+			return "", fmt.Errorf("alert id %v is ambiguous", id)
+		}
+		match = fp
+	}
+	return match, nil
+}
+
+// Silences returns the active silences.
+func (r *Receiver) Silences() []Silence {
+	now := time.Now()
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	res := make([]Silence, 0, len(r.state.Silences))
+	for _, s := range r.state.Silences {
+		if now.Before(s.Until) {
+			res = append(res, *s)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Until.Before(res[j].Until) })
+	return res
+}
+
+// silence mutes the alert with the short ID id, everywhere when all is set
+// and otherwise for the destinations in dests it was sent to.
+func (r *Receiver) silence(id, by string, d time.Duration, dests []dest, all bool) (*Silence, error) {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	var fps []string
+	for k, st := range r.state.Alerts {
+		if all || inScope(k, st.Fingerprint, dests) {
+			fps = append(fps, st.Fingerprint)
+		}
+	}
+	fp, err := matchID(id, fps)
+	if err != nil {
+		return nil, err
+	}
+	if fp == "" {
+		return nil, fmt.Errorf("unknown alert %v", id)
+	}
+
+	var res *Silence
+	until := time.Now().Add(d)
+	for k, st := range r.state.Alerts {
+		if st.Fingerprint != fp {
+			continue
+		}
+		s := &Silence{Fingerprint: fp, Name: st.Name, By: by, Until: until}
+		if all {
+			r.state.Silences[fp] = s
+			return s, r.save()
+		}
+		if inScope(k, fp, dests) {
+			s.Dest = strings.TrimSuffix(k, "/"+fp)
+			r.state.Silences[k] = s
+			res = s
+		}
+	}
+	return res, r.save()
+}
+
+// unsilence removes the silences of the alert with the short ID id, only
+// those of dests unless all is set.
+func (r *Receiver) unsilence(id string, dests []dest, all bool) error {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	var fps []string
+	for k, s := range r.state.Silences {
+		if all || inScope(k, s.Fingerprint, dests) {
+			fps = append(fps, s.Fingerprint)
+		}
+	}
+	fp, err := matchID(id, fps)
+	if err != nil {
+		return err
+	}
+	if fp == "" {
+		return fmt.Errorf("alert %v is not silenced", id)
+	}
+	for k, s := range r.state.Silences {
+		if s.Fingerprint == fp && (all || inScope(k, fp, dests)) {
+			delete(r.state.Silences, k)
+		}
+	}
+	return r.save()
+}
+
+// HandleCommand handles the !alerts PM command.
+func (r *Receiver) HandleCommand(ctx context.Context, uid zkidentity.ShortID, nick string, args []string) error {
+	reply := func(f string, a ...interface{}) error {
+		return r.bot.SendPMUser(ctx, bot.UserRefID(uid), fmt.Sprintf(f, a...))
+	}
+	const usage = "usage: !alerts silence <id> [duration] | unsilence <id> | silences"
+	if len(args) == 0 {
+		return reply(usage)
+	}
+	dests, all := r.scope(uid)
+	if !all && len(dests) == 0 {
+		return reply("you may not manage alerts")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "silence":
+		if len(args) < 2 {
+			return reply("usage: !alerts silence <id> [duration]")
+		}
+		d := defaultSilence
+		if len(args) > 2 {
+			var err error
+			d, err = time.ParseDuration(args[2])
+			if err != nil || d <= 0 {
+				return reply("invalid duration %q", args[2])
+			}
+		}
+		s, err := r.silence(strings.ToLower(args[1]), nick, d, dests, all)
+		if err != nil {
+			return reply("%v", err)
+		}
+		r.log.Infof("%v silenced alert %v until %v", nick, s.Fingerprint, s.Until)
+		return reply("silenced %v until %v", args[1], s.Until.UTC().Format(time.RFC3339))
+
+	case "unsilence":
+		if len(args) < 2 {
+			return reply("usage: !alerts unsilence <id>")
+		}
+		if err := r.unsilence(strings.ToLower(args[1]), dests, all); err != nil {
+			return reply("%v", err)
+		}
+		return reply("unsilenced %v", args[1])
+
+	case "silences":
+		silences := r.Silences()
+		if len(silences) == 0 {
+			return reply("no active silences")
+		}
+		var sb strings.Builder
+		for _, s := range silences {
+			fmt.Fprintf(&sb, "%.8s %v by %v until %v", s.Fingerprint, s.Name,
+				s.By, s.Until.UTC().Format(time.RFC3339))
+			if s.Dest != "" {
+				fmt.Fprintf(&sb, " for %v", s.Dest)
+			}
+			sb.WriteString("\n")
+		}
+		return reply("%s", sb.String())
+
+	default:
+		return reply(usage)
+	}
+}
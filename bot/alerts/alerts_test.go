@@ -0,0 +1,244 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+// flakyBot fails to send to GCs while fail is set.
+type flakyBot struct {
+	bot.API
+
+	mtx  sync.Mutex
+	fail bool
+}
+
+func (b *flakyBot) setFail(fail bool) {
+	b.mtx.Lock()
+	b.fail = fail
+	b.mtx.Unlock()
+}
+
+func (b *flakyBot) SendGC(ctx context.Context, gc, msg string) error {
+	b.mtx.Lock()
+	fail := b.fail
+	b.mtx.Unlock()
+	if fail {
+		return errors.New("gc unavailable")
+	}
+	return b.API.SendGC(ctx, gc, msg)
+}
+
+func newTestReceiver(t *testing.T, user zkidentity.ShortID) (context.Context, *bottest.Server, *flakyBot, *Receiver) {
+	t.Helper()
+	ctx, srv, b := bottest.NewBot(t)
+	fb := &flakyBot{API: b}
+	r, err := New(Config{
+		DataDir: t.TempDir(),
+		Log:     slog.Disabled,
+		Bot:     fb,
+		Routes: []Route{
+			{Match: map[string]string{"severity": "page"}, Users: []string{user.String()}, Continue: true},
+			{GCs: []string{"ops"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx, srv, fb, r
+}
+
+func firing() []Alert {
+	labels := map[string]string{"alertname": "DiskFull", "severity": "page"}
+	return []Alert{{
+		Fingerprint: fingerprint(labels),
+		Status:      StatusFiring,
+		Labels:      labels,
+		StartsAt:    time.Now(),
+	}}
+}
+
+func TestAlertSentToEveryDestination(t *testing.T) {
+	var user zkidentity.ShortID
+	user[0] = 1
+	ctx, srv, _, r := newTestReceiver(t, user)
+
+	// The group of the user opens before the group of the GC, so it is
+	// flushed first.
+	now := time.Now()
+	r.mtx.Lock()
+	r.queue(dest{user: user.String()}, &firing()[0], now.Add(-defaultGroupWait))
+	r.mtx.Unlock()
+	r.add(firing(), now)
+	r.flush(ctx, now)
+	if got := len(srv.PMs()); got != 1 {
+		t.Fatalf("%d pms sent, want 1", got)
+	}
+	r.flush(ctx, now.Add(defaultGroupWait))
+	if got := len(srv.GCMs()); got != 1 {
+		t.Fatalf("%d gc messages sent, want 1", got)
+	}
+
+	// Both destinations were told: the alert is not repeated.
+	now = now.Add(time.Minute)
+	r.add(firing(), now)
+	r.flush(ctx, now.Add(defaultGroupWait))
+	if pms, gcms := len(srv.PMs()), len(srv.GCMs()); pms != 1 || gcms != 1 {
+		t.Fatalf("alert repeated: %d pms, %d gc messages", pms, gcms)
+	}
+}
+
+func TestFailedSendRetried(t *testing.T) {
+	var user zkidentity.ShortID
+	user[0] = 1
+	ctx, srv, fb, r := newTestReceiver(t, user)
+
+	fb.setFail(true)
+	now := time.Now().Add(defaultGroupWait)
+	r.add(firing(), now.Add(-defaultGroupWait))
+	r.flush(ctx, now)
+	if pms, gcms := len(srv.PMs()), len(srv.GCMs()); pms != 1 || gcms != 0 {
+		t.Fatalf("%d pms, %d gc messages sent, want 1 and 0", pms, gcms)
+	}
+
+	// The failed group is queued again and sent after GroupWait, without
+	// the alert firing again.
+	fb.setFail(false)
+	r.flush(ctx, now.Add(defaultGroupWait/2))
+	if got := len(srv.GCMs()); got != 0 {
+		t.Fatalf("%d gc messages sent before GroupWait", got)
+	}
+	r.flush(ctx, now.Add(defaultGroupWait))
+	if pms, gcms := len(srv.PMs()), len(srv.GCMs()); pms != 1 || gcms != 1 {
+		t.Fatalf("%d pms, %d gc messages sent, want 1 and 1", pms, gcms)
+	}
+
+	// The alert fires again before the repeat interval: nobody gets it
+	// twice.
+	now = now.Add(time.Minute)
+	r.add(firing(), now)
+	r.flush(ctx, now.Add(defaultGroupWait))
+	if pms, gcms := len(srv.PMs()), len(srv.GCMs()); pms != 1 || gcms != 1 {
+		t.Fatalf("%d pms, %d gc messages sent, want 1 and 1", pms, gcms)
+	}
+}
+
+func TestSilences(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	var admin, user, other, stranger zkidentity.ShortID
+	admin[0], user[0], other[0], stranger[0] = 1, 2, 3, 4
+	if err := b.WhitelistAdd(admin); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(Config{
+		DataDir: t.TempDir(),
+		Log:     slog.Disabled,
+		Bot:     b,
+		Routes: []Route{
+			{Users: []string{user.String(), other.String()}, GCs: []string{"ops"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := func(fp string) Alert {
+		return Alert{Fingerprint: fp, Status: StatusFiring,
+			Labels: map[string]string{"alertname": "A" + fp[4:8]}}
+	}
+	const fp1, fp2 = "aaaa1111aaaa1111", "aaaa2222aaaa2222"
+	now := time.Now()
+	fire := func() {
+		now = now.Add(defaultRepeatInterval)
+		r.add([]Alert{alert(fp1), alert(fp2)}, now.Add(-defaultGroupWait))
+		r.flush(ctx, now)
+	}
+	// received returns how many times the alert with fingerprint fp was
+	// sent to uid, or to the GC when uid is empty.
+	received := func(uid zkidentity.ShortID, fp string) int {
+		var msgs []string
+		if uid.IsEmpty() {
+			for _, m := range srv.GCMs() {
+				msgs = append(msgs, m.Msg)
+			}
+		} else {
+			for _, pm := range srv.PMs() {
+				if pm.User == uid.String() {
+					msgs = append(msgs, pm.Msg.Message)
+				}
+			}
+		}
+		var n int
+		for _, m := range msgs {
+			if strings.Contains(m, "FIRING "+fp[:8]) {
+				n++
+			}
+		}
+		return n
+	}
+	check := func(when string, fp string, u, o, gc int) {
+		t.Helper()
+		gotU, gotO, gotGC := received(user, fp), received(other, fp), received(zkidentity.ShortID{}, fp)
+		if gotU != u || gotO != o || gotGC != gc {
+			t.Fatalf("%v: alert %v sent to user %d, other %d, gc %d times, want %d, %d, %d",
+				when, fp[:8], gotU, gotO, gotGC, u, o, gc)
+		}
+	}
+	cmd := func(uid zkidentity.ShortID, args ...string) string {
+		t.Helper()
+		if err := r.HandleCommand(ctx, uid, "u", args); err != nil {
+			t.Fatal(err)
+		}
+		pms := srv.PMs()
+		return pms[len(pms)-1].Msg.Message
+	}
+	fire()
+
+	if got := cmd(stranger, "silence", "aaaa1111"); got != "you may not manage alerts" {
+		t.Fatalf("stranger: %q", got)
+	}
+	if got := cmd(user, "silence", "aaaa"); got != "alert id aaaa is ambiguous" {
+		t.Fatalf("ambiguous silence: %q", got)
+	}
+	if got := cmd(user, "silence", "bbbb"); got != "unknown alert bbbb" {
+		t.Fatalf("unknown silence: %q", got)
+	}
+
+	// A user's silence only applies to the alerts sent to them.
+	if got := cmd(user, "silence", "aaaa1111", "100h"); !strings.HasPrefix(got, "silenced") {
+		t.Fatalf("user silence: %q", got)
+	}
+	fire()
+	check("after user silence", fp1, 1, 2, 2)
+	check("after user silence", fp2, 2, 2, 2)
+
+	// Silences set by admins apply everywhere and cannot be removed by
+	// users.
+	cmd(admin, "silence", "aaaa2222", "100h")
+	if got := cmd(other, "unsilence", "aaaa2222"); got != "alert aaaa2222 is not silenced" {
+		t.Fatalf("user unsilenced an admin silence: %q", got)
+	}
+	fire()
+	check("after admin silence", fp1, 1, 3, 3)
+	check("after admin silence", fp2, 2, 2, 2)
+	if got := cmd(admin, "unsilence", "aaaa"); got != "alert id aaaa is ambiguous" {
+		t.Fatalf("ambiguous unsilence: %q", got)
+	}
+	cmd(admin, "unsilence", "aaaa1111")
+	cmd(admin, "unsilence", "aaaa2222")
+	if got := r.Silences(); len(got) != 0 {
+		t.Fatalf("silences left %+v", got)
+	}
+	fire()
+	check("after unsilence", fp1, 2, 4, 4)
+	check("after unsilence", fp2, 3, 3, 3)
+}
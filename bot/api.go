@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"sort"
@@ -15,6 +16,7 @@ func (b *Bot) GetGCs(ctx context.Context) ([]*types.ListGCsResponse_GCInfo, erro
 	var req types.ListGCsRequest
 	var rep types.ListGCsResponse
 
+	defer b.beginCall("GCService.List", &req)()
 	if err := b.gcService.List(ctx, &req, &rep); err != nil {
 		return nil, err
 	}
@@ -23,13 +25,34 @@ func (b *Bot) GetGCs(ctx context.Context) ([]*types.ListGCsResponse_GCInfo, erro
 	return rep.Gcs, nil
 }
 
+// IsGCMember returns whether uid is a member of the GC with the given ID or
+// alias, according to the GC definition known to the client.
+func (b *Bot) IsGCMember(ctx context.Context, gc string, uid zkidentity.ShortID) (bool, error) {
+	req := types.GetGCRequest{Gc: gc}
+	var rep types.GetGCResponse
+
+	defer b.beginCall("GCService.GetGC", &req)()
+	if err := b.gcService.GetGC(ctx, &req, &rep); err != nil {
+		return false, err
+	}
+	for _, m := range rep.Gc.GetMembers() {
+		if bytes.Equal(m, uid[:]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (b *Bot) SendFile(ctx context.Context, uid, filename string) error {
 	sfr := types.SendFileRequest{
 		User:     uid,
 		Filename: filename,
 	}
 
-	return b.chatService.SendFile(ctx, &sfr, &types.SendFileResponse{})
+	defer b.beginCall("ChatService.SendFile", &sfr)()
+	err := b.chatService.SendFile(ctx, &sfr, &types.SendFileResponse{})
+	b.countSent("file", err)
+	return err
 }
 
 func (b *Bot) SendPM(ctx context.Context, nick, msg string) error {
@@ -40,7 +63,10 @@ func (b *Bot) SendPM(ctx context.Context, nick, msg string) error {
 		},
 	}
 	var res types.PMResponse
-	return b.chatService.PM(ctx, req, &res)
+	defer b.beginCall("ChatService.PM", req)()
+	err := b.chatService.PM(ctx, req, &res)
+	b.countSent("pm", err)
+	return err
 }
 
 func (b *Bot) SendGC(ctx context.Context, gc, msg string) error {
@@ -49,7 +75,13 @@ func (b *Bot) SendGC(ctx context.Context, gc, msg string) error {
 		Msg: msg,
 	}
 	var res types.GCMResponse
-	return b.chatService.GCM(ctx, req, &res)
+	defer b.beginCall("ChatService.GCM", req)()
+	err := b.chatService.GCM(ctx, req, &res)
+	b.countSent("gc", err)
+	if err == nil {
+		b.metrics.gcSent.Inc(gc)
+	}
+	return err
 }
 
 func (b *Bot) SubscribeToUserPosts(ctx context.Context, user zkidentity.ShortID) error {
@@ -57,6 +89,7 @@ func (b *Bot) SubscribeToUserPosts(ctx context.Context, user zkidentity.ShortID)
 	req := types.SubscribeToPostsRequest{
 		User: user.String(),
 	}
+	defer b.beginCall("PostsService.SubscribeToPosts", &req)()
 	return b.postService.SubscribeToPosts(ctx, &req, &rep)
 }
 
@@ -67,7 +100,10 @@ func (b *Bot) PayTip(ctx context.Context, uid zkidentity.ShortID, tipAmt dcrutil
 		DcrAmount:   tipAmt.ToCoin(),
 		MaxAttempts: maxAttempts,
 	}
-	return b.paymentService.TipUser(ctx, &req, &rep)
+	defer b.beginCall("PaymentsService.TipUser", &req)()
+	err := b.paymentService.TipUser(ctx, &req, &rep)
+	b.countTip(tipAmt, err)
+	return err
 }
 
 func (b *Bot) MediateKX(ctx context.Context, mediator, target string) error {
@@ -76,6 +112,7 @@ func (b *Bot) MediateKX(ctx context.Context, mediator, target string) error {
 		Mediator: mediator,
 		Target:   target,
 	}
+	defer b.beginCall("ChatService.MediateKX", &mreq)()
 	return b.chatService.MediateKX(ctx, &mreq, &mres)
 }
 
@@ -88,6 +125,7 @@ func (b *Bot) AcceptGCInvite(ctx context.Context, id string) error {
 	req := types.AcceptGCInviteRequest{
 		InviteId: i,
 	}
+	defer b.beginCall("GCService.AcceptGCInvite", &req)()
 	return b.gcService.AcceptGCInvite(ctx, &req, &res)
 }
 
@@ -97,6 +135,7 @@ func (b *Bot) InviteToGC(ctx context.Context, gc, id string) error {
 		Gc:   gc,
 		User: id,
 	}
+	defer b.beginCall("GCService.InviteToGC", &ireq)()
 	return b.gcService.InviteToGC(ctx, &ireq, &irep)
 
 }
@@ -112,6 +151,7 @@ func (b *Bot) WriteNewInvite(ctx context.Context, amt dcrutil.Amount, gc string)
 	var rep types.WriteNewInviteResponse
 
 	// Add GC
+	defer b.beginCall("ChatService.WriteNewInvite", &req)()
 	err := b.chatService.WriteNewInvite(ctx, &req, &rep)
 	if err != nil {
 		return nil, "", err
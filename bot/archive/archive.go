@@ -0,0 +1,337 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/decred/slog"
+)
+
+// Kind is the kind of an archived record.
+type Kind string
+
+const (
+	KindGC      Kind = "gc"
+	KindPM      Kind = "pm"
+	KindPost    Kind = "post"
+	KindComment Kind = "comment"
+)
+
+// pruneInterval is how often records past their retention are removed.
+const pruneInterval = time.Hour
+
+// Record is a single archived message, post or comment.
+type Record struct {
+	Kind   Kind      `json:"kind"`
+	GC     string    `json:"gc,omitempty"`
+	UID    string    `json:"uid"`
+	Nick   string    `json:"nick"`
+	PostID string    `json:"post_id,omitempty"`
+	Time   time.Time `json:"time"`
+	Msg    string    `json:"msg"`
+}
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot bot.API
+
+	// Retention is how long messages are kept per GC alias. GCs not in
+	// the map, PMs, posts and comments use DefaultRetention. A zero
+	// duration keeps records forever.
+	Retention        map[string]time.Duration
+	DefaultRetention time.Duration
+}
+
+// Archive records every message the bot receives and indexes it for full
+// text search.
+type Archive struct {
+	bot              bot.API
+	log              slog.Logger
+	dir              string
+	retention        map[string]time.Duration
+	defaultRetention time.Duration
+
+	mtx     sync.Mutex
+	records []*Record
+	index   map[string][]int
+}
+
+func New(cfg Config) (*Archive, error) {
+	dir := filepath.Join(cfg.DataDir, "archive")
+	if err := os.MkdirAll(filepath.Join(dir, "gc"), 0o700); err != nil {
+		return nil, err
+	}
+
+	a := &Archive{
+		bot:              cfg.Bot,
+		log:              cfg.Log,
+		dir:              dir,
+		retention:        cfg.Retention,
+		defaultRetention: cfg.DefaultRetention,
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "gc", "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	files = append(files,
+		filepath.Join(dir, "pm.jsonl"),
+		filepath.Join(dir, "posts.jsonl"),
+		filepath.Join(dir, "comments.jsonl"),
+	)
+	for _, file := range files {
+		recs, err := readRecords(file)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", file, err)
+		}
+		a.records = append(a.records, recs...)
+	}
+	sort.SliceStable(a.records, func(i, j int) bool {
+		return a.records[i].Time.Before(a.records[j].Time)
+	})
+	a.reindex()
+
+	return a, nil
+}
+
+// Run removes records past their retention period until the context is
+// canceled.
+func (a *Archive) Run(ctx context.Context) error {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		if err := a.prune(time.Now()); err != nil {
+			a.log.Errorf("failed to prune archive: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Archive) HandleGC(m *types.GCReceivedMsg) error {
+	if m.Msg == nil {
+		return nil
+	}
+	return a.add(&Record{
+		Kind: KindGC,
+		GC:   m.GcAlias,
+		UID:  hex.EncodeToString(m.Uid),
+		Nick: m.Nick,
+		Time: msgTime(m.TimestampMs),
+		Msg:  m.Msg.Message,
+	})
+}
+
+func (a *Archive) HandlePM(m *types.ReceivedPM) error {
+	if m.Msg == nil {
+		return nil
+	}
+	return a.add(&Record{
+		Kind: KindPM,
+		UID:  hex.EncodeToString(m.Uid),
+		Nick: m.Nick,
+		Time: msgTime(m.TimestampMs),
+		Msg:  m.Msg.Message,
+	})
+}
+
+func (a *Archive) HandlePost(p *types.ReceivedPost) error {
+	if p.Summary == nil || p.Post == nil {
+		return nil
+	}
+	msg := p.Post.Attributes[rpc.RMPMain]
+	if title := p.Post.Attributes[rpc.RMPTitle]; title != "" {
+		msg = title + "\n" + msg
+	}
+	return a.add(&Record{
+		Kind:   KindPost,
+		UID:    hex.EncodeToString(p.Summary.AuthorId),
+		Nick:   p.Summary.AuthorNick,
+		PostID: hex.EncodeToString(p.Summary.Id),
+		Time:   time.Unix(p.Summary.Date, 0),
+		Msg:    msg,
+	})
+}
+
+// HandlePostStatus archives comments. Other status updates are ignored.
+func (a *Archive) HandlePostStatus(ps *types.ReceivedPostStatus) error {
+	if ps.Status == nil || ps.Status.Attributes[rpc.RMPSComment] == "" {
+		return nil
+	}
+	return a.add(&Record{
+		Kind:   KindComment,
+		UID:    hex.EncodeToString(ps.StatusFrom),
+		Nick:   ps.StatusFromNick,
+		PostID: hex.EncodeToString(ps.PostId),
+		Time:   time.Now(),
+		Msg:    ps.Status.Attributes[rpc.RMPSComment],
+	})
+}
+
+func (a *Archive) add(rec *Record) error {
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	raw = append(raw, '\n')
+
+	defer a.mtx.Unlock()
+	a.mtx.Lock()
+
+	f, err := os.OpenFile(a.file(rec), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(raw); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	a.records = append(a.records, rec)
+	idx := len(a.records) - 1
+	for _, tok := range uniqueTokens(rec.Msg) {
+		a.index[tok] = append(a.index[tok], idx)
+	}
+	return nil
+}
+
+// prune drops records older than their retention and rewrites the files
+// they were stored in. The records in memory are only replaced once every
+// file was rewritten.
+func (a *Archive) prune(now time.Time) error {
+	defer a.mtx.Unlock()
+	a.mtx.Lock()
+
+	kept := make([]*Record, 0, len(a.records))
+	dirty := make(map[string]bool)
+	for _, rec := range a.records {
+		retention := a.defaultRetention
+		if r, ok := a.retention[rec.GC]; ok && rec.Kind == KindGC {
+			retention = r
+		}
+		if retention > 0 && now.Sub(rec.Time) > retention {
+			dirty[a.file(rec)] = true
+			continue
+		}
+		kept = append(kept, rec)
+	}
+	if len(dirty) == 0 {
+		return nil
+	}
+
+	byFile := make(map[string][]*Record, len(dirty))
+	for _, rec := range kept {
+		if file := a.file(rec); dirty[file] {
+			byFile[file] = append(byFile[file], rec)
+		}
+	}
+	for file := range dirty {
+		if err := writeRecords(file, byFile[file]); err != nil {
+			return err
+		}
+	}
+	a.records = kept
+	a.reindex()
+	a.log.Debugf("pruned archive files: %d", len(dirty))
+	return nil
+}
+
+// reindex rebuilds the full text index. Must be called with the mutex held.
+func (a *Archive) reindex() {
+	a.index = make(map[string][]int)
+	for i, rec := range a.records {
+		for _, tok := range uniqueTokens(rec.Msg) {
+			a.index[tok] = append(a.index[tok], i)
+		}
+	}
+}
+
+// file returns the file a record is stored in.
+func (a *Archive) file(rec *Record) string {
+	switch rec.Kind {
+	case KindGC:
+		name := url.PathEscape(strings.ToLower(rec.GC))
+		return filepath.Join(a.dir, "gc", name+".jsonl")
+	case KindPM:
+		return filepath.Join(a.dir, "pm.jsonl")
+	case KindPost:
+		return filepath.Join(a.dir, "posts.jsonl")
+	default:
+		return filepath.Join(a.dir, "comments.jsonl")
+	}
+}
+
+func readRecords(file string) ([]*Record, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recs []*Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, &rec)
+	}
+	return recs, scanner.Err()
+}
+
+func writeRecords(file string, recs []*Record) error {
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func msgTime(ms int64) time.Time {
+	if ms == 0 {
+		return time.Now()
+	}
+	return time.UnixMilli(ms)
+}
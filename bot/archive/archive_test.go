@@ -0,0 +1,209 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+func gcMsg(gc string, uid byte, nick, msg string, t time.Time) *types.GCReceivedMsg {
+	return &types.GCReceivedMsg{
+		GcAlias:     gc,
+		Uid:         []byte{uid},
+		Nick:        nick,
+		TimestampMs: t.UnixMilli(),
+		Msg:         &types.RMGroupMessage{Message: msg},
+	}
+}
+
+func pm(uid zkidentity.ShortID, nick, msg string, t time.Time) *types.ReceivedPM {
+	return &types.ReceivedPM{
+		Uid:         uid[:],
+		Nick:        nick,
+		TimestampMs: t.UnixMilli(),
+		Msg:         &types.RMPrivateMessage{Message: msg},
+	}
+}
+
+func TestSearch(t *testing.T) {
+	cfg := Config{DataDir: t.TempDir(), Log: slog.Disabled}
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	var alice, bob zkidentity.ShortID
+	alice[0], bob[0] = 1, 2
+	msgs := []*types.GCReceivedMsg{
+		gcMsg("dev", 1, "alice", "the release is ready", now.Add(-3*time.Hour)),
+		gcMsg("dev", 2, "bob", "Release notes, please!", now.Add(-2*time.Hour)),
+		gcMsg("ops", 2, "bob", "release deployed", now.Add(-time.Hour)),
+	}
+	for _, m := range msgs {
+		if err := a.HandleGC(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.HandlePM(pm(alice, "alice", "secret release key", now)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.HandlePM(pm(bob, "bob", "my release password", now)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records are reloaded from disk.
+	a, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args string
+		want []string
+	}{
+		{"keyword", "release", []string{"my release password", "secret release key",
+			"release deployed", "Release notes, please!", "the release is ready"}},
+		{"keywords", "RELEASE notes", []string{"Release notes, please!"}},
+		{"gc", "release gc:#dev", []string{"Release notes, please!", "the release is ready"}},
+		{"from", "from:bob kind:gc", []string{"release deployed", "Release notes, please!"}},
+		{"since", "release since:90m kind:gc", []string{"release deployed"}},
+		{"limit", "release limit:1", []string{"my release password"}},
+		{"requester", "release kind:pm requester", []string{"secret release key"}},
+	}
+	for _, tc := range tests {
+		args := strings.Fields(tc.args)
+		var requester string
+		if args[len(args)-1] == "requester" {
+			args, requester = args[:len(args)-1], alice.String()
+		}
+		q, err := ParseQuery(args, now)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		q.Requester = requester
+		var got []string
+		for _, rec := range a.Search(q) {
+			got = append(got, rec.Msg)
+		}
+		if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := ParseQuery([]string{"since:yesterday"}, now); err == nil {
+		t.Fatal("invalid time accepted")
+	}
+}
+
+func TestSearchCommand(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	a, err := New(Config{DataDir: t.TempDir(), Log: slog.Disabled, Bot: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var admin, other zkidentity.ShortID
+	admin[0], other[0] = 1, 2
+	if err := b.WhitelistAdd(admin); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := a.HandlePM(pm(other, "other", "private note", now)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.HandlePM(pm(admin, "admin", "admin note", now)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.HandleSearchCommand(ctx, other, "other", []string{"note"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.HandleSearchCommand(ctx, admin, "admin", []string{"note"}); err != nil {
+		t.Fatal(err)
+	}
+	pms := srv.PMs()
+	if len(pms) != 2 {
+		t.Fatalf("unexpected pms %v", pms)
+	}
+	if pms[0].User != other.String() || pms[0].Msg.Message != "you are not allowed to search the archive" {
+		t.Fatalf("unexpected reply %v", pms[0])
+	}
+	got := pms[1].Msg.Message
+	if pms[1].User != admin.String() || !strings.HasPrefix(got, "1 results:") ||
+		!strings.Contains(got, "admin note") || strings.Contains(got, "private note") {
+		t.Fatalf("unexpected results %v", pms[1])
+	}
+}
+
+func TestPrune(t *testing.T) {
+	cfg := Config{
+		DataDir:          t.TempDir(),
+		Log:              slog.Disabled,
+		Retention:        map[string]time.Duration{"dev": time.Hour},
+		DefaultRetention: 24 * time.Hour,
+	}
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	msgs := []*types.GCReceivedMsg{
+		gcMsg("dev", 1, "alice", "old dev", now.Add(-2*time.Hour)),
+		gcMsg("dev", 1, "alice", "new dev", now),
+		gcMsg("ops", 1, "alice", "old ops", now.Add(-2*time.Hour)),
+	}
+	for _, m := range msgs {
+		if err := a.HandleGC(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failed rewrite keeps the archive searchable.
+	tmp := a.file(a.records[0]) + ".tmp"
+	if err := os.Mkdir(tmp, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.prune(now); err == nil {
+		t.Fatal("prune did not fail")
+	}
+	if got := len(a.Search(Query{Keywords: []string{"dev"}})); got != 2 {
+		t.Fatalf("%d records after a failed prune, want 2", got)
+	}
+
+	if err := os.Remove(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.prune(now); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []*Archive{a, mustNew(t, cfg)} {
+		var got []string
+		for _, rec := range a.Search(Query{}) {
+			got = append(got, rec.Msg)
+		}
+		sort.Strings(got)
+		if strings.Join(got, "|") != "new dev|old ops" {
+			t.Fatalf("unexpected records after prune %q", got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(cfg.DataDir, "archive", "gc", "dev.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustNew(t *testing.T, cfg Config) *Archive {
+	t.Helper()
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
@@ -0,0 +1,245 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// Query filters archived records. Zero values match everything.
+type Query struct {
+	Kind  Kind
+	GC    string
+	User  string // Nick or id of the sender.
+	Since time.Time
+	Until time.Time
+
+	// Keywords must all be present in a record for it to match.
+	Keywords []string
+
+	// Limit is the maximum number of records returned. Newer records
+	// are returned first.
+	Limit int
+
+	// Requester, when set, is the id of the user searching. PM records
+	// are then limited to the PMs that user sent to the bot.
+	Requester string
+}
+
+// Search returns the records matching the query, newest first.
+func (a *Archive) Search(q Query) []Record {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	defer a.mtx.Unlock()
+	a.mtx.Lock()
+
+	candidates := a.candidates(q.Keywords)
+	var res []Record
+	for i := len(candidates) - 1; i >= 0 && len(res) < limit; i-- {
+		rec := a.records[candidates[i]]
+		if q.Kind != "" && rec.Kind != q.Kind {
+			continue
+		}
+		if q.Requester != "" && rec.Kind == KindPM && rec.UID != q.Requester {
+			continue
+		}
+		if q.GC != "" && !strings.EqualFold(rec.GC, q.GC) {
+			continue
+		}
+		if q.User != "" && !strings.EqualFold(rec.Nick, q.User) &&
+			!strings.EqualFold(rec.UID, q.User) {
+			continue
+		}
+		if !q.Since.IsZero() && rec.Time.Before(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && rec.Time.After(q.Until) {
+			continue
+		}
+		res = append(res, *rec)
+	}
+	return res
+}
+
+// candidates returns the sorted indexes of the records containing every
+// keyword. Must be called with the mutex held.
+func (a *Archive) candidates(keywords []string) []int {
+	var toks []string
+	for _, kw := range keywords {
+		toks = append(toks, tokenize(kw)...)
+	}
+	if len(toks) == 0 {
+		all := make([]int, len(a.records))
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+
+	// Start from the rarest token to keep the intersection small.
+	sort.Slice(toks, func(i, j int) bool {
+		return len(a.index[toks[i]]) < len(a.index[toks[j]])
+	})
+	res := a.index[toks[0]]
+	for _, tok := range toks[1:] {
+		res = intersect(res, a.index[tok])
+		if len(res) == 0 {
+			break
+		}
+	}
+	return res
+}
+
+// HandleSearchCommand runs a !search command sent by PM and replies with the
+// results. Only whitelisted users may search the archive, and the only PMs
+// they find are their own.
+//
+// The arguments are keywords plus optional filters: gc:<alias>,
+// from:<nick or id>, since:<duration or date> and until:<date>.
+func (a *Archive) HandleSearchCommand(ctx context.Context, uid zkidentity.ShortID, nick string, args []string) error {
+	reply := func(msg string) error {
+		return a.bot.SendPMUser(ctx, bot.UserRefID(uid), msg)
+	}
+	if !a.bot.IsWhitelisted(uid) {
+		return reply("you are not allowed to search the archive")
+	}
+
+	q, err := ParseQuery(args, time.Now())
+	if err != nil {
+		return reply(fmt.Sprintf("invalid search: %v", err))
+	}
+	q.Requester = uid.String()
+	recs := a.Search(q)
+	if len(recs) == 0 {
+		return reply("no results")
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d results:\n", len(recs))
+	for _, rec := range recs {
+		where := string(rec.Kind)
+		if rec.Kind == KindGC {
+			where = "#" + rec.GC
+		}
+		fmt.Fprintf(&sb, "[%v %v] <%v> %v\n",
+			rec.Time.UTC().Format("2006-01-02 15:04"), where, rec.Nick,
+			oneLine(rec.Msg))
+	}
+	return reply(sb.String())
+}
+
+// ParseQuery builds a query from !search arguments.
+func ParseQuery(args []string, now time.Time) (Query, error) {
+	var q Query
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, ":")
+		if !found || value == "" {
+			q.Keywords = append(q.Keywords, arg)
+			continue
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "gc":
+			q.GC = strings.TrimPrefix(value, "#")
+		case "from":
+			q.User = value
+		case "kind":
+			q.Kind = Kind(strings.ToLower(value))
+		case "since":
+			q.Since, err = parseTime(value, now)
+		case "until":
+			q.Until, err = parseTime(value, now)
+		case "limit":
+			_, err = fmt.Sscanf(value, "%d", &q.Limit)
+			if q.Limit > maxLimit {
+				q.Limit = maxLimit
+			}
+		default:
+			q.Keywords = append(q.Keywords, arg)
+		}
+		if err != nil {
+			return q, fmt.Errorf("%v: %w", key, err)
+		}
+	}
+	return q, nil
+}
+
+// parseTime parses either a duration before now (such as 2h or 7d) or a
+// date.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if strings.HasSuffix(s, "d") {
+		var days int
+		if _, err := fmt.Sscanf(s, "%dd", &days); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time %q", s)
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func uniqueTokens(s string) []string {
+	toks := tokenize(s)
+	seen := make(map[string]struct{}, len(toks))
+	res := toks[:0]
+	for _, tok := range toks {
+		if _, ok := seen[tok]; ok {
+			continue
+		}
+		seen[tok] = struct{}{}
+		res = append(res, tok)
+	}
+	return res
+}
+
+// intersect returns the values present in both sorted slices.
+func intersect(a, b []int) []int {
+	var res []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+func oneLine(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > 200 {
+		s = string(runes[:200]) + "…"
+	}
+	return s
+}
@@ -3,10 +3,15 @@ package bot
 import (
 	"context"
 	"encoding/json"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
+	"github.com/companyzero/bisonrelay-bot/bot/admin"
+	"github.com/companyzero/bisonrelay-bot/bot/health"
+	"github.com/companyzero/bisonrelay-bot/bot/metrics"
 	"github.com/companyzero/bisonrelay/clientrpc/jsonrpc"
 	"github.com/companyzero/bisonrelay/clientrpc/types"
 	"github.com/decred/slog"
@@ -26,6 +31,11 @@ type Config struct {
 	GCLog      slog.Logger
 	InviteChan chan<- types.ReceivedGCInvite
 
+	// GCRefreshInterval is how often the GC cache is refreshed. The cache
+	// is only kept up to date by Run when GCEventChan is set.
+	GCRefreshInterval time.Duration
+	GCEventChan       chan<- GCEvent
+
 	PMChan chan<- types.ReceivedPM
 	PMLog  slog.Logger
 
@@ -40,20 +50,83 @@ type Config struct {
 
 	KXChan chan<- types.KXCompleted
 	KXLog  slog.Logger
+
+	// ChatService, GCService, PaymentsService and PostsService replace
+	// the clients connected to URL when set. The websocket connection is
+	// only made when at least one of them is nil.
+	ChatService     types.ChatServiceClient
+	GCService       types.GCServiceClient
+	PaymentsService types.PaymentsServiceClient
+	PostsService    types.PostsServiceClient
+
+	// RecordEvents writes every inbound notification and outbound call
+	// to a compressed recording under DataDir/recordings.
+	RecordEvents bool
+
+	// MetricsAddr is the address on which Run serves /metrics in the
+	// Prometheus text format, along with the /healthz and /readyz health
+	// checks. Nothing is served when empty.
+	MetricsAddr string
+
+	// Metrics is the registry the bot metrics are added to. A new one is
+	// created when nil.
+	Metrics *metrics.Registry
+
+	// Health is the registry the bot health checks are added to. A new
+	// one is created when nil.
+	Health *health.Registry
+
+	// HealthStaleAfter is how long a liveness check may go without
+	// progress before it fails. Defaults to two minutes.
+	HealthStaleAfter time.Duration
+
+	// AdminSocket is the path of the Unix socket on which Run serves the
+	// admin API. Nothing is served when empty.
+	AdminSocket string
 }
 
 type Bot struct {
-	wsc *jsonrpc.WSClient
-	ctx context.Context
+	wsc    *jsonrpc.WSClient
+	ctx    context.Context
+	cancel context.CancelFunc
+	log    slog.Logger
+
+	recorder *Recorder
+
+	metricsReg  *metrics.Registry
+	metrics     *botMetrics
+	metricsAddr string
+
+	healthReg        *health.Registry
+	healthStaleAfter time.Duration
+	versionService   types.VersionServiceClient
+
+	admin       *admin.Server
+	adminSocket string
+
+	cursors    map[string]StreamCursor
+	cursorsMtx sync.Mutex
 
 	wl     map[string]int64
 	wlFile string
 	wlMtx  sync.Mutex
 
+	users     map[string]*User
+	usersFile string
+	usersMtx  sync.Mutex
+
 	gcLog      slog.Logger
 	gcChan     chan<- types.GCReceivedMsg
 	inviteChan chan<- types.ReceivedGCInvite
 
+	gcs         map[string]*GCInfo
+	gcsFile     string
+	gcsLoaded   bool
+	gcsMtx      sync.Mutex
+	gcsInterval time.Duration
+	gcsRefresh  chan struct{}
+	gcEventChan chan<- GCEvent
+
 	pmLog  slog.Logger
 	pmChan chan<- types.ReceivedPM
 
@@ -63,8 +136,9 @@ type Bot struct {
 	postStatusLog  slog.Logger
 	postStatusChan chan<- types.ReceivedPostStatus
 
-	tipLog  slog.Logger
-	tipChan chan<- types.TipProgressEvent
+	tipLog      slog.Logger
+	tipChan     chan<- types.TipProgressEvent
+	tipClaimers []TipClaimer
 
 	kxLog  slog.Logger
 	kxChan chan<- types.KXCompleted
@@ -91,6 +165,15 @@ func (g GCs) Swap(a, b int) {
 }
 
 func (b *Bot) Close() error {
+	b.cancel()
+	if b.recorder != nil {
+		if err := b.recorder.Close(); err != nil {
+			b.log.Errorf("Unable to close recording: %v", err)
+		}
+	}
+	if b.wsc == nil {
+		return nil
+	}
 	return b.wsc.Close()
 }
 
@@ -109,6 +192,21 @@ func (b *Bot) Run() error {
 		})
 	}
 
+	if b.gcEventChan != nil {
+		g.Go(func() error {
+			return b.gcCache(gctx)
+		})
+		g.Go(func() error {
+			return b.gcMembersAddedNtfns(gctx)
+		})
+		g.Go(func() error {
+			return b.gcMembersRemovedNtfns(gctx)
+		})
+		g.Go(func() error {
+			return b.joinedGCNtfns(gctx)
+		})
+	}
+
 	if b.pmChan != nil {
 		g.Go(func() error {
 			return b.pmNtfns(gctx)
@@ -133,26 +231,71 @@ func (b *Bot) Run() error {
 		})
 	}
 
-	if b.tipChan != nil {
+	if b.tipChan != nil || len(b.tipClaimers) > 0 {
 		g.Go(func() error {
 			return b.tipProgress(gctx)
 		})
 	}
 
+	if b.versionService != nil {
+		g.Go(func() error {
+			return b.probeClientRPC(gctx)
+		})
+	}
+
+	if b.metricsAddr != "" {
+		g.Go(func() error {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", b.metricsReg)
+			mux.Handle("/healthz", b.healthReg.HealthzHandler())
+			mux.Handle("/readyz", b.healthReg.ReadyzHandler())
+			return metrics.ListenAndServe(gctx, b.metricsAddr, mux, b.log)
+		})
+	}
+
+	if b.adminSocket != "" {
+		g.Go(func() error {
+			return b.admin.ListenAndServe(gctx, b.adminSocket)
+		})
+	}
+
 	return g.Wait()
 }
 
 func New(cfg Config) (*Bot, error) {
 	brLog := cfg.Log
 
-	wsc, err := jsonrpc.NewWSClient(
-		jsonrpc.WithWebsocketURL(cfg.URL),
-		jsonrpc.WithServerTLSCertPath(cfg.ServerCertPath),
-		jsonrpc.WithClientTLSCert(cfg.ClientCertPath, cfg.ClientKeyPath),
-		jsonrpc.WithClientLog(brLog),
-	)
-	if err != nil {
-		return nil, err
+	chatService := cfg.ChatService
+	gcService := cfg.GCService
+	paymentService := cfg.PaymentsService
+	postService := cfg.PostsService
+
+	var wsc *jsonrpc.WSClient
+	var versionService types.VersionServiceClient
+	if chatService == nil || gcService == nil || paymentService == nil || postService == nil {
+		var err error
+		wsc, err = jsonrpc.NewWSClient(
+			jsonrpc.WithWebsocketURL(cfg.URL),
+			jsonrpc.WithServerTLSCertPath(cfg.ServerCertPath),
+			jsonrpc.WithClientTLSCert(cfg.ClientCertPath, cfg.ClientKeyPath),
+			jsonrpc.WithClientLog(brLog),
+		)
+		if err != nil {
+			return nil, err
+		}
+		versionService = types.NewVersionServiceClient(wsc)
+		if chatService == nil {
+			chatService = types.NewChatServiceClient(wsc)
+		}
+		if gcService == nil {
+			gcService = types.NewGCServiceClient(wsc)
+		}
+		if paymentService == nil {
+			paymentService = types.NewPaymentsServiceClient(wsc)
+		}
+		if postService == nil {
+			postService = types.NewPostsServiceClient(wsc)
+		}
 	}
 
 	wl := make(map[string]int64)
@@ -168,22 +311,96 @@ func New(cfg Config) (*Bot, error) {
 		}
 	}
 
+	users := make(map[string]*User)
+	usersFile := filepath.Join(cfg.DataDir, "users.json")
+	usersBytes, err := os.ReadFile(usersFile)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(usersBytes, &users); err != nil {
+			return nil, err
+		}
+	}
+
+	gcs := make(map[string]*GCInfo)
+	gcsFile := filepath.Join(cfg.DataDir, "gcs.json")
+	gcsBytes, err := os.ReadFile(gcsFile)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(gcsBytes, &gcs); err != nil {
+			return nil, err
+		}
+	}
+
+	var recorder *Recorder
+	if cfg.RecordEvents {
+		recorder, err = NewRecorder(filepath.Join(cfg.DataDir, "recordings"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	metricsReg := cfg.Metrics
+	if metricsReg == nil {
+		metricsReg = metrics.NewRegistry()
+	}
+
+	healthReg := cfg.Health
+	if healthReg == nil {
+		healthReg = health.NewRegistry()
+	}
+	healthStaleAfter := cfg.HealthStaleAfter
+	if healthStaleAfter <= 0 {
+		healthStaleAfter = defaultHealthStaleAfter
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		// XXX - kill everything if websocket returns
-		err := wsc.Run(ctx)
-		brLog.Errorf("websocket run ended: %v", err)
-		cancel()
-	}()
+	if wsc != nil {
+		go func() {
+			// XXX - kill everything if websocket returns
+			err := wsc.Run(ctx)
+			brLog.Errorf("websocket run ended: %v", err)
+			cancel()
+		}()
+	}
+
+	b := &Bot{
+		wsc:    wsc,
+		ctx:    ctx,
+		cancel: cancel,
+		log:    brLog,
+
+		recorder: recorder,
+
+		metricsReg:  metricsReg,
+		metrics:     newBotMetrics(metricsReg),
+		metricsAddr: cfg.MetricsAddr,
+
+		healthReg:        healthReg,
+		healthStaleAfter: healthStaleAfter,
+		versionService:   versionService,
 
-	return &Bot{
-		wsc: wsc,
-		ctx: ctx,
+		admin:       admin.NewServer(brLog),
+		adminSocket: cfg.AdminSocket,
+
+		cursors: make(map[string]StreamCursor),
 
 		gcChan:     cfg.GCChan,
 		gcLog:      cfg.GCLog,
 		inviteChan: cfg.InviteChan,
 
+		gcs:         gcs,
+		gcsFile:     gcsFile,
+		gcsLoaded:   gcsBytes != nil,
+		gcsInterval: cfg.GCRefreshInterval,
+		gcsRefresh:  make(chan struct{}, 1),
+		gcEventChan: cfg.GCEventChan,
+
 		pmChan: cfg.PMChan,
 		pmLog:  cfg.PMLog,
 
@@ -202,9 +419,14 @@ func New(cfg Config) (*Bot, error) {
 		wl:     wl,
 		wlFile: wlFile,
 
-		chatService:    types.NewChatServiceClient(wsc),
-		gcService:      types.NewGCServiceClient(wsc),
-		paymentService: types.NewPaymentsServiceClient(wsc),
-		postService:    types.NewPostsServiceClient(wsc),
-	}, nil
+		users:     users,
+		usersFile: usersFile,
+
+		chatService:    chatService,
+		gcService:      gcService,
+		paymentService: paymentService,
+		postService:    postService,
+	}
+	b.registerAdminMethods()
+	return b, nil
 }
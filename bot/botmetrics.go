@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"github.com/companyzero/bisonrelay-bot/bot/metrics"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"google.golang.org/protobuf/proto"
+)
+
+type botMetrics struct {
+	received    *metrics.Counter
+	gcReceived  *metrics.Counter
+	sent        *metrics.Counter
+	gcSent      *metrics.Counter
+	sendErrors  *metrics.Counter
+	ackFailures *metrics.Counter
+	reconnects  *metrics.Counter
+	tips        *metrics.Counter
+	tipsDCR     *metrics.Counter
+	tipProgress *metrics.Counter
+	outbound    *metrics.Gauge
+}
+
+func newBotMetrics(r *metrics.Registry) *botMetrics {
+	return &botMetrics{
+		received: r.Counter("bot_received_total",
+			"Notifications received per stream.", "stream"),
+		gcReceived: r.Counter("bot_gc_messages_received_total",
+			"GC messages received per GC.", "gc"),
+		sent: r.Counter("bot_sent_total",
+			"Messages sent per kind.", "kind"),
+		gcSent: r.Counter("bot_gc_messages_sent_total",
+			"GC messages sent per GC.", "gc"),
+		sendErrors: r.Counter("bot_send_errors_total",
+			"Messages that failed to be sent per kind.", "kind"),
+		ackFailures: r.Counter("bot_ack_failures_total",
+			"Notifications that failed to be acked per stream.", "stream"),
+		reconnects: r.Counter("bot_stream_reconnects_total",
+			"Times a notification stream was requested again after an error.", "stream"),
+		tips: r.Counter("bot_tips_sent_total",
+			"Tip requests per outcome.", "outcome"),
+		tipsDCR: r.Counter("bot_tips_sent_dcr_total",
+			"Amount of tip requests in DCR per outcome.", "outcome"),
+		tipProgress: r.Counter("bot_tip_progress_total",
+			"Tip progress events per outcome.", "outcome"),
+		outbound: r.Gauge("bot_outbound_queue_depth",
+			"Outbound calls waiting for a reply from clientrpc.", "method"),
+	}
+}
+
+// Metrics returns the registry holding the bot metrics. Callers may register
+// their own metrics in it to have them served by the bot.
+func (b *Bot) Metrics() *metrics.Registry {
+	return b.metricsReg
+}
+
+// beginCall records an outbound call and tracks it as pending until the
+// returned function is called.
+func (b *Bot) beginCall(method string, m proto.Message) func() {
+	b.recordOut(method, m)
+	b.metrics.outbound.Inc(method)
+	return func() { b.metrics.outbound.Dec(method) }
+}
+
+func (b *Bot) countSent(kind string, err error) {
+	if err != nil {
+		b.metrics.sendErrors.Inc(kind)
+		return
+	}
+	b.metrics.sent.Inc(kind)
+}
+
+func (b *Bot) countTipProgress(e *types.TipProgressEvent) {
+	outcome := "failed_attempt"
+	if e.Completed {
+		outcome = "completed"
+	}
+	b.metrics.tipProgress.Inc(outcome)
+}
+
+func (b *Bot) countTip(amt dcrutil.Amount, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	b.metrics.tips.Inc(outcome)
+	b.metrics.tipsDCR.Add(amt.ToCoin(), outcome)
+}
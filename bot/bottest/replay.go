@@ -0,0 +1,94 @@
+package bottest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// replayEvent decodes a recorded inbound event and injects it in its stream.
+func (s *Server) replayEvent(e *bot.RecordedEvent) error {
+	var (
+		stream string
+		m      proto.Message
+		setSeq func(uint64)
+	)
+	switch e.Kind {
+	case bot.RecordPM:
+		v := new(types.ReceivedPM)
+		stream, m, setSeq = StreamPM, v, func(seq uint64) { v.SequenceId = seq }
+	case bot.RecordGCM:
+		v := new(types.GCReceivedMsg)
+		stream, m, setSeq = StreamGCM, v, func(seq uint64) { v.SequenceId = seq }
+	case bot.RecordKX:
+		v := new(types.KXCompleted)
+		stream, m, setSeq = StreamKX, v, func(seq uint64) { v.SequenceId = seq }
+	case bot.RecordGCInvite:
+		v := new(types.ReceivedGCInvite)
+		stream, m, setSeq = StreamGCInvites, v, func(seq uint64) { v.SequenceId = seq }
+	case bot.RecordMembersAdded:
+		v := new(types.GCMembersAddedEvent)
+		stream, m, setSeq = StreamMembersAdded, v, func(seq uint64) { v.SequenceId = seq }
+	case bot.RecordMembersRemoved:
+		v := new(types.GCMembersRemovedEvent)
+		stream, m, setSeq = StreamMembersRemoved, v, func(seq uint64) { v.SequenceId = seq }
+	case bot.RecordJoinedGC:
+		v := new(types.JoinedGCEvent)
+		stream, m, setSeq = StreamJoinedGCs, v, func(seq uint64) { v.SequenceId = seq }
+	case bot.RecordPost:
+		v := new(types.ReceivedPost)
+		stream, m, setSeq = StreamPosts, v, func(seq uint64) { v.SequenceId = seq }
+	case bot.RecordPostStatus:
+		v := new(types.ReceivedPostStatus)
+		stream, m, setSeq = StreamPostStatus, v, func(seq uint64) { v.SequenceId = seq }
+	case bot.RecordTipProgress:
+		v := new(types.TipProgressEvent)
+		stream, m, setSeq = StreamTipProgress, v, func(seq uint64) { v.SequenceId = seq }
+	default:
+		return fmt.Errorf("unknown recorded event kind %q", e.Kind)
+	}
+
+	if err := protojson.Unmarshal(e.Msg, m); err != nil {
+		return fmt.Errorf("unable to decode %v event: %v", e.Kind, err)
+	}
+	s.push(stream, m, setSeq)
+	return nil
+}
+
+// Replay feeds the inbound events of the recording at path to the bots
+// connected to s, while their outbound calls are captured by s as usual.
+// speed scales the delays between events: 1 replays in real time, 10 ten
+// times faster and 0 without any delay.
+//
+// The outbound calls found in the recording are returned so they can be
+// compared with the ones captured during the replay.
+func (s *Server) Replay(ctx context.Context, path string, speed float64) ([]bot.RecordedEvent, error) {
+	var (
+		outbound []bot.RecordedEvent
+		last     time.Time
+	)
+	err := bot.ReadRecording(path, func(e *bot.RecordedEvent) error {
+		if !e.In {
+			outbound = append(outbound, *e)
+			return nil
+		}
+
+		if speed > 0 && !last.IsZero() && e.Time.After(last) {
+			delay := time.Duration(float64(e.Time.Sub(last)) / speed)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		last = e.Time
+
+		return s.replayEvent(e)
+	})
+	return outbound, err
+}
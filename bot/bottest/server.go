@@ -0,0 +1,446 @@
+// Package bottest provides an in-process fake clientrpc server that bots can
+// connect to instead of a real brclient, so they can be tested offline.
+package bottest
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/clientrpc/jsonrpc"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/decred/slog"
+	"google.golang.org/protobuf/proto"
+)
+
+// Names of the streams served by the fake server, used to query acks.
+const (
+	StreamPM             = "pm"
+	StreamGCM            = "gcm"
+	StreamKX             = "kx"
+	StreamGCInvites      = "gcinvites"
+	StreamMembersAdded   = "membersadded"
+	StreamMembersRemoved = "membersremoved"
+	StreamJoinedGCs      = "joinedgcs"
+	StreamPosts          = "posts"
+	StreamPostStatus     = "poststatus"
+	StreamTipProgress    = "tipprogress"
+)
+
+// queue holds the events injected into a stream until they are acked.
+type queue struct {
+	seq    uint64
+	acked  uint64
+	events []proto.Message
+	seqs   []uint64
+}
+
+// Server is a fake clientrpc server. Events injected with the Inject*
+// methods are streamed to connected clients and kept until acked, and every
+// request that would have reached the network is recorded.
+type Server struct {
+	// URL is the websocket URL clients should connect to.
+	URL string
+
+	listener net.Listener
+	cancel   context.CancelFunc
+	runErr   chan error
+
+	mtx     sync.Mutex
+	changed chan struct{}
+	queues  map[string]*queue
+
+	pms           []*types.PMRequest
+	gcms          []*types.GCMRequest
+	tips          []*types.TipUserRequest
+	files         []*types.SendFileRequest
+	mediateKXs    []*types.MediateKXRequest
+	gcInvites     []*types.InviteToGCRequest
+	acceptedGCs   []uint64
+	kicks         []*types.KickFromGCRequest
+	subscriptions []string
+	gcs           []*types.ListGCsResponse_GCInfo
+	gcMembers     map[string][][]byte
+	tipUserErr    error
+	ackFailures   map[string]int
+}
+
+// NewServer starts a fake server listening on a random localhost port.
+func NewServer(log slog.Logger) (*Server, error) {
+	if log == nil {
+		log = slog.Disabled
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		URL:      "ws://" + l.Addr().String() + "/ws",
+		listener: l,
+		runErr:   make(chan error, 1),
+		changed:  make(chan struct{}),
+		queues:   make(map[string]*queue),
+
+		gcMembers:   make(map[string][][]byte),
+		ackFailures: make(map[string]int),
+	}
+
+	services := &types.ServersMap{}
+	services.Bind("VersionService", types.VersionServiceDefn(), versionService{})
+	services.Bind("ChatService", types.ChatServiceDefn(), &chatService{s: s})
+	services.Bind("GCService", types.GCServiceDefn(), &gcService{s: s})
+	services.Bind("PaymentsService", types.PaymentsServiceDefn(), &paymentsService{s: s})
+	services.Bind("PostsService", types.PostsServiceDefn(), &postsService{s: s})
+
+	srv := jsonrpc.NewServer(
+		jsonrpc.WithServices(services),
+		jsonrpc.WithListeners([]net.Listener{l}),
+		jsonrpc.WithServerLog(log),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go func() { s.runErr <- srv.Run(ctx) }()
+
+	return s, nil
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	s.cancel()
+	err := <-s.runErr
+	if err == context.Canceled {
+		err = nil
+	}
+	return err
+}
+
+// BotConfig returns a bot config that connects to the fake server.
+func (s *Server) BotConfig(dataDir string) bot.Config {
+	return bot.Config{
+		DataDir: dataDir,
+		Log:     slog.Disabled,
+		URL:     s.URL,
+
+		GCLog:         slog.Disabled,
+		PMLog:         slog.Disabled,
+		PostLog:       slog.Disabled,
+		PostStatusLog: slog.Disabled,
+		TipLog:        slog.Disabled,
+		KXLog:         slog.Disabled,
+	}
+}
+
+// notify wakes up every goroutine waiting on a change. Must be called with
+// the mutex held.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// push adds an event to a stream and returns its sequence id.
+func (s *Server) push(stream string, m proto.Message, setSeq func(uint64)) uint64 {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	q := s.queues[stream]
+	if q == nil {
+		q = &queue{}
+		s.queues[stream] = q
+	}
+	q.seq++
+	setSeq(q.seq)
+	q.events = append(q.events, m)
+	q.seqs = append(q.seqs, q.seq)
+	s.notify()
+	return q.seq
+}
+
+// ack drops the events of a stream up to seq. It fails without dropping
+// anything while acks of the stream are set to fail by FailAcks.
+func (s *Server) ack(stream string, seq uint64) error {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	if s.ackFailures[stream] > 0 {
+		s.ackFailures[stream]--
+		return errors.New("ack failed")
+	}
+
+	q := s.queues[stream]
+	if q == nil {
+		q = &queue{}
+		s.queues[stream] = q
+	}
+	if seq > q.acked {
+		q.acked = seq
+	}
+	i := 0
+	for i < len(q.seqs) && q.seqs[i] <= q.acked {
+		i++
+	}
+	q.events = q.events[i:]
+	q.seqs = q.seqs[i:]
+	s.notify()
+	return nil
+}
+
+// serveStream sends the unacked events of a stream after unackedFrom until
+// ctx is done.
+func (s *Server) serveStream(ctx context.Context, stream string, unackedFrom uint64,
+	send func(proto.Message) error) error {
+
+	sent := unackedFrom
+	for {
+		s.mtx.Lock()
+		var pending []proto.Message
+		if q := s.queues[stream]; q != nil {
+			for i, seq := range q.seqs {
+				if seq > sent {
+					pending = append(pending, q.events[i])
+					sent = seq
+				}
+			}
+		}
+		changed := s.changed
+		s.mtx.Unlock()
+
+		for _, m := range pending {
+			if err := send(m); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// record runs f with the mutex held and notifies waiters.
+func (s *Server) record(f func()) {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	f()
+	s.notify()
+}
+
+// WaitFor blocks until cond returns true or ctx is done. cond is called again
+// every time the server state changes and may use the other Server methods.
+func (s *Server) WaitFor(ctx context.Context, cond func() bool) error {
+	for {
+		s.mtx.Lock()
+		changed := s.changed
+		s.mtx.Unlock()
+
+		if cond() {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Acked returns the last sequence id acked by the client on a stream.
+func (s *Server) Acked(stream string) uint64 {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	if q := s.queues[stream]; q != nil {
+		return q.acked
+	}
+	return 0
+}
+
+// Pending returns the number of events of a stream not yet acked.
+func (s *Server) Pending(stream string) int {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	if q := s.queues[stream]; q != nil {
+		return len(q.events)
+	}
+	return 0
+}
+
+// WaitAcked blocks until every event injected in stream is acked.
+func (s *Server) WaitAcked(ctx context.Context, stream string) error {
+	return s.WaitFor(ctx, func() bool { return s.Pending(stream) == 0 })
+}
+
+// SetGCs sets the GCs returned by GCService.List.
+func (s *Server) SetGCs(gcs []*types.ListGCsResponse_GCInfo) {
+	s.record(func() { s.gcs = gcs })
+}
+
+// SetGCMembers sets the members returned by GCService.GetGC for the GC with
+// the given id, which must also be set with SetGCs.
+func (s *Server) SetGCMembers(id []byte, members ...[]byte) {
+	s.record(func() { s.gcMembers[hex.EncodeToString(id)] = members })
+}
+
+// FailAcks makes the next n acks of stream fail.
+func (s *Server) FailAcks(stream string, n int) {
+	s.record(func() { s.ackFailures[stream] = n })
+}
+
+// SetTipUserErr makes TipUser calls fail with err. A nil err makes them
+// succeed again.
+func (s *Server) SetTipUserErr(err error) {
+	s.record(func() { s.tipUserErr = err })
+}
+
+// InjectPM queues a PM to the bot from the given user.
+func (s *Server) InjectPM(uid []byte, nick, msg string) uint64 {
+	m := &types.ReceivedPM{
+		Uid:  uid,
+		Nick: nick,
+		Msg:  &types.RMPrivateMessage{Message: msg},
+	}
+	return s.push(StreamPM, m, func(seq uint64) { m.SequenceId = seq })
+}
+
+// InjectGCM queues a GC message from the given user.
+func (s *Server) InjectGCM(gc string, uid []byte, nick, msg string) uint64 {
+	m := &types.GCReceivedMsg{
+		Uid:     uid,
+		Nick:    nick,
+		GcAlias: gc,
+		Msg:     &types.RMGroupMessage{Message: msg},
+	}
+	return s.push(StreamGCM, m, func(seq uint64) { m.SequenceId = seq })
+}
+
+// InjectKX queues a completed KX with the given user.
+func (s *Server) InjectKX(uid []byte, nick string) uint64 {
+	m := &types.KXCompleted{Uid: uid, Nick: nick}
+	return s.push(StreamKX, m, func(seq uint64) { m.SequenceId = seq })
+}
+
+// InjectGCInvite queues a GC invite.
+func (s *Server) InjectGCInvite(m *types.ReceivedGCInvite) uint64 {
+	return s.push(StreamGCInvites, m, func(seq uint64) { m.SequenceId = seq })
+}
+
+// InjectMembersAdded queues a GC members added event.
+func (s *Server) InjectMembersAdded(m *types.GCMembersAddedEvent) uint64 {
+	return s.push(StreamMembersAdded, m, func(seq uint64) { m.SequenceId = seq })
+}
+
+// InjectMembersRemoved queues a GC members removed event.
+func (s *Server) InjectMembersRemoved(m *types.GCMembersRemovedEvent) uint64 {
+	return s.push(StreamMembersRemoved, m, func(seq uint64) { m.SequenceId = seq })
+}
+
+// InjectJoinedGC queues a joined GC event.
+func (s *Server) InjectJoinedGC(m *types.JoinedGCEvent) uint64 {
+	return s.push(StreamJoinedGCs, m, func(seq uint64) { m.SequenceId = seq })
+}
+
+// InjectPost queues a received post.
+func (s *Server) InjectPost(m *types.ReceivedPost) uint64 {
+	return s.push(StreamPosts, m, func(seq uint64) { m.SequenceId = seq })
+}
+
+// InjectPostStatus queues a received post status update.
+func (s *Server) InjectPostStatus(m *types.ReceivedPostStatus) uint64 {
+	return s.push(StreamPostStatus, m, func(seq uint64) { m.SequenceId = seq })
+}
+
+// InjectTipProgress queues a tip progress event.
+func (s *Server) InjectTipProgress(m *types.TipProgressEvent) uint64 {
+	return s.push(StreamTipProgress, m, func(seq uint64) { m.SequenceId = seq })
+}
+
+// PMs returns the PMs sent by the bot.
+func (s *Server) PMs() []*types.PMRequest {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	return append([]*types.PMRequest(nil), s.pms...)
+}
+
+// GCMs returns the GC messages sent by the bot.
+func (s *Server) GCMs() []*types.GCMRequest {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	return append([]*types.GCMRequest(nil), s.gcms...)
+}
+
+// Tips returns the tips sent by the bot.
+func (s *Server) Tips() []*types.TipUserRequest {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	return append([]*types.TipUserRequest(nil), s.tips...)
+}
+
+// Files returns the files sent by the bot.
+func (s *Server) Files() []*types.SendFileRequest {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	return append([]*types.SendFileRequest(nil), s.files...)
+}
+
+// MediateKXs returns the mediated KX requests made by the bot.
+func (s *Server) MediateKXs() []*types.MediateKXRequest {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	return append([]*types.MediateKXRequest(nil), s.mediateKXs...)
+}
+
+// GCInvites returns the GC invites sent by the bot.
+func (s *Server) GCInvites() []*types.InviteToGCRequest {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	return append([]*types.InviteToGCRequest(nil), s.gcInvites...)
+}
+
+// AcceptedGCInvites returns the ids of the GC invites accepted by the bot.
+func (s *Server) AcceptedGCInvites() []uint64 {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	return append([]uint64(nil), s.acceptedGCs...)
+}
+
+// Kicks returns the kicks requested by the bot.
+func (s *Server) Kicks() []*types.KickFromGCRequest {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	return append([]*types.KickFromGCRequest(nil), s.kicks...)
+}
+
+// Subscriptions returns the users whose posts the bot subscribed to.
+func (s *Server) Subscriptions() []string {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+	return append([]string(nil), s.subscriptions...)
+}
+
+// WaitPMs blocks until the bot sent at least n PMs and returns them.
+func (s *Server) WaitPMs(ctx context.Context, n int) ([]*types.PMRequest, error) {
+	var res []*types.PMRequest
+	err := s.WaitFor(ctx, func() bool {
+		res = s.PMs()
+		return len(res) >= n
+	})
+	return res, err
+}
+
+// WaitGCMs blocks until the bot sent at least n GC messages and returns
+// them.
+func (s *Server) WaitGCMs(ctx context.Context, n int) ([]*types.GCMRequest, error) {
+	var res []*types.GCMRequest
+	err := s.WaitFor(ctx, func() bool {
+		res = s.GCMs()
+		return len(res) >= n
+	})
+	return res, err
+}
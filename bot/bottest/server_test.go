@@ -0,0 +1,96 @@
+package bottest_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+func TestServer(t *testing.T) {
+	pmChan := make(chan types.ReceivedPM, 1)
+	ctx, srv, b := bottest.NewBot(t, func(cfg *bot.Config) { cfg.PMChan = pmChan })
+	go b.Run()
+
+	var alice zkidentity.ShortID
+	alice[0] = 1
+
+	// Inbound events reach the bot and are acked. The received PM is not
+	// inspected as it cannot be copied out of the channel.
+	seq := srv.InjectPM(alice[:], "alice", "hello")
+	select {
+	case <-pmChan:
+	case <-ctx.Done():
+		t.Fatal("pm not received")
+	}
+	if err := srv.WaitAcked(ctx, bottest.StreamPM); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Acked(bottest.StreamPM); got != seq {
+		t.Fatalf("acked %d, want %d", got, seq)
+	}
+	if id, err := b.ResolveUser(bot.UserRefNick("alice")); err != nil || id != alice {
+		t.Fatalf("alice resolved to %v, %v", id, err)
+	}
+
+	// Outbound calls are captured.
+	if err := b.SendPM(ctx, alice.String(), "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SendGC(ctx, "dev", "hi all"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.PayTip(ctx, alice, dcrutil.Amount(1e6), 1); err != nil {
+		t.Fatal(err)
+	}
+	pms := srv.PMs()
+	if len(pms) != 1 || pms[0].User != alice.String() || pms[0].Msg.Message != "hi" {
+		t.Fatalf("unexpected pms %v", pms)
+	}
+	gcms := srv.GCMs()
+	if len(gcms) != 1 || gcms[0].Gc != "dev" || gcms[0].Msg != "hi all" {
+		t.Fatalf("unexpected gc messages %v", gcms)
+	}
+	tips := srv.Tips()
+	if len(tips) != 1 || tips[0].User != alice.String() || tips[0].DcrAmount != 0.01 {
+		t.Fatalf("unexpected tips %v", tips)
+	}
+
+	srv.SetTipUserErr(errors.New("no route to user"))
+	if err := b.PayTip(ctx, alice, dcrutil.Amount(1e6), 1); err == nil {
+		t.Fatal("tip did not fail")
+	}
+	srv.SetTipUserErr(nil)
+	if got := len(srv.Tips()); got != 1 {
+		t.Fatalf("%d tips recorded, want 1", got)
+	}
+}
+
+func TestGCMembers(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+
+	var alice, bob zkidentity.ShortID
+	alice[0], bob[0] = 1, 2
+	gcID := bytes.Repeat([]byte{2}, 32)
+	srv.SetGCs([]*types.ListGCsResponse_GCInfo{{Id: gcID, Name: "dev", NbMembers: 1}})
+	srv.SetGCMembers(gcID, alice[:])
+
+	gc, err := b.FindGC(ctx, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.IsGCMember(ctx, gc.ID, alice); err != nil || !ok {
+		t.Fatalf("alice is not a member: %v", err)
+	}
+	if ok, err := b.IsGCMember(ctx, "dev", bob); err != nil || ok {
+		t.Fatalf("bob is a member: %v", err)
+	}
+	if _, err := b.IsGCMember(ctx, "unknown", bob); err == nil {
+		t.Fatal("unknown gc did not fail")
+	}
+}
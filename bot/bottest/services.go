@@ -0,0 +1,249 @@
+package bottest
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"google.golang.org/protobuf/proto"
+)
+
+type versionService struct{}
+
+var _ types.VersionServiceServer = versionService{}
+
+func (versionService) Version(_ context.Context, _ *types.VersionRequest, res *types.VersionResponse) error {
+	res.AppName = "bottest"
+	res.AppVersion = "0.0.0"
+	return nil
+}
+
+func (versionService) KeepaliveStream(ctx context.Context, _ *types.KeepaliveStreamRequest, _ types.VersionService_KeepaliveStreamServer) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type chatService struct {
+	s *Server
+}
+
+var _ types.ChatServiceServer = (*chatService)(nil)
+
+func (c *chatService) PM(_ context.Context, req *types.PMRequest, _ *types.PMResponse) error {
+	c.s.record(func() { c.s.pms = append(c.s.pms, req) })
+	return nil
+}
+
+func (c *chatService) PMStream(ctx context.Context, req *types.PMStreamRequest, stream types.ChatService_PMStreamServer) error {
+	return c.s.serveStream(ctx, StreamPM, req.UnackedFrom, func(m proto.Message) error {
+		return stream.Send(m.(*types.ReceivedPM))
+	})
+}
+
+func (c *chatService) AckReceivedPM(_ context.Context, req *types.AckRequest, _ *types.AckResponse) error {
+	return c.s.ack(StreamPM, req.SequenceId)
+}
+
+func (c *chatService) GCM(_ context.Context, req *types.GCMRequest, _ *types.GCMResponse) error {
+	c.s.record(func() { c.s.gcms = append(c.s.gcms, req) })
+	return nil
+}
+
+func (c *chatService) GCMStream(ctx context.Context, req *types.GCMStreamRequest, stream types.ChatService_GCMStreamServer) error {
+	return c.s.serveStream(ctx, StreamGCM, req.UnackedFrom, func(m proto.Message) error {
+		return stream.Send(m.(*types.GCReceivedMsg))
+	})
+}
+
+func (c *chatService) AckReceivedGCM(_ context.Context, req *types.AckRequest, _ *types.AckResponse) error {
+	return c.s.ack(StreamGCM, req.SequenceId)
+}
+
+func (c *chatService) MediateKX(_ context.Context, req *types.MediateKXRequest, _ *types.MediateKXResponse) error {
+	c.s.record(func() { c.s.mediateKXs = append(c.s.mediateKXs, req) })
+	return nil
+}
+
+func (c *chatService) KXStream(ctx context.Context, req *types.KXStreamRequest, stream types.ChatService_KXStreamServer) error {
+	return c.s.serveStream(ctx, StreamKX, req.UnackedFrom, func(m proto.Message) error {
+		return stream.Send(m.(*types.KXCompleted))
+	})
+}
+
+func (c *chatService) AckKXCompleted(_ context.Context, req *types.AckRequest, _ *types.AckResponse) error {
+	return c.s.ack(StreamKX, req.SequenceId)
+}
+
+func (c *chatService) WriteNewInvite(_ context.Context, req *types.WriteNewInviteRequest, res *types.WriteNewInviteResponse) error {
+	res.InviteBytes = []byte("invite")
+	res.InviteKey = "invite-key"
+	return nil
+}
+
+func (c *chatService) AcceptInvite(context.Context, *types.AcceptInviteRequest, *types.AcceptInviteResponse) error {
+	return nil
+}
+
+func (c *chatService) SendFile(_ context.Context, req *types.SendFileRequest, _ *types.SendFileResponse) error {
+	c.s.record(func() { c.s.files = append(c.s.files, req) })
+	return nil
+}
+
+type gcService struct {
+	s *Server
+}
+
+var _ types.GCServiceServer = (*gcService)(nil)
+
+func (g *gcService) InviteToGC(_ context.Context, req *types.InviteToGCRequest, _ *types.InviteToGCResponse) error {
+	g.s.record(func() { g.s.gcInvites = append(g.s.gcInvites, req) })
+	return nil
+}
+
+func (g *gcService) AcceptGCInvite(_ context.Context, req *types.AcceptGCInviteRequest, _ *types.AcceptGCInviteResponse) error {
+	g.s.record(func() { g.s.acceptedGCs = append(g.s.acceptedGCs, req.InviteId) })
+	return nil
+}
+
+func (g *gcService) KickFromGC(_ context.Context, req *types.KickFromGCRequest, _ *types.KickFromGCResponse) error {
+	g.s.record(func() { g.s.kicks = append(g.s.kicks, req) })
+	return nil
+}
+
+func (g *gcService) GetGC(_ context.Context, req *types.GetGCRequest, res *types.GetGCResponse) error {
+	defer g.s.mtx.Unlock()
+	g.s.mtx.Lock()
+	for _, gc := range g.s.gcs {
+		id := hex.EncodeToString(gc.Id)
+		if id != req.Gc && gc.Name != req.Gc {
+			continue
+		}
+		res.Gc = &types.RMGroupList{
+			Id:      gc.Id,
+			Name:    gc.Name,
+			Members: g.s.gcMembers[id],
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown gc %q", req.Gc)
+}
+
+func (g *gcService) List(_ context.Context, _ *types.ListGCsRequest, res *types.ListGCsResponse) error {
+	defer g.s.mtx.Unlock()
+	g.s.mtx.Lock()
+	for _, gc := range g.s.gcs {
+		res.Gcs = append(res.Gcs, proto.Clone(gc).(*types.ListGCsResponse_GCInfo))
+	}
+	return nil
+}
+
+func (g *gcService) ReceivedGCInvites(ctx context.Context, req *types.ReceivedGCInvitesRequest, stream types.GCService_ReceivedGCInvitesServer) error {
+	return g.s.serveStream(ctx, StreamGCInvites, req.UnackedFrom, func(m proto.Message) error {
+		return stream.Send(m.(*types.ReceivedGCInvite))
+	})
+}
+
+func (g *gcService) AckReceivedGCInvites(_ context.Context, req *types.AckRequest, _ *types.AckResponse) error {
+	return g.s.ack(StreamGCInvites, req.SequenceId)
+}
+
+func (g *gcService) MembersAdded(ctx context.Context, req *types.GCMembersAddedRequest, stream types.GCService_MembersAddedServer) error {
+	return g.s.serveStream(ctx, StreamMembersAdded, req.UnackedFrom, func(m proto.Message) error {
+		return stream.Send(m.(*types.GCMembersAddedEvent))
+	})
+}
+
+func (g *gcService) AckMembersAdded(_ context.Context, req *types.AckRequest, _ *types.AckResponse) error {
+	return g.s.ack(StreamMembersAdded, req.SequenceId)
+}
+
+func (g *gcService) MembersRemoved(ctx context.Context, req *types.GCMembersRemovedRequest, stream types.GCService_MembersRemovedServer) error {
+	return g.s.serveStream(ctx, StreamMembersRemoved, req.UnackedFrom, func(m proto.Message) error {
+		return stream.Send(m.(*types.GCMembersRemovedEvent))
+	})
+}
+
+func (g *gcService) AckMembersRemoved(_ context.Context, req *types.AckRequest, _ *types.AckResponse) error {
+	return g.s.ack(StreamMembersRemoved, req.SequenceId)
+}
+
+func (g *gcService) JoinedGCs(ctx context.Context, req *types.JoinedGCsRequest, stream types.GCService_JoinedGCsServer) error {
+	return g.s.serveStream(ctx, StreamJoinedGCs, req.UnackedFrom, func(m proto.Message) error {
+		return stream.Send(m.(*types.JoinedGCEvent))
+	})
+}
+
+func (g *gcService) AckJoinedGCs(_ context.Context, req *types.AckRequest, _ *types.AckResponse) error {
+	return g.s.ack(StreamJoinedGCs, req.SequenceId)
+}
+
+type paymentsService struct {
+	s *Server
+}
+
+var _ types.PaymentsServiceServer = (*paymentsService)(nil)
+
+func (p *paymentsService) TipUser(_ context.Context, req *types.TipUserRequest, _ *types.TipUserResponse) error {
+	var err error
+	p.s.record(func() {
+		err = p.s.tipUserErr
+		if err == nil {
+			p.s.tips = append(p.s.tips, req)
+		}
+	})
+	return err
+}
+
+func (p *paymentsService) TipProgress(ctx context.Context, req *types.TipProgressRequest, stream types.PaymentsService_TipProgressServer) error {
+	return p.s.serveStream(ctx, StreamTipProgress, req.UnackedFrom, func(m proto.Message) error {
+		return stream.Send(m.(*types.TipProgressEvent))
+	})
+}
+
+func (p *paymentsService) AckTipProgress(_ context.Context, req *types.AckRequest, _ *types.AckResponse) error {
+	return p.s.ack(StreamTipProgress, req.SequenceId)
+}
+
+type postsService struct {
+	s *Server
+}
+
+var _ types.PostsServiceServer = (*postsService)(nil)
+
+func (p *postsService) SubscribeToPosts(_ context.Context, req *types.SubscribeToPostsRequest, _ *types.SubscribeToPostsResponse) error {
+	p.s.record(func() { p.s.subscriptions = append(p.s.subscriptions, req.User) })
+	return nil
+}
+
+func (p *postsService) UnsubscribeToPosts(_ context.Context, req *types.UnsubscribeToPostsRequest, _ *types.UnsubscribeToPostsResponse) error {
+	p.s.record(func() {
+		for i, u := range p.s.subscriptions {
+			if u == req.User {
+				p.s.subscriptions = append(p.s.subscriptions[:i], p.s.subscriptions[i+1:]...)
+				break
+			}
+		}
+	})
+	return nil
+}
+
+func (p *postsService) PostsStream(ctx context.Context, req *types.PostsStreamRequest, stream types.PostsService_PostsStreamServer) error {
+	return p.s.serveStream(ctx, StreamPosts, req.UnackedFrom, func(m proto.Message) error {
+		return stream.Send(m.(*types.ReceivedPost))
+	})
+}
+
+func (p *postsService) AckReceivedPost(_ context.Context, req *types.AckRequest, _ *types.AckResponse) error {
+	return p.s.ack(StreamPosts, req.SequenceId)
+}
+
+func (p *postsService) PostsStatusStream(ctx context.Context, req *types.PostsStatusStreamRequest, stream types.PostsService_PostsStatusStreamServer) error {
+	return p.s.serveStream(ctx, StreamPostStatus, req.UnackedFrom, func(m proto.Message) error {
+		return stream.Send(m.(*types.ReceivedPostStatus))
+	})
+}
+
+func (p *postsService) AckReceivedPostStatus(_ context.Context, req *types.AckRequest, _ *types.AckResponse) error {
+	return p.s.ack(StreamPostStatus, req.SequenceId)
+}
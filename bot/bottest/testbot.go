@@ -0,0 +1,35 @@
+package bottest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+)
+
+// NewBot starts a fake server and a bot connected to it, both closed when
+// the test ends. opts may change the bot config before the bot is created.
+// The returned context is canceled when the test ends.
+func NewBot(t testing.TB, opts ...func(*bot.Config)) (context.Context, *Server, *bot.Bot) {
+	t.Helper()
+	srv, err := NewServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	cfg := srv.BotConfig(t.TempDir())
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	b, err := bot.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	return ctx, srv, b
+}
@@ -0,0 +1,541 @@
+package bounty
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/slog"
+)
+
+// Status is the state of a bounty.
+type Status string
+
+const (
+	StatusOpen     Status = "open"
+	StatusClaimed  Status = "claimed"
+	StatusInReview Status = "in review"
+	StatusPaid     Status = "paid"
+	StatusExpired  Status = "expired"
+	StatusCanceled Status = "canceled"
+)
+
+const (
+	checkInterval      = time.Minute
+	defaultClaimTTL    = 72 * time.Hour
+	defaultMaxAttempts = 3
+)
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot bot.API
+
+	// ClaimTTL is how long a claim is held without the work being
+	// submitted for review before the bounty is released.
+	ClaimTTL time.Duration
+
+	// MaxAttempts is the number of attempts to pay a claimant.
+	MaxAttempts int32
+}
+
+// Bounty is a paid task on the board of a GC.
+type Bounty struct {
+	ID       int            `json:"id"`
+	GC       string         `json:"gc"`
+	Title    string         `json:"title"`
+	Amount   dcrutil.Amount `json:"amount"`
+	Creator  string         `json:"creator"`
+	Deadline time.Time      `json:"deadline"`
+	Status   Status         `json:"status"`
+
+	Claimant     string    `json:"claimant,omitempty"`
+	ClaimantNick string    `json:"claimant_nick,omitempty"`
+	ClaimedAt    time.Time `json:"claimed_at,omitempty"`
+
+	// PayoutPending is set from approval until the tip to the claimant
+	// completes or fails for good. PayoutSentAt is when the tip was
+	// requested and tells the tip progress events of the tips sent by the
+	// board apart from the ones sent by other modules.
+	PayoutPending bool      `json:"payout_pending,omitempty"`
+	PayoutSentAt  time.Time `json:"payout_sent_at,omitempty"`
+	PayoutErr     string    `json:"payout_err,omitempty"`
+
+	// Approver is the admin who approved the payout and is told when it
+	// fails.
+	Approver string `json:"approver,omitempty"`
+}
+
+type state struct {
+	NextID   int             `json:"next_id"`
+	Bounties map[int]*Bounty `json:"bounties"`
+}
+
+// Board keeps the bounties of every GC.
+type Board struct {
+	bot         bot.API
+	log         slog.Logger
+	file        string
+	claimTTL    time.Duration
+	maxAttempts int32
+
+	mtx   sync.Mutex
+	state state
+}
+
+func New(cfg Config) (*Board, error) {
+	claimTTL := cfg.ClaimTTL
+	if claimTTL <= 0 {
+		claimTTL = defaultClaimTTL
+	}
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	b := &Board{
+		bot:         cfg.Bot,
+		log:         cfg.Log,
+		file:        filepath.Join(cfg.DataDir, "bounties.json"),
+		claimTTL:    claimTTL,
+		maxAttempts: maxAttempts,
+		state: state{
+			NextID:   1,
+			Bounties: make(map[int]*Bounty),
+		},
+	}
+
+	raw, err := os.ReadFile(b.file)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(raw, &b.state); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// HandleCommand handles the !bounty command sent to a GC:
+//
+//	!bounty list
+//	!bounty add <amount in DCR> <time to deadline> <title>   (admins)
+//	!bounty claim <id>
+//	!bounty release <id>
+//	!bounty submit <id>
+//	!bounty approve <id>                                     (admins)
+//	!bounty reject <id>                                      (admins)
+//	!bounty cancel <id>                                      (admins)
+func (b *Board) HandleCommand(ctx context.Context, gc string, uid zkidentity.ShortID, nick string, args []string) error {
+	reply := func(f string, a ...interface{}) error {
+		return b.bot.SendGC(ctx, gc, fmt.Sprintf(f, a...))
+	}
+	if len(args) == 0 || strings.EqualFold(args[0], "list") {
+		return reply("%s", b.List(gc))
+	}
+
+	isAdmin := b.bot.IsWhitelisted(uid)
+	cmd := strings.ToLower(args[0])
+	if cmd == "add" {
+		if !isAdmin {
+			return reply("only admins can add bounties")
+		}
+		if len(args) < 4 {
+			return reply("usage: !bounty add <amount in DCR> <time to deadline> <title>")
+		}
+		bounty, err := b.add(gc, uid.String(), args[1], args[2], strings.Join(args[3:], " "))
+		if err != nil {
+			return reply("%v", err)
+		}
+		return reply("Bounty %d: %v for %q, deadline %v", bounty.ID,
+			bounty.Amount, bounty.Title,
+			bounty.Deadline.UTC().Format("2006-01-02 15:04 MST"))
+	}
+
+	if len(args) < 2 {
+		return reply("usage: !bounty %v <id>", cmd)
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		return reply("invalid bounty id %q", args[1])
+	}
+
+	var (
+		msg    string
+		payout *Bounty
+	)
+	err = b.update(gc, id, func(bounty *Bounty) error {
+		switch cmd {
+		case "claim":
+			if bounty.Status != StatusOpen {
+				return fmt.Errorf("bounty %d is %v", id, bounty.Status)
+			}
+			bounty.Status = StatusClaimed
+			bounty.Claimant, bounty.ClaimantNick = uid.String(), nick
+			bounty.ClaimedAt = time.Now()
+			msg = fmt.Sprintf("%v claimed bounty %d", nick, id)
+
+		case "release":
+			if bounty.Status != StatusClaimed ||
+				(bounty.Claimant != uid.String() && !isAdmin) {
+				return fmt.Errorf("bounty %d is not claimed by you", id)
+			}
+			bounty.release()
+			msg = fmt.Sprintf("bounty %d is open again", id)
+
+		case "submit":
+			if bounty.Status != StatusClaimed || bounty.Claimant != uid.String() {
+				return fmt.Errorf("bounty %d is not claimed by you", id)
+			}
+			bounty.Status = StatusInReview
+			msg = fmt.Sprintf("bounty %d submitted for review", id)
+
+		case "approve":
+			if !isAdmin {
+				return fmt.Errorf("only admins can approve bounties")
+			}
+			if bounty.Status != StatusInReview || bounty.PayoutPending {
+				return fmt.Errorf("bounty %d is not awaiting review", id)
+			}
+			bounty.PayoutPending = true
+			bounty.PayoutSentAt = time.Now()
+			bounty.PayoutErr = ""
+			bounty.Approver = uid.String()
+			payout = bounty
+			msg = fmt.Sprintf("bounty %d approved, paying %v to %v", id,
+				bounty.Amount, bounty.ClaimantNick)
+
+		case "reject":
+			if !isAdmin {
+				return fmt.Errorf("only admins can reject bounties")
+			}
+			if bounty.Status != StatusInReview || bounty.PayoutPending {
+				return fmt.Errorf("bounty %d is not awaiting review", id)
+			}
+			bounty.Status = StatusClaimed
+			bounty.ClaimedAt = time.Now()
+			msg = fmt.Sprintf("bounty %d needs more work", id)
+
+		case "cancel":
+			if !isAdmin {
+				return fmt.Errorf("only admins can cancel bounties")
+			}
+			if bounty.Status == StatusPaid || bounty.PayoutPending {
+				return fmt.Errorf("bounty %d is already being paid", id)
+			}
+			bounty.Status = StatusCanceled
+			msg = fmt.Sprintf("bounty %d canceled", id)
+
+		default:
+			return fmt.Errorf("unknown bounty command %q", cmd)
+		}
+		return nil
+	})
+	if err != nil {
+		return reply("%v", err)
+	}
+	if err := reply("%s", msg); err != nil {
+		return err
+	}
+	if payout != nil {
+		b.pay(ctx, payout.ID, payout.Claimant, payout.Amount)
+	}
+	return nil
+}
+
+// List returns the active bounties of a GC.
+func (b *Board) List(gc string) string {
+	defer b.mtx.Unlock()
+	b.mtx.Lock()
+
+	var bounties []*Bounty
+	for _, bounty := range b.state.Bounties {
+		if !strings.EqualFold(bounty.GC, gc) {
+			continue
+		}
+		switch bounty.Status {
+		case StatusOpen, StatusClaimed, StatusInReview:
+			bounties = append(bounties, bounty)
+		}
+	}
+	if len(bounties) == 0 {
+		return "no active bounties"
+	}
+	sort.Slice(bounties, func(i, j int) bool { return bounties[i].ID < bounties[j].ID })
+
+	var sb strings.Builder
+	for _, bounty := range bounties {
+		fmt.Fprintf(&sb, "%d) %v - %v [%v", bounty.ID, bounty.Title,
+			bounty.Amount, bounty.Status)
+		if bounty.ClaimantNick != "" && bounty.Status != StatusOpen {
+			fmt.Fprintf(&sb, " by %v", bounty.ClaimantNick)
+		}
+		fmt.Fprintf(&sb, "] due %v\n", bounty.Deadline.UTC().Format("2006-01-02"))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (b *Board) add(gc, creator, amountStr, durStr, title string) (*Bounty, error) {
+	dcr, err := strconv.ParseFloat(amountStr, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount %q", amountStr)
+	}
+	amount, err := dcrutil.NewAmount(dcr)
+	if err != nil || amount <= 0 {
+		return nil, fmt.Errorf("invalid amount %q", amountStr)
+	}
+	d, err := time.ParseDuration(durStr)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid deadline %q", durStr)
+	}
+
+	defer b.mtx.Unlock()
+	b.mtx.Lock()
+
+	bounty := &Bounty{
+		ID:       b.state.NextID,
+		GC:       gc,
+		Title:    title,
+		Amount:   amount,
+		Creator:  creator,
+		Deadline: time.Now().Add(d),
+		Status:   StatusOpen,
+	}
+	b.state.NextID++
+	b.state.Bounties[bounty.ID] = bounty
+	return bounty, b.save()
+}
+
+// update applies fn to a bounty of the GC and saves the board if fn
+// succeeds.
+func (b *Board) update(gc string, id int, fn func(*Bounty) error) error {
+	defer b.mtx.Unlock()
+	b.mtx.Lock()
+
+	bounty, ok := b.state.Bounties[id]
+	if !ok || !strings.EqualFold(bounty.GC, gc) {
+		return fmt.Errorf("unknown bounty %d", id)
+	}
+	updated := *bounty
+	if err := fn(&updated); err != nil {
+		return err
+	}
+	*bounty = updated
+	return b.save()
+}
+
+func (b *Board) pay(ctx context.Context, id int, claimant string, amount dcrutil.Amount) {
+	var uid zkidentity.ShortID
+	err := uid.FromString(claimant)
+	if err == nil {
+		err = b.bot.PayTip(ctx, uid, amount, b.maxAttempts)
+	}
+	if err == nil {
+		b.log.Infof("paying bounty %d: %v to %v", id, amount, claimant)
+		return
+	}
+
+	b.log.Errorf("failed to pay bounty %d: %v", id, err)
+	b.mtx.Lock()
+	var failed *Bounty
+	if bounty, ok := b.state.Bounties[id]; ok {
+		bounty.payoutFailed(err.Error())
+		res := *bounty
+		failed = &res
+		if err := b.save(); err != nil {
+			b.log.Errorf("failed to save bounties: %v", err)
+		}
+	}
+	b.mtx.Unlock()
+	if failed != nil {
+		b.notifyPayoutFailed(ctx, failed)
+	}
+}
+
+// notifyPayoutFailed tells the approver of a bounty that its payout failed
+// and may be approved again.
+func (b *Board) notifyPayoutFailed(ctx context.Context, bounty *Bounty) {
+	var uid zkidentity.ShortID
+	if err := uid.FromString(bounty.Approver); err != nil {
+		b.log.Errorf("bounty %d has no valid approver to notify", bounty.ID)
+		return
+	}
+	msg := fmt.Sprintf("payout of bounty %d (%v to %v) failed: %v. "+
+		"It is still in review, approve it again to retry.",
+		bounty.ID, bounty.Amount, bounty.ClaimantNick, bounty.PayoutErr)
+	if err := b.bot.SendPMUser(ctx, bot.UserRefID(uid), msg); err != nil {
+		b.log.Errorf("failed to notify approver of bounty %d: %v", bounty.ID, err)
+	}
+}
+
+var _ bot.TipClaimer = (*Board)(nil)
+
+// PendingTip returns when the oldest payout to uid for amount that is still
+// in flight was requested.
+func (b *Board) PendingTip(uid zkidentity.ShortID, amount dcrutil.Amount) (time.Time, bool) {
+	defer b.mtx.Unlock()
+	b.mtx.Lock()
+
+	bounty := b.oldestPayout(uid.String(), amount)
+	if bounty == nil {
+		return time.Time{}, false
+	}
+	return bounty.PayoutSentAt, true
+}
+
+// oldestPayout returns the bounty with the oldest payout to uid for amount
+// still in flight. Must be called with the mutex held.
+func (b *Board) oldestPayout(uid string, amount dcrutil.Amount) *Bounty {
+	var bounty *Bounty
+	for _, cand := range b.state.Bounties {
+		if !cand.PayoutPending || cand.PayoutSentAt.IsZero() ||
+			cand.Claimant != uid || cand.Amount != amount {
+			continue
+		}
+		if bounty == nil || cand.PayoutSentAt.Before(bounty.PayoutSentAt) {
+			bounty = cand
+		}
+	}
+	return bounty
+}
+
+// HandleTipProgress marks bounties as paid once the tip to the claimant
+// completes and returns them to review when it fails for good. Once
+// registered with bot.AddTipClaimer, the bot only hands it events that no
+// older payout of another module matches.
+//
+// Progress events do not identify the tip, so they are matched by user and
+// amount against the payouts requested by the board, oldest first. Events
+// that match no pending payout are ignored.
+func (b *Board) HandleTipProgress(ctx context.Context, ev *types.TipProgressEvent) {
+	uid := hex.EncodeToString(ev.Uid)
+	amount := dcrutil.Amount(ev.AmountMatoms / 1000)
+
+	b.mtx.Lock()
+	bounty := b.oldestPayout(uid, amount)
+	var paid, failed *Bounty
+	if bounty != nil {
+		switch {
+		case ev.Completed:
+			bounty.PayoutPending = false
+			bounty.PayoutSentAt = time.Time{}
+			bounty.PayoutErr = ""
+			bounty.Status = StatusPaid
+			res := *bounty
+			paid = &res
+		case ev.AttemptErr != "" && !ev.WillRetry:
+			bounty.payoutFailed(ev.AttemptErr)
+			res := *bounty
+			failed = &res
+		case ev.AttemptErr != "":
+			bounty.PayoutErr = ev.AttemptErr
+		}
+		if err := b.save(); err != nil {
+			b.log.Errorf("failed to save bounties: %v", err)
+		}
+	}
+	b.mtx.Unlock()
+
+	switch {
+	case paid != nil:
+		msg := fmt.Sprintf("bounty %d paid: %v to %v", paid.ID, paid.Amount, paid.ClaimantNick)
+		if err := b.bot.SendGC(ctx, paid.GC, msg); err != nil {
+			b.log.Errorf("failed to announce payout: %v", err)
+		}
+	case failed != nil:
+		b.log.Errorf("failed to pay bounty %d: %v", failed.ID, failed.PayoutErr)
+		b.notifyPayoutFailed(ctx, failed)
+	}
+}
+
+// Run releases stale claims and expires bounties past their deadline until
+// the context is canceled.
+func (b *Board) Run(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			b.expire(ctx, now)
+		}
+	}
+}
+
+func (b *Board) expire(ctx context.Context, now time.Time) {
+	type notice struct {
+		gc  string
+		msg string
+	}
+	var notices []notice
+
+	b.mtx.Lock()
+	for _, bounty := range b.state.Bounties {
+		switch {
+		case bounty.Status == StatusClaimed && now.Sub(bounty.ClaimedAt) > b.claimTTL:
+			notices = append(notices, notice{bounty.GC, fmt.Sprintf(
+				"claim of %v on bounty %d expired, it is open again",
+				bounty.ClaimantNick, bounty.ID)})
+			bounty.release()
+		case bounty.Status == StatusOpen && now.After(bounty.Deadline):
+			bounty.Status = StatusExpired
+			notices = append(notices, notice{bounty.GC, fmt.Sprintf(
+				"bounty %d expired", bounty.ID)})
+		}
+	}
+	var err error
+	if len(notices) > 0 {
+		err = b.save()
+	}
+	b.mtx.Unlock()
+	if err != nil {
+		b.log.Errorf("failed to save bounties: %v", err)
+	}
+
+	for _, n := range notices {
+		if err := b.bot.SendGC(ctx, n.gc, n.msg); err != nil {
+			b.log.Errorf("failed to send to gc %v: %v", n.gc, err)
+		}
+	}
+}
+
+// payoutFailed keeps the bounty in review so the payout can be approved
+// again.
+func (bounty *Bounty) payoutFailed(reason string) {
+	bounty.PayoutPending = false
+	bounty.PayoutSentAt = time.Time{}
+	bounty.PayoutErr = reason
+}
+
+func (bounty *Bounty) release() {
+	bounty.Status = StatusOpen
+	bounty.Claimant = ""
+	bounty.ClaimantNick = ""
+	bounty.ClaimedAt = time.Time{}
+}
+
+// save writes the board to disk. Must be called with the mutex held.
+func (b *Board) save() error {
+	raw, err := json.Marshal(&b.state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(b.file, raw, 0o600)
+}
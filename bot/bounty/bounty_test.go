@@ -0,0 +1,167 @@
+package bounty
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+func newTestBoard(t *testing.T) (context.Context, *bottest.Server, *bot.Bot, *Board) {
+	t.Helper()
+	ctx, srv, b := bottest.NewBot(t)
+	board, err := New(Config{DataDir: t.TempDir(), Log: slog.Disabled, Bot: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx, srv, b, board
+}
+
+func tipEvent(uid zkidentity.ShortID, b *Bounty) *types.TipProgressEvent {
+	return &types.TipProgressEvent{
+		Uid:          uid[:],
+		AmountMatoms: int64(b.Amount) * 1000,
+	}
+}
+
+func TestPayoutFailureReturnsToReview(t *testing.T) {
+	ctx, srv, b, board := newTestBoard(t)
+
+	var admin, worker zkidentity.ShortID
+	admin[0], worker[0] = 1, 2
+	if err := b.WhitelistAdd(admin); err != nil {
+		t.Fatal(err)
+	}
+
+	cmds := [][]string{
+		{"add", "0.5", "24h", "fix", "the", "docs"},
+		{"claim", "1"},
+		{"submit", "1"},
+	}
+	users := []zkidentity.ShortID{admin, worker, worker}
+	for i, args := range cmds {
+		if err := board.HandleCommand(ctx, "dev", users[i], "u", args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := board.HandleCommand(ctx, "dev", admin, "admin", []string{"approve", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.Tips()); got != 1 {
+		t.Fatalf("%d tips requested, want 1", got)
+	}
+	bounty := *board.state.Bounties[1]
+	if !bounty.PayoutPending || bounty.PayoutSentAt.IsZero() {
+		t.Fatalf("payout not pending: %+v", bounty)
+	}
+	if sentAt, ok := board.PendingTip(worker, bounty.Amount); !ok || !sentAt.Equal(bounty.PayoutSentAt) {
+		t.Fatalf("pending tip %v, %v", sentAt, ok)
+	}
+	if _, ok := board.PendingTip(admin, bounty.Amount); ok {
+		t.Fatal("pending tip to a user that was not paid")
+	}
+
+	// A failed attempt that will be retried keeps the payout pending.
+	ev := tipEvent(worker, &bounty)
+	ev.AttemptErr, ev.WillRetry = "no route", true
+	board.HandleTipProgress(ctx, ev)
+	if !board.state.Bounties[1].PayoutPending {
+		t.Fatal("payout no longer pending after a retried attempt")
+	}
+
+	// The last failed attempt returns the bounty to review and tells the
+	// approver.
+	ev = tipEvent(worker, &bounty)
+	ev.AttemptErr = "no route"
+	board.HandleTipProgress(ctx, ev)
+	bounty = *board.state.Bounties[1]
+	if bounty.PayoutPending || bounty.Status != StatusInReview || bounty.PayoutErr != "no route" {
+		t.Fatalf("unexpected bounty after failed payout: %+v", bounty)
+	}
+	pms := srv.PMs()
+	if len(pms) != 1 || pms[0].User != admin.String() ||
+		!strings.Contains(pms[0].Msg.Message, "approve it again") {
+		t.Fatalf("approver not notified: %v", pms)
+	}
+
+	// The approver can retry and the completed tip pays the bounty.
+	if err := board.HandleCommand(ctx, "dev", admin, "admin", []string{"approve", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.Tips()); got != 2 {
+		t.Fatalf("%d tips requested, want 2", got)
+	}
+	ev = tipEvent(worker, &bounty)
+	ev.Completed = true
+	board.HandleTipProgress(ctx, ev)
+	if got := board.state.Bounties[1].Status; got != StatusPaid {
+		t.Fatalf("status %v, want %v", got, StatusPaid)
+	}
+	if _, ok := board.PendingTip(worker, bounty.Amount); ok {
+		t.Fatal("paid bounty still has a pending tip")
+	}
+}
+
+func TestPayoutFailedRequest(t *testing.T) {
+	ctx, srv, b, board := newTestBoard(t)
+
+	var admin, worker zkidentity.ShortID
+	admin[0], worker[0] = 1, 2
+	if err := b.WhitelistAdd(admin); err != nil {
+		t.Fatal(err)
+	}
+	board.state.Bounties[1] = &Bounty{
+		ID:       1,
+		GC:       "dev",
+		Amount:   1e7,
+		Status:   StatusInReview,
+		Claimant: worker.String(),
+		Deadline: time.Now().Add(time.Hour),
+	}
+
+	srv.SetTipUserErr(errors.New("no route to user"))
+	if err := board.HandleCommand(ctx, "dev", admin, "admin", []string{"approve", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	bounty := board.state.Bounties[1]
+	if bounty.PayoutPending || bounty.Status != StatusInReview {
+		t.Fatalf("unexpected bounty after failed tip request: %+v", bounty)
+	}
+	// Nothing blocks the admins from acting on the bounty again.
+	if err := board.HandleCommand(ctx, "dev", admin, "admin", []string{"reject", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := board.state.Bounties[1].Status; got != StatusClaimed {
+		t.Fatalf("status %v, want %v", got, StatusClaimed)
+	}
+}
+
+func TestTipProgressOfOtherModulesIgnored(t *testing.T) {
+	ctx, _, _, board := newTestBoard(t)
+
+	var worker zkidentity.ShortID
+	worker[0] = 2
+	board.state.Bounties[1] = &Bounty{
+		ID:       1,
+		GC:       "dev",
+		Amount:   1e7,
+		Status:   StatusInReview,
+		Claimant: worker.String(),
+	}
+
+	// A tip of the same amount to the claimant that the board did not
+	// send does not pay the bounty.
+	ev := tipEvent(worker, board.state.Bounties[1])
+	ev.Completed = true
+	board.HandleTipProgress(ctx, ev)
+	if got := board.state.Bounties[1].Status; got != StatusInReview {
+		t.Fatalf("status %v, want %v", got, StatusInReview)
+	}
+}
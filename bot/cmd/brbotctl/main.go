@@ -0,0 +1,146 @@
+// brbotctl controls a running bot through its admin socket.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot/admin"
+)
+
+const usage = `Usage: brbotctl [-s socket] [-t timeout] <command> [args]
+
+Commands:
+  whitelist list
+  whitelist add <user>
+  whitelist remove <user>
+  gcs
+  send pm <user> <msg>
+  send gc <gc> <msg>
+  streams
+  health
+  bridges
+  bridge pause <room>
+  bridge resume <room>
+  reload
+  methods
+  call <method> [json params]
+
+Users may be given by ID or nick.
+`
+
+// defaultSocket returns the admin socket in the default data directory of
+// the bridge.
+func defaultSocket() string {
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "windows":
+		dir := os.Getenv("LOCALAPPDATA")
+		if dir == "" {
+			dir = os.Getenv("APPDATA")
+		}
+		return filepath.Join(dir, "Brbot", "admin.sock")
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "Brbot", "admin.sock")
+	default:
+		return filepath.Join(home, ".brbot", "admin.sock")
+	}
+}
+
+var errUsage = errors.New("invalid usage")
+
+// parseCommand maps the command line to an admin method and its params.
+func parseCommand(args []string) (string, interface{}, error) {
+	if len(args) == 0 {
+		return "", nil, errUsage
+	}
+	cmd, args := args[0], args[1:]
+	switch {
+	case cmd == "whitelist" && len(args) == 1 && args[0] == "list":
+		return "whitelist.list", nil, nil
+	case cmd == "whitelist" && len(args) == 2 && (args[0] == "add" || args[0] == "remove"):
+		return "whitelist." + args[0], map[string]string{"user": args[1]}, nil
+	case cmd == "gcs" && len(args) == 0:
+		return "gcs.list", nil, nil
+	case cmd == "send" && len(args) >= 3 && (args[0] == "pm" || args[0] == "gc"):
+		return "send." + args[0], map[string]string{
+			"to":  args[1],
+			"msg": strings.Join(args[2:], " "),
+		}, nil
+	case cmd == "streams" && len(args) == 0:
+		return "streams", nil, nil
+	case cmd == "health" && len(args) == 0:
+		return "health", nil, nil
+	case cmd == "bridges" && len(args) == 0:
+		return "bridges.list", nil, nil
+	case cmd == "bridge" && len(args) == 2 && (args[0] == "pause" || args[0] == "resume"):
+		return "bridges." + args[0], map[string]string{"room": args[1]}, nil
+	case cmd == "reload" && len(args) == 0:
+		return "config.reload", nil, nil
+	case cmd == "methods" && len(args) == 0:
+		return "methods", nil, nil
+	case cmd == "call" && len(args) == 1:
+		return args[0], nil, nil
+	case cmd == "call" && len(args) == 2:
+		return args[0], json.RawMessage(args[1]), nil
+	}
+	return "", nil, errUsage
+}
+
+func realMain() error {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	socket := flag.String("s", defaultSocket(), "path of the admin socket")
+	timeout := flag.Duration("t", 30*time.Second, "timeout of the call")
+	flag.Parse()
+
+	method, params, err := parseCommand(flag.Args())
+	if err != nil {
+		return err
+	}
+
+	c, err := admin.Dial(*socket)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var res json.RawMessage
+	if err := c.Call(ctx, method, params, &res); err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		fmt.Println("ok")
+		return nil
+	}
+	out, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func main() {
+	err := realMain()
+	if errors.Is(err, errUsage) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,97 @@
+// forgesend posts a webhook payload to a forge receiver the way a GitHub,
+// Gitea or GitLab instance would, to test the receiver locally.
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const usage = `Usage: forgesend [flags] <payload.json | ->
+
+Posts the JSON payload read from the file, or from stdin when "-", to the
+forge receiver at -url with the headers of the chosen forge.
+
+Flags:
+`
+
+func realMain() error {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	url := flag.String("url", "http://127.0.0.1:8090/", "URL of the receiver")
+	forge := flag.String("forge", "github", "forge to imitate: github, gitea or gitlab")
+	event := flag.String("event", "push", "event name, such as push, pull_request or \"Push Hook\" for gitlab")
+	secret := flag.String("secret", "", "shared secret of the receiver")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var body []byte
+	var err error
+	if flag.Arg(0) == "-" {
+		body, err = io.ReadAll(os.Stdin)
+	} else {
+		body, err = os.ReadFile(flag.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, *url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	mac := hmac.New(sha256.New, []byte(*secret))
+	mac.Write(body)
+	sig := hex.EncodeToString(mac.Sum(nil))
+	switch *forge {
+	case "github":
+		req.Header.Set("X-GitHub-Event", *event)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+sig)
+	case "gitea":
+		req.Header.Set("X-Gitea-Event", *event)
+		req.Header.Set("X-Gitea-Signature", sig)
+	case "gitlab":
+		req.Header.Set("X-Gitlab-Event", *event)
+		req.Header.Set("X-Gitlab-Token", *secret)
+	default:
+		return fmt.Errorf("unknown forge %q", *forge)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	fmt.Printf("%v %s", resp.Status, respBody)
+	if len(respBody) == 0 || respBody[len(respBody)-1] != '\n' {
+		fmt.Println()
+	}
+	if resp.StatusCode >= 300 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func main() {
+	if err := realMain(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CommandPrefix is the prefix of messages that are commands to the bot.
+const CommandPrefix = "!"
+
+// ParseCommand splits a message such as `!poll "Lunch?" pizza tacos` into
+// the lowercased command name and its arguments. Double quotes group words
+// into a single argument. ok is false when msg is not a command.
+func ParseCommand(msg string) (cmd string, args []string, ok bool) {
+	msg = strings.TrimSpace(msg)
+	if !strings.HasPrefix(msg, CommandPrefix) {
+		return "", nil, false
+	}
+
+	fields := splitQuoted(msg[len(CommandPrefix):])
+	if len(fields) == 0 || fields[0] == "" {
+		return "", nil, false
+	}
+	return strings.ToLower(fields[0]), fields[1:], true
+}
+
+// splitQuoted splits s around whitespace, keeping text between double quotes
+// together.
+func splitQuoted(s string) []string {
+	var (
+		fields  []string
+		sb      strings.Builder
+		inQuote bool
+		inField bool
+	)
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			inField = true
+		case unicode.IsSpace(r) && !inQuote:
+			if inField {
+				fields = append(fields, sb.String())
+				sb.Reset()
+				inField = false
+			}
+		default:
+			sb.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, sb.String())
+	}
+	return fields
+}
@@ -0,0 +1,509 @@
+package digest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+const (
+	// maxWindow is how long message stats are kept. It must be at least
+	// as long as the longest frequency.
+	maxWindow = 7 * 24 * time.Hour
+
+	checkInterval = time.Minute
+	topMembers    = 5
+	maxMentions   = 10
+	maxLinks      = 10
+)
+
+var frequencies = map[string]time.Duration{
+	"hourly": time.Hour,
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+var (
+	embedRegexp = regexp.MustCompile(`--embed\[.*?\]--`)
+	linkRegexp  = regexp.MustCompile(`https?://[^\s<>"]+`)
+)
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot bot.API
+
+	// Keywords are words whose mentions are listed in the digest.
+	Keywords []string
+
+	// GCPosts maps GC aliases to a local time of day (15:04) at which a
+	// daily digest is sent to the GC itself.
+	GCPosts map[string]string
+}
+
+// Subscription is a user's request to receive the digest of a GC by PM.
+type Subscription struct {
+	UID       string        `json:"uid"`
+	Nick      string        `json:"nick"`
+	GC        string        `json:"gc"`
+	Frequency time.Duration `json:"frequency"`
+	LastSent  time.Time     `json:"last_sent"`
+}
+
+// event is what is kept of each GC message to build digests.
+type event struct {
+	Time    time.Time `json:"time"`
+	Nick    string    `json:"nick"`
+	Mention string    `json:"mention,omitempty"`
+	Embeds  int       `json:"embeds,omitempty"`
+	Links   []string  `json:"links,omitempty"`
+}
+
+type state struct {
+	Subscriptions []*Subscription      `json:"subscriptions"`
+	Events        map[string][]event   `json:"events"`
+	GCPosted      map[string]time.Time `json:"gc_posted"`
+}
+
+// Digest summarizes GC activity and periodically delivers the summaries to
+// subscribers and GCs.
+type Digest struct {
+	bot      bot.API
+	log      slog.Logger
+	keywords []string
+	gcPosts  map[string]time.Duration
+
+	stateFile string
+
+	mtx   sync.Mutex
+	state state
+	dirty bool
+}
+
+func New(cfg Config) (*Digest, error) {
+	gcPosts := make(map[string]time.Duration, len(cfg.GCPosts))
+	for gc, at := range cfg.GCPosts {
+		t, err := time.Parse("15:04", at)
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest time for gc %v: %w", gc, err)
+		}
+		gcPosts[strings.ToLower(gc)] = time.Duration(t.Hour())*time.Hour +
+			time.Duration(t.Minute())*time.Minute
+	}
+
+	keywords := make([]string, 0, len(cfg.Keywords))
+	for _, kw := range cfg.Keywords {
+		keywords = append(keywords, strings.ToLower(kw))
+	}
+
+	d := &Digest{
+		bot:       cfg.Bot,
+		log:       cfg.Log,
+		keywords:  keywords,
+		gcPosts:   gcPosts,
+		stateFile: filepath.Join(cfg.DataDir, "digest.json"),
+		state: state{
+			Events:   make(map[string][]event),
+			GCPosted: make(map[string]time.Time),
+		},
+	}
+
+	raw, err := os.ReadFile(d.stateFile)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(raw, &d.state); err != nil {
+			return nil, err
+		}
+		if d.state.Events == nil {
+			d.state.Events = make(map[string][]event)
+		}
+		if d.state.GCPosted == nil {
+			d.state.GCPosted = make(map[string]time.Time)
+		}
+	}
+
+	return d, nil
+}
+
+// HandleGC records the activity of a GC message.
+func (d *Digest) HandleGC(m *types.GCReceivedMsg) {
+	if m.Msg == nil {
+		return
+	}
+	msg := m.Msg.Message
+	ev := event{
+		Time:   time.Now(),
+		Nick:   m.Nick,
+		Embeds: len(embedRegexp.FindAllString(msg, -1)),
+		Links:  linkRegexp.FindAllString(msg, -1),
+	}
+	if m.TimestampMs != 0 {
+		ev.Time = time.UnixMilli(m.TimestampMs)
+	}
+	lower := strings.ToLower(msg)
+	for _, kw := range d.keywords {
+		if strings.Contains(lower, kw) {
+			ev.Mention = embedRegexp.ReplaceAllString(msg, "[embed]")
+			break
+		}
+	}
+
+	gc := strings.ToLower(m.GcAlias)
+	d.mtx.Lock()
+	d.state.Events[gc] = append(d.state.Events[gc], ev)
+	d.dirty = true
+	d.mtx.Unlock()
+}
+
+// HandleCommand handles the !digest command sent by PM:
+//
+//	!digest subscribe <gc> [hourly|daily|weekly]
+//	!digest unsubscribe <gc>
+//	!digest list
+//
+// Only whitelisted users and the members of a GC may subscribe to its
+// digest.
+func (d *Digest) HandleCommand(ctx context.Context, uid zkidentity.ShortID, nick string, args []string) error {
+	reply := func(f string, a ...interface{}) error {
+		return d.bot.SendPMUser(ctx, bot.UserRefID(uid), fmt.Sprintf(f, a...))
+	}
+	if len(args) == 0 {
+		return reply("usage: !digest subscribe <gc> [hourly|daily|weekly] | unsubscribe <gc> | list")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "subscribe":
+		if len(args) < 2 {
+			return reply("usage: !digest subscribe <gc> [hourly|daily|weekly]")
+		}
+		gc := strings.ToLower(strings.TrimPrefix(args[1], "#"))
+		freqName := "daily"
+		if len(args) > 2 {
+			freqName = strings.ToLower(args[2])
+		}
+		freq, ok := frequencies[freqName]
+		if !ok {
+			return reply("unknown frequency %q", freqName)
+		}
+		ok, err := d.mayRead(ctx, uid, gc)
+		if err != nil {
+			d.log.Warnf("Unable to check access of %v to gc %v: %v", nick, gc, err)
+		}
+		if !ok {
+			return reply("you are not a member of %v", gc)
+		}
+		d.subscribe(uid.String(), nick, gc, freq)
+		return reply("subscribed to the %v digest of %v", freqName, gc)
+
+	case "unsubscribe":
+		if len(args) < 2 {
+			return reply("usage: !digest unsubscribe <gc>")
+		}
+		gc := strings.ToLower(strings.TrimPrefix(args[1], "#"))
+		if !d.unsubscribe(uid.String(), gc) {
+			return reply("not subscribed to %v", gc)
+		}
+		return reply("unsubscribed from the digest of %v", gc)
+
+	case "list":
+		subs := d.Subscriptions(uid.String())
+		if len(subs) == 0 {
+			return reply("no digest subscriptions")
+		}
+		var sb strings.Builder
+		for _, sub := range subs {
+			fmt.Fprintf(&sb, "%v every %v\n", sub.GC, sub.Frequency)
+		}
+		return reply("%s", sb.String())
+
+	default:
+		return reply("unknown digest command %q", args[0])
+	}
+}
+
+// mayRead returns whether uid may receive the digest of gc: whitelisted users
+// and the members of the GC.
+func (d *Digest) mayRead(ctx context.Context, uid zkidentity.ShortID, gc string) (bool, error) {
+	if d.bot.IsWhitelisted(uid) {
+		return true, nil
+	}
+	info, err := d.bot.FindGC(ctx, gc)
+	if err != nil {
+		return false, err
+	}
+	if !strings.EqualFold(info.Name, gc) {
+		return false, nil
+	}
+	return d.bot.IsGCMember(ctx, info.ID, uid)
+}
+
+// Subscriptions returns the digest subscriptions of a user.
+func (d *Digest) Subscriptions(uid string) []Subscription {
+	defer d.mtx.Unlock()
+	d.mtx.Lock()
+
+	var res []Subscription
+	for _, sub := range d.state.Subscriptions {
+		if sub.UID == uid {
+			res = append(res, *sub)
+		}
+	}
+	return res
+}
+
+func (d *Digest) subscribe(uid, nick, gc string, freq time.Duration) {
+	defer d.mtx.Unlock()
+	d.mtx.Lock()
+
+	d.dirty = true
+	for _, sub := range d.state.Subscriptions {
+		if sub.UID == uid && sub.GC == gc {
+			sub.Nick = nick
+			sub.Frequency = freq
+			return
+		}
+	}
+	d.state.Subscriptions = append(d.state.Subscriptions, &Subscription{
+		UID:       uid,
+		Nick:      nick,
+		GC:        gc,
+		Frequency: freq,
+		LastSent:  time.Now(),
+	})
+}
+
+func (d *Digest) unsubscribe(uid, gc string) bool {
+	defer d.mtx.Unlock()
+	d.mtx.Lock()
+
+	for i, sub := range d.state.Subscriptions {
+		if sub.UID == uid && sub.GC == gc {
+			d.state.Subscriptions = append(d.state.Subscriptions[:i],
+				d.state.Subscriptions[i+1:]...)
+			d.dirty = true
+			return true
+		}
+	}
+	return false
+}
+
+// Run delivers digests until the context is canceled.
+func (d *Digest) Run(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			d.deliver(ctx, now)
+			if err := d.save(); err != nil {
+				d.log.Errorf("failed to save digest state: %v", err)
+			}
+		}
+	}
+}
+
+type delivery struct {
+	to   string
+	isGC bool
+	msg  string
+	sub  *Subscription
+}
+
+// deliver sends the digests that are due. A digest is only marked as sent
+// once the send succeeded, so a failed one is retried on the next check.
+func (d *Digest) deliver(ctx context.Context, now time.Time) {
+	d.mtx.Lock()
+	d.expire(now)
+
+	var deliveries []delivery
+	for _, sub := range d.state.Subscriptions {
+		if now.Sub(sub.LastSent) < sub.Frequency {
+			continue
+		}
+		since := sub.LastSent
+		if now.Sub(since) > maxWindow {
+			since = now.Add(-maxWindow)
+		}
+		deliveries = append(deliveries, delivery{
+			to:  sub.UID,
+			msg: d.summary(sub.GC, since, now),
+			sub: sub,
+		})
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for gc, at := range d.gcPosts {
+		postAt := midnight.Add(at)
+		if now.Before(postAt) || !d.state.GCPosted[gc].Before(postAt) {
+			continue
+		}
+		deliveries = append(deliveries, delivery{
+			to:   gc,
+			isGC: true,
+			msg:  d.summary(gc, now.Add(-24*time.Hour), now),
+		})
+	}
+	d.mtx.Unlock()
+
+	for _, dl := range deliveries {
+		var err error
+		if dl.isGC {
+			err = d.bot.SendGC(ctx, dl.to, dl.msg)
+		} else {
+			err = d.bot.SendPM(ctx, dl.to, dl.msg)
+		}
+		if err != nil {
+			d.log.Errorf("failed to deliver digest to %v: %v", dl.to, err)
+			continue
+		}
+		d.mtx.Lock()
+		if dl.isGC {
+			d.state.GCPosted[dl.to] = now
+		} else {
+			dl.sub.LastSent = now
+		}
+		d.dirty = true
+		d.mtx.Unlock()
+	}
+}
+
+// Summary returns the digest of a GC for the given period.
+func (d *Digest) Summary(gc string, since, until time.Time) string {
+	defer d.mtx.Unlock()
+	d.mtx.Lock()
+	return d.summary(strings.ToLower(gc), since, until)
+}
+
+// summary builds the digest text. Must be called with the mutex held.
+func (d *Digest) summary(gc string, since, until time.Time) string {
+	var (
+		count    int
+		embeds   int
+		members  = make(map[string]int)
+		mentions []event
+		links    []string
+	)
+	for _, ev := range d.state.Events[gc] {
+		if ev.Time.Before(since) || ev.Time.After(until) {
+			continue
+		}
+		count++
+		embeds += ev.Embeds
+		members[ev.Nick]++
+		if ev.Mention != "" {
+			mentions = append(mentions, ev)
+		}
+		links = append(links, ev.Links...)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Digest for %v since %v\n", gc, since.Format("2006-01-02 15:04"))
+	if count == 0 {
+		sb.WriteString("No messages.")
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "%d messages from %d members\n", count, len(members))
+
+	type member struct {
+		nick  string
+		count int
+	}
+	ranked := make([]member, 0, len(members))
+	for nick, n := range members {
+		ranked = append(ranked, member{nick, n})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].count != ranked[j].count {
+			return ranked[i].count > ranked[j].count
+		}
+		return ranked[i].nick < ranked[j].nick
+	})
+	if len(ranked) > topMembers {
+		ranked = ranked[:topMembers]
+	}
+	sb.WriteString("Most active:")
+	for _, m := range ranked {
+		fmt.Fprintf(&sb, " %v (%d)", m.nick, m.count)
+	}
+	sb.WriteString("\n")
+
+	if len(mentions) > 0 {
+		sb.WriteString("Mentions:\n")
+		if len(mentions) > maxMentions {
+			mentions = mentions[len(mentions)-maxMentions:]
+		}
+		for _, ev := range mentions {
+			fmt.Fprintf(&sb, "  [%v] <%v> %v\n", ev.Time.Format("15:04"),
+				ev.Nick, ev.Mention)
+		}
+	}
+	if embeds > 0 {
+		fmt.Fprintf(&sb, "Embeds shared: %d\n", embeds)
+	}
+	if len(links) > 0 {
+		sb.WriteString("Links:\n")
+		if len(links) > maxLinks {
+			links = links[len(links)-maxLinks:]
+		}
+		for _, link := range links {
+			fmt.Fprintf(&sb, "  %v\n", link)
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// expire drops events older than the longest digest window. Must be called
+// with the mutex held.
+func (d *Digest) expire(now time.Time) {
+	cutoff := now.Add(-maxWindow)
+	for gc, events := range d.state.Events {
+		i := sort.Search(len(events), func(i int) bool {
+			return !events[i].Time.Before(cutoff)
+		})
+		if i == 0 {
+			continue
+		}
+		d.dirty = true
+		if i == len(events) {
+			delete(d.state.Events, gc)
+			continue
+		}
+		d.state.Events[gc] = append([]event(nil), events[i:]...)
+	}
+}
+
+func (d *Digest) save() error {
+	defer d.mtx.Unlock()
+	d.mtx.Lock()
+
+	if !d.dirty {
+		return nil
+	}
+	raw, err := json.Marshal(&d.state)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(d.stateFile, raw, 0o600); err != nil {
+		return err
+	}
+	d.dirty = false
+	return nil
+}
@@ -0,0 +1,105 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+// flakyPM fails to send PMs while fail is set.
+type flakyPM struct {
+	bot.API
+	fail bool
+}
+
+func (b *flakyPM) SendPM(ctx context.Context, nick, msg string) error {
+	if b.fail {
+		return errors.New("user unreachable")
+	}
+	return b.API.SendPM(ctx, nick, msg)
+}
+
+func TestSubscribeRequiresMembership(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	d, err := New(Config{DataDir: t.TempDir(), Log: slog.Disabled, Bot: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var member, admin, outsider zkidentity.ShortID
+	member[0], admin[0], outsider[0] = 1, 2, 3
+	if err := b.WhitelistAdd(admin); err != nil {
+		t.Fatal(err)
+	}
+	gcID := bytes.Repeat([]byte{9}, 32)
+	srv.SetGCs([]*types.ListGCsResponse_GCInfo{{Id: gcID, Name: "dev", NbMembers: 1}})
+	srv.SetGCMembers(gcID, member[:])
+
+	tests := []struct {
+		name  string
+		uid   zkidentity.ShortID
+		gc    string
+		reply string
+		subs  int
+	}{
+		{"outsider", outsider, "dev", "you are not a member of dev", 0},
+		{"unknown gc", outsider, "ops", "you are not a member of ops", 0},
+		{"member", member, "#dev", "subscribed to the daily digest of dev", 1},
+		{"whitelisted", admin, "dev", "subscribed to the daily digest of dev", 1},
+	}
+	for _, tc := range tests {
+		before := len(srv.PMs())
+		err := d.HandleCommand(ctx, tc.uid, tc.name, []string{"subscribe", tc.gc})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		pms := srv.PMs()
+		if len(pms) != before+1 || pms[before].Msg.Message != tc.reply {
+			t.Fatalf("%s: unexpected replies %v", tc.name, pms[before:])
+		}
+		if got := len(d.Subscriptions(tc.uid.String())); got != tc.subs {
+			t.Fatalf("%s: %d subscriptions, want %d", tc.name, got, tc.subs)
+		}
+	}
+}
+
+func TestFailedDigestRetried(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	fb := &flakyPM{API: b}
+	d, err := New(Config{DataDir: t.TempDir(), Log: slog.Disabled, Bot: fb})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uid zkidentity.ShortID
+	uid[0] = 1
+	d.subscribe(uid.String(), "alice", "dev", time.Hour)
+	d.HandleGC(&types.GCReceivedMsg{
+		GcAlias: "dev",
+		Nick:    "bob",
+		Msg:     &types.RMGroupMessage{Message: "hello"},
+	})
+
+	now := time.Now().Add(time.Hour + time.Minute)
+	fb.fail = true
+	d.deliver(ctx, now)
+	fb.fail = false
+	d.deliver(ctx, now.Add(time.Minute))
+	pms := srv.PMs()
+	if len(pms) != 1 || pms[0].User != uid.String() {
+		t.Fatalf("unexpected pms %v", pms)
+	}
+
+	// The next digest is due a period after the one that was sent.
+	d.deliver(ctx, now.Add(30*time.Minute))
+	if got := len(srv.PMs()); got != 1 {
+		t.Fatalf("%d pms sent, want 1", got)
+	}
+}
@@ -0,0 +1,485 @@
+package faq
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	reloadInterval  = 5 * time.Second
+	defaultCooldown = 5 * time.Minute
+)
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot bot.API
+
+	// RulesFile is the YAML file with the rules. Defaults to faq.yaml
+	// in DataDir.
+	RulesFile string
+}
+
+// Rule is a single FAQ entry.
+type Rule struct {
+	Name string `yaml:"name"`
+
+	// Keywords match when any of them is in the message, ignoring case.
+	Keywords []string `yaml:"keywords,omitempty"`
+
+	// Patterns are regular expressions matched against the message.
+	Patterns []string `yaml:"patterns,omitempty"`
+
+	// Response is a text/template executed with the fields Nick, GC and
+	// Msg of the matched message.
+	Response string `yaml:"response"`
+
+	// Cooldown is the minimum time between two answers of this rule in
+	// the same GC or to the same user.
+	Cooldown time.Duration `yaml:"cooldown,omitempty"`
+
+	// GCs limits the rule to the given GC aliases. An empty list
+	// matches every GC.
+	GCs []string `yaml:"gcs,omitempty"`
+
+	// PM enables the rule for private messages.
+	PM bool `yaml:"pm,omitempty"`
+
+	patterns []*regexp.Regexp
+	tmpl     *template.Template
+}
+
+type rulesFile struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+// templateData is the data available to response templates.
+type templateData struct {
+	Nick string
+	GC   string
+	Msg  string
+}
+
+// FAQ answers GC messages and PMs matching the configured rules.
+type FAQ struct {
+	bot  bot.API
+	log  slog.Logger
+	file string
+
+	mtx     sync.Mutex
+	rules   []*Rule
+	modTime time.Time
+
+	// lastAnswer tracks, per rule name and scope (GC alias or user id),
+	// when the rule was last answered.
+	lastAnswer map[string]time.Time
+}
+
+func New(cfg Config) (*FAQ, error) {
+	file := cfg.RulesFile
+	if file == "" {
+		file = filepath.Join(cfg.DataDir, "faq.yaml")
+	}
+
+	f := &FAQ{
+		bot:        cfg.Bot,
+		log:        cfg.Log,
+		file:       file,
+		lastAnswer: make(map[string]time.Time),
+	}
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Run reloads the rules file whenever it changes, until the context is
+// canceled.
+func (f *FAQ) Run(ctx context.Context) error {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			reloaded, err := f.reload()
+			if err != nil {
+				f.log.Errorf("failed to reload faq rules: %v", err)
+			} else if reloaded {
+				f.log.Infof("reloaded faq rules from %v", f.file)
+			}
+		}
+	}
+}
+
+// reload reads the rules file if it changed since the last read. Invalid
+// files leave the current rules in place.
+func (f *FAQ) reload() (bool, error) {
+	fi, err := os.Stat(f.file)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	f.mtx.Lock()
+	unchanged := fi.ModTime().Equal(f.modTime)
+	f.mtx.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	raw, err := os.ReadFile(f.file)
+	if err != nil {
+		return false, err
+	}
+	var rf rulesFile
+	if err := yaml.UnmarshalStrict(raw, &rf); err != nil {
+		return false, fmt.Errorf("%v: %w", f.file, err)
+	}
+	for _, rule := range rf.Rules {
+		if err := rule.compile(); err != nil {
+			return false, fmt.Errorf("%v: %w", f.file, err)
+		}
+	}
+
+	f.mtx.Lock()
+	f.rules = rf.Rules
+	f.modTime = fi.ModTime()
+	f.mtx.Unlock()
+	return true, nil
+}
+
+// HandleGC answers a GC message that matches a rule.
+func (f *FAQ) HandleGC(ctx context.Context, m *types.GCReceivedMsg) error {
+	if m.Msg == nil {
+		return nil
+	}
+	resp := f.answer(m.GcAlias, "", m.Nick, m.Msg.Message)
+	if resp == "" {
+		return nil
+	}
+	return f.bot.SendGC(ctx, m.GcAlias, resp)
+}
+
+// HandlePM answers a PM that matches a rule enabled for PMs.
+func (f *FAQ) HandlePM(ctx context.Context, m *types.ReceivedPM) error {
+	if m.Msg == nil {
+		return nil
+	}
+	var uid zkidentity.ShortID
+	if err := uid.FromBytes(m.Uid); err != nil {
+		return err
+	}
+	resp := f.answer("", uid.String(), m.Nick, m.Msg.Message)
+	if resp == "" {
+		return nil
+	}
+	return f.bot.SendPM(ctx, uid.String(), resp)
+}
+
+// answer returns the response of the first matching rule that is not
+// cooling down, or an empty string.
+func (f *FAQ) answer(gc, uid, nick, msg string) string {
+	if _, _, isCmd := bot.ParseCommand(msg); isCmd {
+		return ""
+	}
+
+	defer f.mtx.Unlock()
+	f.mtx.Lock()
+
+	now := time.Now()
+	for _, rule := range f.rules {
+		if !rule.inScope(gc) || !rule.matches(msg) {
+			continue
+		}
+		scope := rule.Name + "/" + gc + uid
+		cooldown := rule.Cooldown
+		if cooldown == 0 {
+			cooldown = defaultCooldown
+		}
+		if now.Sub(f.lastAnswer[scope]) < cooldown {
+			continue
+		}
+
+		var b bytes.Buffer
+		data := templateData{Nick: nick, GC: gc, Msg: msg}
+		if err := rule.tmpl.Execute(&b, data); err != nil {
+			f.log.Errorf("faq rule %v: %v", rule.Name, err)
+			continue
+		}
+		f.lastAnswer[scope] = now
+		return b.String()
+	}
+	return ""
+}
+
+// HandleAdminCommand handles the !faq command sent by whitelisted users by
+// PM:
+//
+//	!faq list
+//	!faq show <name>
+//	!faq add <name> <response>
+//	!faq set <name> keywords|patterns|gcs <comma separated values>
+//	!faq set <name> response <text>
+//	!faq set <name> cooldown <duration>
+//	!faq set <name> pm on|off
+//	!faq del <name>
+func (f *FAQ) HandleAdminCommand(ctx context.Context, uid zkidentity.ShortID, nick string, args []string) error {
+	reply := func(format string, a ...interface{}) error {
+		return f.bot.SendPMUser(ctx, bot.UserRefID(uid), fmt.Sprintf(format, a...))
+	}
+	if !f.bot.IsWhitelisted(uid) {
+		return reply("you are not allowed to edit the faq")
+	}
+	if len(args) == 0 {
+		return reply("usage: !faq list | show <name> | add <name> <response> | set <name> <field> <value> | del <name>")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "list":
+		f.mtx.Lock()
+		names := make([]string, 0, len(f.rules))
+		for _, rule := range f.rules {
+			names = append(names, rule.Name)
+		}
+		f.mtx.Unlock()
+		if len(names) == 0 {
+			return reply("no faq rules")
+		}
+		sort.Strings(names)
+		return reply("faq rules: %v", strings.Join(names, ", "))
+
+	case "show":
+		if len(args) < 2 {
+			return reply("usage: !faq show <name>")
+		}
+		f.mtx.Lock()
+		rule := f.rule(args[1])
+		var raw []byte
+		var err error
+		if rule != nil {
+			raw, err = yaml.Marshal(rule)
+		}
+		f.mtx.Unlock()
+		if rule == nil {
+			return reply("unknown rule %q", args[1])
+		}
+		if err != nil {
+			return err
+		}
+		return reply("%s", raw)
+
+	case "add":
+		if len(args) < 3 {
+			return reply("usage: !faq add <name> <response>")
+		}
+		rule := &Rule{
+			Name:     args[1],
+			Keywords: []string{args[1]},
+			Response: strings.Join(args[2:], " "),
+		}
+		err := f.edit(func() error {
+			if f.rule(rule.Name) != nil {
+				return fmt.Errorf("rule %q already exists", rule.Name)
+			}
+			if err := rule.compile(); err != nil {
+				return err
+			}
+			f.rules = append(f.rules, rule)
+			return nil
+		})
+		if err != nil {
+			return reply("%v", err)
+		}
+		return reply("rule %q added", rule.Name)
+
+	case "set":
+		if len(args) < 4 {
+			return reply("usage: !faq set <name> <field> <value>")
+		}
+		name, field, value := args[1], strings.ToLower(args[2]), strings.Join(args[3:], " ")
+		err := f.edit(func() error {
+			rule := f.rule(name)
+			if rule == nil {
+				return fmt.Errorf("unknown rule %q", name)
+			}
+			updated := *rule
+			if err := updated.set(field, value); err != nil {
+				return err
+			}
+			if err := updated.compile(); err != nil {
+				return err
+			}
+			*rule = updated
+			return nil
+		})
+		if err != nil {
+			return reply("%v", err)
+		}
+		return reply("rule %q updated", name)
+
+	case "del":
+		if len(args) < 2 {
+			return reply("usage: !faq del <name>")
+		}
+		err := f.edit(func() error {
+			for i, rule := range f.rules {
+				if rule.Name == args[1] {
+					f.rules = append(f.rules[:i], f.rules[i+1:]...)
+					return nil
+				}
+			}
+			return fmt.Errorf("unknown rule %q", args[1])
+		})
+		if err != nil {
+			return reply("%v", err)
+		}
+		return reply("rule %q deleted", args[1])
+
+	default:
+		return reply("unknown faq command %q", args[0])
+	}
+}
+
+// edit applies fn to the rules and writes them back to the rules file.
+func (f *FAQ) edit(fn func() error) error {
+	defer f.mtx.Unlock()
+	f.mtx.Lock()
+
+	if err := fn(); err != nil {
+		return err
+	}
+	raw, err := yaml.Marshal(&rulesFile{Rules: f.rules})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(f.file, raw, 0o600); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(f.file); err == nil {
+		f.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+// rule returns the rule with the given name. Must be called with the mutex
+// held.
+func (f *FAQ) rule(name string) *Rule {
+	for _, rule := range f.rules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (r *Rule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule without name")
+	}
+	if len(r.Keywords) == 0 && len(r.Patterns) == 0 {
+		return fmt.Errorf("rule %v: no keywords or patterns", r.Name)
+	}
+	// A fresh slice is built since rules being edited are shallow copies
+	// of the live ones.
+	patterns := make([]*regexp.Regexp, 0, len(r.Patterns))
+	for _, p := range r.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("rule %v: %w", r.Name, err)
+		}
+		patterns = append(patterns, re)
+	}
+	tmpl, err := template.New(r.Name).Parse(r.Response)
+	if err != nil {
+		return fmt.Errorf("rule %v: %w", r.Name, err)
+	}
+	r.patterns = patterns
+	r.tmpl = tmpl
+	return nil
+}
+
+func (r *Rule) set(field, value string) error {
+	splitList := func(s string) []string {
+		var res []string
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				res = append(res, v)
+			}
+		}
+		return res
+	}
+
+	switch field {
+	case "keywords":
+		r.Keywords = splitList(value)
+	case "patterns":
+		r.Patterns = splitList(value)
+	case "gcs":
+		r.GCs = splitList(value)
+	case "response":
+		r.Response = value
+	case "cooldown":
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		r.Cooldown = d
+	case "pm":
+		r.PM = value == "on" || value == "true" || value == "yes"
+	default:
+		return fmt.Errorf("unknown field %q", field)
+	}
+	return nil
+}
+
+// inScope returns whether the rule applies to the GC, or to PMs when gc is
+// empty.
+func (r *Rule) inScope(gc string) bool {
+	if gc == "" {
+		return r.PM
+	}
+	if len(r.GCs) == 0 {
+		return true
+	}
+	for _, g := range r.GCs {
+		if strings.EqualFold(g, gc) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matches(msg string) bool {
+	lower := strings.ToLower(msg)
+	for _, kw := range r.Keywords {
+		if strings.Contains(lower, strings.ToLower(kw)) {
+			return true
+		}
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(msg) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package faq
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+const testRules = `rules:
+- name: wallet
+  keywords: [wallet]
+  response: "{{.Nick}}, see the wallet docs"
+  gcs: [dev]
+- name: version
+  patterns: ['(?i)which version']
+  response: "latest is 1.0"
+  cooldown: 1ns
+  pm: true
+`
+
+func gcMsg(gc, nick, msg string) *types.GCReceivedMsg {
+	return &types.GCReceivedMsg{GcAlias: gc, Nick: nick, Msg: &types.RMGroupMessage{Message: msg}}
+}
+
+func TestAnswers(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	dataDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dataDir, "faq.yaml"), []byte(testRules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := New(Config{DataDir: dataDir, Log: slog.Disabled, Bot: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := []*types.GCReceivedMsg{
+		gcMsg("dev", "alice", "where is the Wallet?"),
+		gcMsg("dev", "bob", "wallet again"), // Cooling down.
+		gcMsg("ops", "bob", "wallet"),       // Out of scope.
+		gcMsg("ops", "bob", "!help wallet"), // Command.
+		gcMsg("ops", "bob", "Which version is it"),
+		gcMsg("ops", "bob", "which version now"),
+	}
+	for _, m := range msgs {
+		if err := f.HandleGC(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"alice, see the wallet docs", "latest is 1.0", "latest is 1.0"}
+	gcms := srv.GCMs()
+	if len(gcms) != len(want) {
+		t.Fatalf("unexpected answers %v", gcms)
+	}
+	for i, m := range gcms {
+		if m.Msg != want[i] {
+			t.Fatalf("answer %d: %q, want %q", i, m.Msg, want[i])
+		}
+	}
+
+	var uid zkidentity.ShortID
+	uid[0] = 1
+	pms := []*types.ReceivedPM{
+		{Uid: uid[:], Nick: "carol", Msg: &types.RMPrivateMessage{Message: "wallet"}},
+		{Uid: uid[:], Nick: "carol", Msg: &types.RMPrivateMessage{Message: "which version"}},
+	}
+	for _, pm := range pms {
+		if err := f.HandlePM(ctx, pm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := srv.PMs(); len(got) != 1 || got[0].User != uid.String() || got[0].Msg.Message != "latest is 1.0" {
+		t.Fatalf("unexpected pm answers %v", got)
+	}
+}
+
+func TestAdminCommand(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	dataDir := t.TempDir()
+	f, err := New(Config{DataDir: dataDir, Log: slog.Disabled, Bot: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var admin, other zkidentity.ShortID
+	admin[0], other[0] = 1, 2
+	if err := b.WhitelistAdd(admin); err != nil {
+		t.Fatal(err)
+	}
+
+	cmds := []struct {
+		uid   zkidentity.ShortID
+		args  []string
+		reply string
+	}{
+		{other, []string{"list"}, "you are not allowed to edit the faq"},
+		{admin, []string{"add", "docs", "read", "the", "docs"}, `rule "docs" added`},
+		{admin, []string{"add", "docs", "again"}, `rule "docs" already exists`},
+		{admin, []string{"set", "docs", "patterns", `^how\b`}, `rule "docs" updated`},
+		{admin, []string{"set", "docs", "patterns", "("}, "rule docs: error parsing regexp: missing closing ): `(`"},
+		{admin, []string{"set", "docs", "color", "red"}, `unknown field "color"`},
+		{admin, []string{"list"}, "faq rules: docs"},
+	}
+	for _, c := range cmds {
+		if err := f.HandleAdminCommand(ctx, c.uid, "u", c.args); err != nil {
+			t.Fatal(err)
+		}
+		pms := srv.PMs()
+		if got := pms[len(pms)-1].Msg.Message; got != c.reply {
+			t.Fatalf("%v: reply %q, want %q", c.args, got, c.reply)
+		}
+	}
+
+	// The failed edit left the rule working.
+	if got := f.answer("dev", "", "u", "how do I start"); got != "read the docs" {
+		t.Fatalf("answer %q", got)
+	}
+
+	// The rules were written to the file and are read back.
+	f, err = New(Config{DataDir: dataDir, Log: slog.Disabled, Bot: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.answer("dev", "", "u", "how come"); got != "read the docs" {
+		t.Fatalf("answer after reload %q", got)
+	}
+
+	// An invalid file leaves the rules in place.
+	file := filepath.Join(dataDir, "faq.yaml")
+	if err := os.WriteFile(file, []byte("rules: [{name: x, response: y}]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.reload(); err == nil {
+		t.Fatal("invalid rules accepted")
+	}
+	if f.rule("docs") == nil {
+		t.Fatal("rules dropped by an invalid file")
+	}
+}
@@ -0,0 +1,300 @@
+package feeds
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/decred/slog"
+)
+
+const (
+	defaultAddr     = "127.0.0.1:8989"
+	defaultMaxItems = 50
+)
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	// Addr is the address the feed server listens on. Defaults to a
+	// localhost address.
+	Addr string
+
+	// BaseURL is the URL used in links inside the feeds. Defaults to
+	// http://<Addr>.
+	BaseURL string
+
+	// MaxItems is the maximum number of posts in a feed.
+	MaxItems int
+}
+
+// Post is a received post as stored by the feed server.
+type Post struct {
+	ID         string    `json:"id"`
+	AuthorID   string    `json:"author_id"`
+	AuthorNick string    `json:"author_nick"`
+	Title      string    `json:"title"`
+	Body       string    `json:"body"`
+	Date       time.Time `json:"date"`
+}
+
+// Server stores received posts and serves them as Atom and RSS feeds.
+type Server struct {
+	log      slog.Logger
+	addr     string
+	baseURL  string
+	maxItems int
+
+	postsDir  string
+	imagesDir string
+
+	mtx   sync.Mutex
+	posts map[string]*Post
+}
+
+func New(cfg Config) (*Server, error) {
+	addr := cfg.Addr
+	if addr == "" {
+		addr = defaultAddr
+	}
+	baseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = "http://" + addr
+	}
+	maxItems := cfg.MaxItems
+	if maxItems <= 0 {
+		maxItems = defaultMaxItems
+	}
+
+	postsDir := filepath.Join(cfg.DataDir, "feeds", "posts")
+	imagesDir := filepath.Join(cfg.DataDir, "feeds", "images")
+	for _, dir := range []string{postsDir, imagesDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Server{
+		log:       cfg.Log,
+		addr:      addr,
+		baseURL:   baseURL,
+		maxItems:  maxItems,
+		postsDir:  postsDir,
+		imagesDir: imagesDir,
+		posts:     make(map[string]*Post),
+	}
+
+	entries, err := os.ReadDir(postsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(postsDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var p Post
+		if err = json.Unmarshal(raw, &p); err != nil {
+			return nil, fmt.Errorf("%v: %w", entry.Name(), err)
+		}
+		s.posts[p.ID] = &p
+	}
+
+	return s, nil
+}
+
+// HandlePost stores a received post so it shows up in the feeds.
+func (s *Server) HandlePost(p *types.ReceivedPost) error {
+	if p.Summary == nil || p.Post == nil {
+		return nil
+	}
+
+	post := &Post{
+		ID:         hex.EncodeToString(p.Summary.Id),
+		AuthorID:   hex.EncodeToString(p.Summary.AuthorId),
+		AuthorNick: p.Summary.AuthorNick,
+		Title:      p.Post.Attributes[rpc.RMPTitle],
+		Body:       p.Post.Attributes[rpc.RMPMain],
+		Date:       time.Unix(p.Summary.Date, 0),
+	}
+	if post.Title == "" {
+		post.Title = p.Summary.Title
+	}
+	if post.AuthorNick == "" {
+		post.AuthorNick = p.Post.Attributes[rpc.RMPFromNick]
+	}
+	if p.Summary.Date == 0 {
+		post.Date = time.Now()
+	}
+
+	raw, err := json.Marshal(post)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.postsDir, post.ID+".json"), raw, 0o600); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	s.posts[post.ID] = post
+	s.mtx.Unlock()
+
+	s.log.Debugf("stored post %v from %v", post.ID, post.AuthorNick)
+	return nil
+}
+
+// Run serves the feeds until the context is canceled.
+func (s *Server) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/atom", s.handleFeed(formatAtom))
+	mux.HandleFunc("/rss", s.handleFeed(formatRSS))
+	mux.HandleFunc("/author/", s.handleAuthorFeed)
+	mux.Handle("/images/", http.StripPrefix("/images/",
+		http.FileServer(http.Dir(s.imagesDir))))
+
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	s.log.Infof("Serving feeds on %v", s.baseURL)
+	err = srv.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return ctx.Err()
+	}
+	return err
+}
+
+type feedFormat int
+
+const (
+	formatAtom feedFormat = iota
+	formatRSS
+)
+
+func (s *Server) handleFeed(format feedFormat) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.writeFeed(w, format, "", "Bison Relay posts", "/"+format.path())
+	}
+}
+
+// handleAuthorFeed serves /author/<id>/atom and /author/<id>/rss.
+func (s *Server) handleAuthorFeed(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/author/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	authorID, kind := parts[0], parts[1]
+
+	var format feedFormat
+	switch kind {
+	case "atom":
+		format = formatAtom
+	case "rss":
+		format = formatRSS
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mtx.Lock()
+	var nick string
+	for _, p := range s.posts {
+		if p.AuthorID == authorID {
+			nick = p.AuthorNick
+			break
+		}
+	}
+	s.mtx.Unlock()
+	if nick == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	title := fmt.Sprintf("Bison Relay posts by %v", nick)
+	s.writeFeed(w, format, authorID, title, r.URL.Path)
+}
+
+func (s *Server) writeFeed(w http.ResponseWriter, format feedFormat, authorID, title, path string) {
+	posts := s.feedPosts(authorID)
+	selfURL := s.baseURL + path
+
+	var (
+		raw []byte
+		err error
+	)
+	switch format {
+	case formatAtom:
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		raw, err = s.atomFeed(title, selfURL, posts)
+	case formatRSS:
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		raw, err = s.rssFeed(title, selfURL, posts)
+	}
+	if err != nil {
+		s.log.Errorf("failed to build feed %v: %v", path, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(raw)
+}
+
+// feedPosts returns the newest posts, optionally filtered by author.
+func (s *Server) feedPosts(authorID string) []*Post {
+	s.mtx.Lock()
+	posts := make([]*Post, 0, len(s.posts))
+	for _, p := range s.posts {
+		if authorID == "" || p.AuthorID == authorID {
+			posts = append(posts, p)
+		}
+	}
+	s.mtx.Unlock()
+
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].Date.After(posts[j].Date)
+	})
+	if len(posts) > s.maxItems {
+		posts = posts[:s.maxItems]
+	}
+	return posts
+}
+
+func (f feedFormat) path() string {
+	if f == formatRSS {
+		return "rss"
+	}
+	return "atom"
+}
+
+// postURL returns a stable identifier for the post.
+func (s *Server) postURL(p *Post) string {
+	return s.baseURL + "/author/" + p.AuthorID + "/atom#" + p.ID
+}
@@ -0,0 +1,125 @@
+package feeds
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/decred/slog"
+)
+
+func testPost(id, author byte, nick, title string, date int64) *types.ReceivedPost {
+	return &types.ReceivedPost{
+		Summary: &types.PostSummary{
+			Id:         []byte{id},
+			AuthorId:   []byte{author},
+			AuthorNick: nick,
+			Date:       date,
+		},
+		Post: &types.PostMetadata{
+			Attributes: map[string]string{rpc.RMPTitle: title, rpc.RMPMain: "body of " + title},
+		},
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	cfg := Config{
+		DataDir:  t.TempDir(),
+		Log:      slog.Disabled,
+		BaseURL:  "https://feeds.example/",
+		MaxItems: 2,
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts := []*types.ReceivedPost{
+		testPost(1, 9, "alice", "oldest", 100),
+		testPost(2, 9, "alice", "middle", 200),
+		testPost(3, 8, "bob", "newest", 300),
+	}
+	for _, p := range posts {
+		if err := s.HandlePost(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Posts are reloaded from disk.
+	s, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(h http.HandlerFunc, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get(s.handleFeed(formatAtom), "/atom")
+	var atom atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &atom); err != nil {
+		t.Fatalf("invalid atom feed %q: %v", w.Body.String(), err)
+	}
+	if len(atom.Entries) != 2 || atom.Entries[0].Title != "newest" || atom.Entries[1].Title != "middle" {
+		t.Fatalf("unexpected atom entries %+v", atom.Entries)
+	}
+
+	w = get(s.handleAuthorFeed, "/author/09/rss")
+	var rss rssFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &rss); err != nil {
+		t.Fatalf("invalid rss feed %q: %v", w.Body.String(), err)
+	}
+	if rss.Channel.Title != "Bison Relay posts by alice" || len(rss.Channel.Items) != 2 {
+		t.Fatalf("unexpected rss channel %+v", rss.Channel)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/rss+xml") {
+		t.Fatalf("content type %q", got)
+	}
+
+	for _, path := range []string{"/author/07/atom", "/author/09/json", "/author/09"} {
+		if w := get(s.handleAuthorFeed, path); w.Code != http.StatusNotFound {
+			t.Fatalf("%v: code %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	s, err := New(Config{DataDir: t.TempDir(), Log: slog.Disabled, BaseURL: "http://x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"# Title\nline one\nline two\n\nnext", "<h1>Title</h1>\n<p>line one<br/>\nline two</p>\n<p>next</p>\n"},
+		{"```\n<b>\n```", "<pre><code>&lt;b&gt;\n</code></pre>\n"},
+		{"**bold** <i>", "<p><strong>bold</strong> &lt;i&gt;</p>\n"},
+		{"see https://example.com", `<p>see <a href="https://example.com">https://example.com</a></p>` + "\n"},
+		{"[docs](https://example.com/d)", `<p><a href="https://example.com/d">docs</a></p>` + "\n"},
+		{"#nospace", "<p>#nospace</p>\n"},
+		{"--embed[type=text/plain,filename=a.txt,data=eA==]--", "<p><em>[file: a.txt]</em></p>\n"},
+	}
+	for _, tc := range tests {
+		if got := s.renderHTML(tc.body); got != tc.want {
+			t.Errorf("renderHTML(%q) = %q, want %q", tc.body, got, tc.want)
+		}
+	}
+
+	// Embedded images are cached and linked.
+	got := s.renderHTML("--embed[type=image/png,alt=a%20cat,data=iVBORw0KGgo=]--")
+	if !strings.Contains(got, `<img src="http://x/images/`) || !strings.Contains(got, `alt="a cat"`) {
+		t.Fatalf("unexpected image %q", got)
+	}
+	files, err := os.ReadDir(s.imagesDir)
+	if err != nil || len(files) != 1 || filepath.Ext(files[0].Name()) != ".png" {
+		t.Fatalf("unexpected image cache %v, %v", files, err)
+	}
+}
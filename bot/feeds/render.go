@@ -0,0 +1,168 @@
+package feeds
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	embedRegexp = regexp.MustCompile(`--embed\[.*?\]--`)
+	linkRegexp  = regexp.MustCompile(`https?://[^\s<]+`)
+	mdLinkRegex = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^)\s]+)\)`)
+	boldRegexp  = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+)
+
+// imageExts are the embedded image types cached and served by the feed
+// server.
+var imageExts = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// renderHTML renders a post body written in markdown to HTML. Only a small
+// subset of markdown is supported: headings, fenced code blocks, bold text,
+// links and paragraphs. Embedded images are written to the image cache and
+// linked from the output.
+func (s *Server) renderHTML(body string) string {
+	var sb strings.Builder
+	var para []string
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		sb.WriteString("<p>")
+		sb.WriteString(strings.Join(para, "<br/>\n"))
+		sb.WriteString("</p>\n")
+		para = para[:0]
+	}
+
+	inCode := false
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			flush()
+			if inCode {
+				sb.WriteString("</code></pre>\n")
+			} else {
+				sb.WriteString("<pre><code>")
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			sb.WriteString(html.EscapeString(line))
+			sb.WriteString("\n")
+			continue
+		}
+		if trimmed == "" {
+			flush()
+			continue
+		}
+		if level := headingLevel(trimmed); level > 0 {
+			flush()
+			text := strings.TrimSpace(trimmed[level:])
+			tag := "h" + string(rune('0'+level))
+			sb.WriteString("<" + tag + ">" + s.renderInline(text) + "</" + tag + ">\n")
+			continue
+		}
+		para = append(para, s.renderInline(line))
+	}
+	if inCode {
+		sb.WriteString("</code></pre>\n")
+	}
+	flush()
+	return sb.String()
+}
+
+func headingLevel(line string) int {
+	level := 0
+	for level < len(line) && level < 6 && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level >= len(line) || line[level] != ' ' {
+		return 0
+	}
+	return level
+}
+
+// renderInline renders a single line of text, replacing embeds, links and
+// bold markers.
+func (s *Server) renderInline(line string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range embedRegexp.FindAllStringIndex(line, -1) {
+		sb.WriteString(renderText(line[last:loc[0]]))
+		sb.WriteString(s.renderEmbed(line[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+	sb.WriteString(renderText(line[last:]))
+	return sb.String()
+}
+
+func renderText(text string) string {
+	text = html.EscapeString(text)
+	if mdLinkRegex.MatchString(text) {
+		text = mdLinkRegex.ReplaceAllString(text, `<a href="$2">$1</a>`)
+	} else {
+		text = linkRegexp.ReplaceAllString(text, `<a href="$0">$0</a>`)
+	}
+	return boldRegexp.ReplaceAllString(text, "<strong>$1</strong>")
+}
+
+// renderEmbed caches an embedded image and returns an img tag pointing to
+// it. Other embeds are rendered as a short description.
+func (s *Server) renderEmbed(raw string) string {
+	var typ, alt, data, filename string
+	args := strings.TrimSuffix(strings.TrimPrefix(raw, "--embed["), "]--")
+	for _, arg := range strings.Split(args, ",") {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "type":
+			typ = kv[1]
+		case "alt":
+			alt, _ = url.PathUnescape(kv[1])
+		case "data":
+			data = kv[1]
+		case "filename":
+			filename = kv[1]
+		}
+	}
+
+	ext, isImage := imageExts[typ]
+	if !isImage || data == "" {
+		if filename != "" {
+			return "<em>[file: " + html.EscapeString(filename) + "]</em>"
+		}
+		return "<em>[embedded content]</em>"
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		s.log.Warnf("invalid embedded image data: %v", err)
+		return "<em>[invalid image]</em>"
+	}
+	sum := sha256.Sum256(decoded)
+	name := hex.EncodeToString(sum[:]) + ext
+	path := filepath.Join(s.imagesDir, name)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.WriteFile(path, decoded, 0o600); err != nil {
+			s.log.Errorf("failed to cache image %v: %v", name, err)
+			return "<em>[image unavailable]</em>"
+		}
+	}
+
+	return `<img src="` + s.baseURL + "/images/" + name + `" alt="` +
+		html.EscapeString(alt) + `"/>`
+}
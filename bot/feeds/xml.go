@@ -0,0 +1,125 @@
+package feeds
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Link    atomLink    `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	Author      string  `xml:"author"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (s *Server) atomFeed(title, selfURL string, posts []*Post) ([]byte, error) {
+	feed := atomFeed{
+		ID:      selfURL,
+		Title:   title,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Link:    atomLink{Rel: "self", Href: selfURL},
+	}
+	if len(posts) > 0 {
+		feed.Updated = posts[0].Date.UTC().Format(time.RFC3339)
+	}
+	for _, p := range posts {
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      "urn:bisonrelay:post:" + p.ID,
+			Title:   p.Title,
+			Updated: p.Date.UTC().Format(time.RFC3339),
+			Author: atomAuthor{
+				Name: p.AuthorNick,
+				URI:  "urn:bisonrelay:user:" + p.AuthorID,
+			},
+			Link: atomLink{Rel: "alternate", Href: s.postURL(p)},
+			Content: atomContent{
+				Type: "html",
+				Body: s.renderHTML(p.Body),
+			},
+		})
+	}
+	return marshalXML(feed)
+}
+
+func (s *Server) rssFeed(title, selfURL string, posts []*Post) ([]byte, error) {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        selfURL,
+			Description: title,
+		},
+	}
+	for _, p := range posts {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       p.Title,
+			Link:        s.postURL(p),
+			GUID:        rssGUID{Value: "urn:bisonrelay:post:" + p.ID},
+			Author:      p.AuthorNick,
+			PubDate:     p.Date.UTC().Format(time.RFC1123Z),
+			Description: s.renderHTML(p.Body),
+		})
+	}
+	return marshalXML(feed)
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	raw, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), raw...), nil
+}
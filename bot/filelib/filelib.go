@@ -0,0 +1,327 @@
+package filelib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+const (
+	defaultRescanInterval = 5 * time.Minute
+	maxListed             = 50
+)
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot bot.API
+
+	// Root is the directory tree served by the library.
+	Root string
+
+	// Public lists folders, relative to Root, whose files anyone may
+	// get. Files in other folders are only sent to whitelisted users.
+	// Use "." to make the whole library public.
+	Public []string
+
+	RescanInterval time.Duration
+}
+
+// File is an indexed file of the library.
+type File struct {
+	Name    string // Slash separated path relative to the root.
+	Size    int64
+	ModTime time.Time
+	Public  bool
+}
+
+// auditEntry is a line of the download audit log.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	UID    string    `json:"uid"`
+	Nick   string    `json:"nick"`
+	File   string    `json:"file"`
+	Result string    `json:"result"`
+}
+
+// Library indexes a directory tree and sends its files on request.
+type Library struct {
+	bot            bot.API
+	log            slog.Logger
+	root           string
+	public         []string
+	rescanInterval time.Duration
+	auditFile      string
+
+	mtx   sync.Mutex
+	files []File
+}
+
+func New(cfg Config) (*Library, error) {
+	if cfg.Root == "" {
+		return nil, fmt.Errorf("file library root is required")
+	}
+	root, err := filepath.Abs(cfg.Root)
+	if err != nil {
+		return nil, err
+	}
+	rescanInterval := cfg.RescanInterval
+	if rescanInterval <= 0 {
+		rescanInterval = defaultRescanInterval
+	}
+
+	public := make([]string, 0, len(cfg.Public))
+	for _, p := range cfg.Public {
+		public = append(public, path.Clean(filepath.ToSlash(p)))
+	}
+
+	l := &Library{
+		bot:            cfg.Bot,
+		log:            cfg.Log,
+		root:           root,
+		public:         public,
+		rescanInterval: rescanInterval,
+		auditFile:      filepath.Join(cfg.DataDir, "filelib-audit.jsonl"),
+	}
+	if err := l.Rescan(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Run rescans the library periodically until the context is canceled.
+func (l *Library) Run(ctx context.Context) error {
+	ticker := time.NewTicker(l.rescanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := l.Rescan(); err != nil {
+				l.log.Errorf("failed to rescan file library: %v", err)
+			}
+		}
+	}
+}
+
+// Rescan rebuilds the index of the library.
+func (l *Library) Rescan() error {
+	var files []File
+	err := filepath.WalkDir(l.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != l.root {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		files = append(files, File{
+			Name:    name,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Public:  l.isPublic(name),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	l.mtx.Lock()
+	l.files = files
+	l.mtx.Unlock()
+	l.log.Debugf("indexed %d files in %v", len(files), l.root)
+	return nil
+}
+
+// isPublic returns whether the file is within a public folder.
+func (l *Library) isPublic(name string) bool {
+	dir := path.Dir(name)
+	for _, p := range l.public {
+		if p == "." || dir == p || strings.HasPrefix(dir, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Search returns the files the user may get whose names contain every
+// term.
+func (l *Library) Search(uid zkidentity.ShortID, terms []string) []File {
+	whitelisted := l.bot.IsWhitelisted(uid)
+
+	defer l.mtx.Unlock()
+	l.mtx.Lock()
+
+	var res []File
+	for _, f := range l.files {
+		if !f.Public && !whitelisted {
+			continue
+		}
+		lower := strings.ToLower(f.Name)
+		match := true
+		for _, term := range terms {
+			if !strings.Contains(lower, strings.ToLower(term)) {
+				match = false
+				break
+			}
+		}
+		if match {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// HandleFilesCommand handles the !files [terms...] command sent by PM.
+func (l *Library) HandleFilesCommand(ctx context.Context, uid zkidentity.ShortID, nick string, args []string) error {
+	files := l.Search(uid, args)
+	if len(files) == 0 {
+		return l.bot.SendPMUser(ctx, bot.UserRefID(uid), "no files found")
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d files:\n", len(files))
+	for i, f := range files {
+		if i == maxListed {
+			fmt.Fprintf(&sb, "... and %d more, refine your search\n", len(files)-maxListed)
+			break
+		}
+		fmt.Fprintf(&sb, "  %v (%v)\n", f.Name, formatSize(f.Size))
+	}
+	sb.WriteString("Get a file with !get <name>")
+	return l.bot.SendPMUser(ctx, bot.UserRefID(uid), sb.String())
+}
+
+// HandleGetCommand handles the !get <name> command sent by PM. The name is
+// either the full path of the file in the library or its base name when it
+// is unique.
+func (l *Library) HandleGetCommand(ctx context.Context, uid zkidentity.ShortID, nick string, args []string) error {
+	if len(args) == 0 {
+		return l.bot.SendPMUser(ctx, bot.UserRefID(uid), "usage: !get <name>")
+	}
+	name := strings.Join(args, " ")
+
+	f, err := l.lookup(name, l.bot.IsWhitelisted(uid))
+	if err != nil {
+		l.audit(uid, nick, name, err.Error())
+		return l.bot.SendPMUser(ctx, bot.UserRefID(uid), err.Error())
+	}
+	if !f.Public && !l.bot.IsWhitelisted(uid) {
+		// Same reply as a missing file to not leak private names.
+		l.audit(uid, nick, f.Name, "denied")
+		return l.bot.SendPMUser(ctx, bot.UserRefID(uid), fmt.Sprintf("file %q not found", name))
+	}
+
+	fullPath := filepath.Join(l.root, filepath.FromSlash(f.Name))
+	if err := l.bot.SendFile(ctx, uid.String(), fullPath); err != nil {
+		l.audit(uid, nick, f.Name, "error: "+err.Error())
+		return err
+	}
+	l.audit(uid, nick, f.Name, "sent")
+	l.log.Infof("sent %v to %v", f.Name, nick)
+	return nil
+}
+
+// lookup returns the file with the given full path or base name. Base names
+// are only matched against the files the user may get, so private files
+// never make a name ambiguous. When only private files match, one of them is
+// returned for the caller to deny.
+func (l *Library) lookup(name string, whitelisted bool) (File, error) {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+
+	defer l.mtx.Unlock()
+	l.mtx.Lock()
+
+	var matches, denied []File
+	for _, f := range l.files {
+		if f.Name == name {
+			return f, nil
+		}
+		if path.Base(f.Name) != name {
+			continue
+		}
+		if f.Public || whitelisted {
+			matches = append(matches, f)
+		} else {
+			denied = append(denied, f)
+		}
+	}
+	switch {
+	case len(matches) == 1:
+		return matches[0], nil
+	case len(matches) > 1:
+		return File{}, fmt.Errorf("%q is ambiguous, use the full path", name)
+	case len(denied) > 0:
+		return denied[0], nil
+	default:
+		return File{}, fmt.Errorf("file %q not found", name)
+	}
+}
+
+// audit appends a download attempt to the audit log.
+func (l *Library) audit(uid zkidentity.ShortID, nick, file, result string) {
+	entry := auditEntry{
+		Time:   time.Now(),
+		UID:    uid.String(),
+		Nick:   nick,
+		File:   file,
+		Result: result,
+	}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		l.log.Errorf("failed to encode audit entry: %v", err)
+		return
+	}
+	f, err := os.OpenFile(l.auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		l.log.Errorf("failed to open audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(raw, '\n')); err != nil {
+		l.log.Errorf("failed to write audit log: %v", err)
+	}
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
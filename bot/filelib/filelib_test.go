@@ -0,0 +1,164 @@
+package filelib
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+func TestLibrary(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	root := t.TempDir()
+	for _, name := range []string{
+		"pub/readme.txt",
+		"pub/docs/guide.pdf",
+		"priv/readme.txt",
+		"priv/notes.txt",
+		".hidden/secret.txt",
+	} {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dataDir := t.TempDir()
+	l, err := New(Config{DataDir: dataDir, Log: slog.Disabled, Bot: b, Root: root, Public: []string{"pub"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var admin, other zkidentity.ShortID
+	admin[0], other[0] = 1, 2
+	if err := b.WhitelistAdd(admin); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(files []File) string {
+		var res []string
+		for _, f := range files {
+			res = append(res, f.Name)
+		}
+		return strings.Join(res, ",")
+	}
+	if got := names(l.Search(other, nil)); got != "pub/docs/guide.pdf,pub/readme.txt" {
+		t.Fatalf("public files %v", got)
+	}
+	if got := names(l.Search(admin, []string{"README"})); got != "priv/readme.txt,pub/readme.txt" {
+		t.Fatalf("admin search %v", got)
+	}
+
+	gets := []struct {
+		uid   zkidentity.ShortID
+		name  string
+		reply string
+	}{
+		// Private files don't make a public base name ambiguous.
+		{other, "readme.txt", ""},
+		{admin, "readme.txt", `"readme.txt" is ambiguous, use the full path`},
+		{other, "notes.txt", `file "notes.txt" not found`},
+		{other, "priv/notes.txt", `file "priv/notes.txt" not found`},
+		{other, "../pub/readme.txt", ""},
+		{other, ".hidden/secret.txt", `file ".hidden/secret.txt" not found`},
+		{admin, "priv/notes.txt", ""},
+	}
+	for _, g := range gets {
+		before := len(srv.PMs())
+		if err := l.HandleGetCommand(ctx, g.uid, "u", strings.Fields(g.name)); err != nil {
+			t.Fatal(err)
+		}
+		pms := srv.PMs()
+		switch {
+		case g.reply == "" && len(pms) != before:
+			t.Fatalf("get %v: unexpected reply %q", g.name, pms[len(pms)-1].Msg.Message)
+		case g.reply != "" && (len(pms) == before || pms[len(pms)-1].Msg.Message != g.reply):
+			t.Fatalf("get %v: missing reply %q", g.name, g.reply)
+		}
+	}
+	files := srv.Files()
+	want := []string{"pub/readme.txt", "pub/readme.txt", "priv/notes.txt"}
+	if len(files) != len(want) {
+		t.Fatalf("unexpected files sent %v", files)
+	}
+	for i, f := range files {
+		if f.Filename != filepath.Join(root, filepath.FromSlash(want[i])) {
+			t.Fatalf("file %d: %v, want %v", i, f.Filename, want[i])
+		}
+	}
+
+	// Every attempt is audited, denials under the real name.
+	f, err := os.Open(filepath.Join(dataDir, "filelib-audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var results []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, entry.File+" "+entry.Result)
+	}
+	wantAudit := []string{
+		"pub/readme.txt sent",
+		`readme.txt "readme.txt" is ambiguous, use the full path`,
+		"priv/notes.txt denied",
+		"priv/notes.txt denied",
+		"pub/readme.txt sent",
+		`.hidden/secret.txt file ".hidden/secret.txt" not found`,
+		"priv/notes.txt sent",
+	}
+	if strings.Join(results, "\n") != strings.Join(wantAudit, "\n") {
+		t.Fatalf("unexpected audit log:\n%v", strings.Join(results, "\n"))
+	}
+}
+
+func TestFilesCommand(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), make([]byte, 1536), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := New(Config{DataDir: t.TempDir(), Log: slog.Disabled, Bot: b, Root: root, Public: []string{"."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uid zkidentity.ShortID
+	uid[0] = 1
+	for _, args := range [][]string{{"a"}, {"missing"}} {
+		if err := l.HandleFilesCommand(ctx, uid, "u", args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pms := srv.PMs()
+	if len(pms) != 2 || pms[0].User != uid.String() || pms[0].Msg.Message != "1 files:\n  a.txt (1.5 KiB)\nGet a file with !get <name>" ||
+		pms[1].Msg.Message != "no files found" {
+		t.Fatalf("unexpected replies %v", pms)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:       "0 B",
+		1023:    "1023 B",
+		1024:    "1.0 KiB",
+		1 << 20: "1.0 MiB",
+		5 << 30: "5.0 GiB",
+	}
+	for n, want := range tests {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d) = %v, want %v", n, got, want)
+		}
+	}
+}
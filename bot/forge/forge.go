@@ -0,0 +1,358 @@
+// Package forge turns webhooks of git forges into GC messages.
+//
+// GitHub, Gitea (and Forgejo) and GitLab webhooks are accepted on any path.
+// The forge is detected from the request headers and the request is
+// verified with the shared secret: GitHub and Gitea sign the body with it
+// and GitLab sends it in the X-Gitlab-Token header.
+//
+// Push, pull request, release and CI events are supported. Other events are
+// acknowledged and ignored.
+package forge
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/decred/slog"
+)
+
+// Event kinds.
+const (
+	EventPush        = "push"
+	EventTag         = "tag"
+	EventPullRequest = "pull_request"
+	EventRelease     = "release"
+	EventCI          = "ci"
+)
+
+// Forges.
+const (
+	GitHub = "github"
+	Gitea  = "gitea"
+	GitLab = "gitlab"
+)
+
+const (
+	defaultMaxCommits = 5
+	maxBody           = 5 << 20
+
+	// sendTimeout bounds the sends of one event, which outlive the
+	// webhook request.
+	sendTimeout = time.Minute
+)
+
+// Repo routes the events of the repositories matching Name to GCs.
+type Repo struct {
+	// Name is matched against the full name of the repository, such as
+	// "owner/repo", with path.Match patterns like "owner/*".
+	Name string
+
+	GCs []string
+
+	// Events are the event kinds sent. An empty list sends every kind.
+	Events []string
+
+	// Branches are path.Match patterns of the branches whose push, pull
+	// request and CI events are sent. Pull requests match their target
+	// branch. An empty list matches every branch.
+	Branches []string
+}
+
+func (r *Repo) matches(ev *Event) bool {
+	if ok, _ := path.Match(r.Name, ev.Repo); !ok {
+		return false
+	}
+	if len(r.Events) > 0 && !contains(r.Events, ev.Kind) {
+		return false
+	}
+	if ev.Branch == "" || len(r.Branches) == 0 {
+		return true
+	}
+	for _, b := range r.Branches {
+		if ok, _ := path.Match(b, ev.Branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type Config struct {
+	Log slog.Logger
+	Bot bot.API
+
+	// Addr is the address Run listens on.
+	Addr string
+
+	// Secret verifies the requests. It must be configured in the
+	// webhooks of the forges.
+	Secret string
+
+	// Repos are tried in order and every matching repo receives the
+	// event.
+	Repos []Repo
+
+	// MaxCommits is the number of commits listed in push messages.
+	// Defaults to 5.
+	MaxCommits int
+}
+
+// Event is a forge event reduced to what is needed to route and announce
+// it.
+type Event struct {
+	Kind   string
+	Repo   string
+	Branch string
+	Msg    string
+}
+
+// Receiver receives forge webhooks.
+type Receiver struct {
+	bot        bot.API
+	log        slog.Logger
+	addr       string
+	secret     string
+	repos      []Repo
+	maxCommits int
+
+	// sends tracks the events still being sent.
+	sends sync.WaitGroup
+}
+
+func New(cfg Config) (*Receiver, error) {
+	if cfg.Secret == "" {
+		return nil, errors.New("secret is required")
+	}
+	for i, r := range cfg.Repos {
+		if _, err := path.Match(r.Name, ""); err != nil {
+			return nil, fmt.Errorf("repo %d: invalid name %q", i, r.Name)
+		}
+		if len(r.GCs) == 0 {
+			return nil, fmt.Errorf("repo %v: no gcs", r.Name)
+		}
+		for _, b := range r.Branches {
+			if _, err := path.Match(b, ""); err != nil {
+				return nil, fmt.Errorf("repo %v: invalid branch %q", r.Name, b)
+			}
+		}
+		for _, e := range r.Events {
+			switch e {
+			case EventPush, EventTag, EventPullRequest, EventRelease, EventCI:
+			default:
+				return nil, fmt.Errorf("repo %v: unknown event %q", r.Name, e)
+			}
+		}
+	}
+
+	maxCommits := cfg.MaxCommits
+	if maxCommits <= 0 {
+		maxCommits = defaultMaxCommits
+	}
+
+	return &Receiver{
+		bot:        cfg.Bot,
+		log:        cfg.Log,
+		addr:       cfg.Addr,
+		secret:     cfg.Secret,
+		repos:      cfg.Repos,
+		maxCommits: maxCommits,
+	}, nil
+}
+
+// Run serves the receiver on the configured address until the context is
+// canceled.
+func (r *Receiver) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", r.addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	r.log.Infof("Receiving forge webhooks on http://%v", l.Addr())
+	err = srv.Serve(l)
+	r.sends.Wait()
+	if errors.Is(err, http.ErrServerClosed) {
+		return ctx.Err()
+	}
+	return err
+}
+
+// detect returns the forge that sent the request and the event name in its
+// headers. Gitea also sends the GitHub headers, so it is checked first.
+func detect(h http.Header) (string, string) {
+	switch {
+	case h.Get("X-Gitea-Event") != "":
+		return Gitea, h.Get("X-Gitea-Event")
+	case h.Get("X-Forgejo-Event") != "":
+		return Gitea, h.Get("X-Forgejo-Event")
+	case h.Get("X-Gitlab-Event") != "":
+		return GitLab, h.Get("X-Gitlab-Event")
+	case h.Get("X-GitHub-Event") != "":
+		return GitHub, h.Get("X-GitHub-Event")
+	}
+	return "", ""
+}
+
+func (r *Receiver) verify(forge string, h http.Header, body []byte) bool {
+	if forge == GitLab {
+		token := []byte(h.Get("X-Gitlab-Token"))
+		return subtle.ConstantTimeCompare(token, []byte(r.secret)) == 1
+	}
+
+	mac := hmac.New(sha256.New, []byte(r.secret))
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	var got string
+	switch {
+	case forge == GitHub:
+		got = strings.TrimPrefix(h.Get("X-Hub-Signature-256"), "sha256=")
+	case h.Get("X-Gitea-Signature") != "":
+		got = h.Get("X-Gitea-Signature")
+	default:
+		got = h.Get("X-Forgejo-Signature")
+	}
+	return hmac.Equal([]byte(got), []byte(want))
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	forge, name := detect(req.Header)
+	if forge == "" {
+		http.Error(w, "unknown forge", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxBody))
+	if err != nil {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !r.verify(forge, req.Header, body) {
+		r.log.Warnf("Rejected %v %v webhook with a bad secret from %v",
+			forge, name, req.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var ev *Event
+	if forge == GitLab {
+		ev, err = parseGitLab(body, r.maxCommits)
+	} else {
+		ev, err = parseGitHub(name, body, r.maxCommits)
+	}
+	if err != nil {
+		r.log.Warnf("Unable to parse %v %v webhook: %v", forge, name, err)
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if ev == nil {
+		r.log.Debugf("Ignoring %v %v webhook", forge, name)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// The forge is answered before the event is sent, so slow GCs do not
+	// time out its request and make it redeliver the event.
+	w.WriteHeader(http.StatusNoContent)
+	r.sends.Add(1)
+	go func() {
+		defer r.sends.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		r.dispatch(ctx, ev)
+	}()
+}
+
+// dispatch sends ev to the GCs of every matching repo, once per GC.
+func (r *Receiver) dispatch(ctx context.Context, ev *Event) {
+	sent := make(map[string]bool)
+	for i := range r.repos {
+		repo := &r.repos[i]
+		if !repo.matches(ev) {
+			continue
+		}
+		for _, gc := range repo.GCs {
+			if sent[gc] {
+				continue
+			}
+			sent[gc] = true
+			if err := r.bot.SendGC(ctx, gc, ev.Msg); err != nil {
+				r.log.Errorf("failed to send %v event of %v to gc %v: %v",
+					ev.Kind, ev.Repo, gc, err)
+			}
+		}
+	}
+	if len(sent) == 0 {
+		r.log.Debugf("No route for %v event of %v on %q", ev.Kind, ev.Repo, ev.Branch)
+	}
+}
+
+type commit struct {
+	ID      string
+	Message string
+	Author  string
+}
+
+// pushMsg formats a push of commits to a branch.
+func pushMsg(repo, actor, branch, url string, commits []commit, total, max int) string {
+	var sb strings.Builder
+	noun := "commits"
+	if total == 1 {
+		noun = "commit"
+	}
+	fmt.Fprintf(&sb, "[%v] %v pushed %d %v to %v", repo, actor, total, noun, branch)
+	if url != "" {
+		fmt.Fprintf(&sb, ": %v", url)
+	}
+	for i, c := range commits {
+		if i == max {
+			fmt.Fprintf(&sb, "\n  ... and %d more", total-max)
+			break
+		}
+		id := c.ID
+		if len(id) > 7 {
+			id = id[:7]
+		}
+		title, _, _ := strings.Cut(c.Message, "\n")
+		fmt.Fprintf(&sb, "\n  %v %v (%v)", id, title, c.Author)
+	}
+	return sb.String()
+}
+
+func isZeroSHA(s string) bool {
+	return s != "" && strings.Trim(s, "0") == ""
+}
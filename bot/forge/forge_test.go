@@ -0,0 +1,94 @@
+package forge
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/decred/slog"
+)
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerify(t *testing.T) {
+	r := &Receiver{secret: "secret"}
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	header := func(kv ...string) http.Header {
+		h := make(http.Header)
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	tests := []struct {
+		desc  string
+		forge string
+		h     http.Header
+		body  []byte
+		ok    bool
+	}{
+		{"github", GitHub, header("X-Hub-Signature-256", "sha256="+sign("secret", body)), body, true},
+		{"github wrong secret", GitHub, header("X-Hub-Signature-256", "sha256="+sign("other", body)), body, false},
+		{"github altered body", GitHub, header("X-Hub-Signature-256", "sha256="+sign("secret", body)), []byte(`{}`), false},
+		{"github missing signature", GitHub, header(), body, false},
+		{"gitea", Gitea, header("X-Gitea-Signature", sign("secret", body)), body, true},
+		{"gitea wrong secret", Gitea, header("X-Gitea-Signature", sign("other", body)), body, false},
+		{"gitea altered body", Gitea, header("X-Gitea-Signature", sign("secret", body)), []byte(`{}`), false},
+		{"forgejo", Gitea, header("X-Forgejo-Signature", sign("secret", body)), body, true},
+		{"gitea missing signature", Gitea, header(), body, false},
+		{"gitlab", GitLab, header("X-Gitlab-Token", "secret"), body, true},
+		{"gitlab wrong token", GitLab, header("X-Gitlab-Token", "other"), body, false},
+		{"gitlab signature instead of token", GitLab, header("X-Gitlab-Token", sign("secret", body)), body, false},
+		{"gitlab missing token", GitLab, header(), body, false},
+	}
+	for _, tc := range tests {
+		if got := r.verify(tc.forge, tc.h, tc.body); got != tc.ok {
+			t.Errorf("%v: got %v, want %v", tc.desc, got, tc.ok)
+		}
+	}
+}
+
+func TestEventSentAfterRequest(t *testing.T) {
+	_, srv, b := bottest.NewBot(t)
+	r, err := New(Config{
+		Log:    slog.Disabled,
+		Bot:    b,
+		Secret: "secret",
+		Repos:  []Repo{{Name: "owner/*", GCs: []string{"dev"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"ref":"refs/heads/main","after":"abc","repository":{"full_name":"owner/repo"},` +
+		`"pusher":{"login":"alice"},"commits":[{"id":"abc","message":"fix","author":{"name":"alice"}}]}`)
+	// The forge hangs up as soon as it is answered: the event is still
+	// sent.
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)).WithContext(ctx)
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+sign("secret", body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	cancel()
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d", w.Code)
+	}
+
+	r.sends.Wait()
+	gcms := srv.GCMs()
+	if len(gcms) != 1 || gcms[0].Gc != "dev" {
+		t.Fatalf("sent %+v", gcms)
+	}
+}
@@ -0,0 +1,231 @@
+package forge
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ghUser covers the user objects of GitHub and Gitea.
+type ghUser struct {
+	Login    string `json:"login"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+}
+
+func (u *ghUser) String() string {
+	switch {
+	case u.Login != "":
+		return u.Login
+	case u.Username != "":
+		return u.Username
+	}
+	return u.Name
+}
+
+// ghPayload covers the fields used from the GitHub and Gitea payloads of the
+// supported events.
+type ghPayload struct {
+	Action     string `json:"action"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Sender ghUser `json:"sender"`
+
+	// push
+	Ref        string `json:"ref"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+	Compare    string `json:"compare"`
+	CompareURL string `json:"compare_url"`
+	Pusher     ghUser `json:"pusher"`
+	Commits    []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  ghUser `json:"author"`
+	} `json:"commits"`
+	TotalCommits int `json:"total_commits"`
+
+	// pull_request
+	Number      int `json:"number"`
+	PullRequest struct {
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+		Merged  bool   `json:"merged"`
+		Head    struct {
+			Ref string `json:"ref"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+
+	// release
+	Release struct {
+		TagName string `json:"tag_name"`
+		Name    string `json:"name"`
+		HTMLURL string `json:"html_url"`
+	} `json:"release"`
+
+	// workflow_run
+	WorkflowRun struct {
+		Name       string `json:"name"`
+		HeadBranch string `json:"head_branch"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+	} `json:"workflow_run"`
+
+	// status
+	State       string `json:"state"`
+	Context     string `json:"context"`
+	Description string `json:"description"`
+	TargetURL   string `json:"target_url"`
+	Branches    []struct {
+		Name string `json:"name"`
+	} `json:"branches"`
+}
+
+// parseGitHub parses the GitHub and Gitea events named name. It returns nil
+// for events that are not announced.
+func parseGitHub(name string, body []byte, maxCommits int) (*Event, error) {
+	var p ghPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	repo := p.Repository.FullName
+	if repo == "" {
+		return nil, fmt.Errorf("no repository")
+	}
+
+	switch name {
+	case "push":
+		return ghPush(&p, maxCommits), nil
+
+	case "pull_request":
+		action := p.Action
+		switch {
+		case action == "closed" && p.PullRequest.Merged:
+			action = "merged"
+		case action == "opened", action == "reopened", action == "closed",
+			action == "ready_for_review":
+		default:
+			return nil, nil
+		}
+		action = strings.ReplaceAll(action, "_", " ")
+		return &Event{
+			Kind:   EventPullRequest,
+			Repo:   repo,
+			Branch: p.PullRequest.Base.Ref,
+			Msg: fmt.Sprintf("[%v] %v %v PR #%d: %v (%v → %v) %v", repo,
+				p.Sender.String(), action, p.Number, p.PullRequest.Title,
+				p.PullRequest.Head.Ref, p.PullRequest.Base.Ref,
+				p.PullRequest.HTMLURL),
+		}, nil
+
+	case "release":
+		if p.Action != "published" {
+			return nil, nil
+		}
+		msg := fmt.Sprintf("[%v] %v published release %v", repo,
+			p.Sender.String(), p.Release.TagName)
+		if p.Release.Name != "" && p.Release.Name != p.Release.TagName {
+			msg += ": " + p.Release.Name
+		}
+		return &Event{
+			Kind: EventRelease,
+			Repo: repo,
+			Msg:  msg + " " + p.Release.HTMLURL,
+		}, nil
+
+	case "workflow_run":
+		if p.Action != "completed" {
+			return nil, nil
+		}
+		run := &p.WorkflowRun
+		return &Event{
+			Kind:   EventCI,
+			Repo:   repo,
+			Branch: run.HeadBranch,
+			Msg: fmt.Sprintf("[%v] CI %v on %v: %v %v", repo, run.Name,
+				run.HeadBranch, run.Conclusion, run.HTMLURL),
+		}, nil
+
+	case "status":
+		if p.State == "pending" {
+			return nil, nil
+		}
+		var branch string
+		if len(p.Branches) > 0 {
+			branch = p.Branches[0].Name
+		}
+		msg := fmt.Sprintf("[%v] CI %v", repo, p.Context)
+		if branch != "" {
+			msg += " on " + branch
+		}
+		msg += ": " + p.State
+		if p.Description != "" {
+			msg += " (" + p.Description + ")"
+		}
+		if p.TargetURL != "" {
+			msg += " " + p.TargetURL
+		}
+		return &Event{
+			Kind:   EventCI,
+			Repo:   repo,
+			Branch: branch,
+			Msg:    msg,
+		}, nil
+	}
+	return nil, nil
+}
+
+func ghPush(p *ghPayload, maxCommits int) *Event {
+	repo := p.Repository.FullName
+	actor := p.Pusher.String()
+	if actor == "" {
+		actor = p.Sender.String()
+	}
+
+	if tag := strings.TrimPrefix(p.Ref, "refs/tags/"); tag != p.Ref {
+		if isZeroSHA(p.After) {
+			return nil
+		}
+		return &Event{
+			Kind: EventTag,
+			Repo: repo,
+			Msg:  fmt.Sprintf("[%v] %v pushed tag %v", repo, actor, tag),
+		}
+	}
+
+	branch := strings.TrimPrefix(p.Ref, "refs/heads/")
+	if isZeroSHA(p.After) {
+		return &Event{
+			Kind:   EventPush,
+			Repo:   repo,
+			Branch: branch,
+			Msg:    fmt.Sprintf("[%v] %v deleted branch %v", repo, actor, branch),
+		}
+	}
+	if len(p.Commits) == 0 {
+		return nil
+	}
+
+	commits := make([]commit, 0, len(p.Commits))
+	for _, c := range p.Commits {
+		commits = append(commits, commit{ID: c.ID, Message: c.Message, Author: c.Author.String()})
+	}
+	total := p.TotalCommits
+	if total < len(commits) {
+		total = len(commits)
+	}
+	url := p.Compare
+	if url == "" {
+		url = p.CompareURL
+	}
+	return &Event{
+		Kind:   EventPush,
+		Repo:   repo,
+		Branch: branch,
+		Msg:    pushMsg(repo, actor, branch, url, commits, total, maxCommits),
+	}
+}
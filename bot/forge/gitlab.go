@@ -0,0 +1,171 @@
+package forge
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// glPayload covers the fields used from the GitLab payloads of the supported
+// events.
+type glPayload struct {
+	ObjectKind string `json:"object_kind"`
+	Project    struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+
+	// push and tag_push
+	Ref          string `json:"ref"`
+	Before       string `json:"before"`
+	After        string `json:"after"`
+	UserUsername string `json:"user_username"`
+	Commits      []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+	TotalCommitsCount int `json:"total_commits_count"`
+
+	// merge_request and pipeline
+	ObjectAttributes struct {
+		ID           int    `json:"id"`
+		IID          int    `json:"iid"`
+		Title        string `json:"title"`
+		Action       string `json:"action"`
+		URL          string `json:"url"`
+		SourceBranch string `json:"source_branch"`
+		TargetBranch string `json:"target_branch"`
+		Ref          string `json:"ref"`
+		Status       string `json:"status"`
+	} `json:"object_attributes"`
+
+	// release
+	Action string `json:"action"`
+	Tag    string `json:"tag"`
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+}
+
+// parseGitLab parses a GitLab event. It returns nil for events that are not
+// announced.
+func parseGitLab(body []byte, maxCommits int) (*Event, error) {
+	var p glPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	repo := p.Project.PathWithNamespace
+	if repo == "" {
+		return nil, fmt.Errorf("no project")
+	}
+	attrs := &p.ObjectAttributes
+
+	switch p.ObjectKind {
+	case "push":
+		branch := strings.TrimPrefix(p.Ref, "refs/heads/")
+		if isZeroSHA(p.After) {
+			return &Event{
+				Kind:   EventPush,
+				Repo:   repo,
+				Branch: branch,
+				Msg: fmt.Sprintf("[%v] %v deleted branch %v", repo,
+					p.UserUsername, branch),
+			}, nil
+		}
+		if len(p.Commits) == 0 {
+			return nil, nil
+		}
+		commits := make([]commit, 0, len(p.Commits))
+		for _, c := range p.Commits {
+			commits = append(commits, commit{ID: c.ID, Message: c.Message, Author: c.Author.Name})
+		}
+		total := p.TotalCommitsCount
+		if total < len(commits) {
+			total = len(commits)
+		}
+		var url string
+		if p.Project.WebURL != "" && !isZeroSHA(p.Before) {
+			url = fmt.Sprintf("%v/-/compare/%v...%v", p.Project.WebURL,
+				p.Before, p.After)
+		}
+		return &Event{
+			Kind:   EventPush,
+			Repo:   repo,
+			Branch: branch,
+			Msg: pushMsg(repo, p.UserUsername, branch, url, commits,
+				total, maxCommits),
+		}, nil
+
+	case "tag_push":
+		if isZeroSHA(p.After) {
+			return nil, nil
+		}
+		return &Event{
+			Kind: EventTag,
+			Repo: repo,
+			Msg: fmt.Sprintf("[%v] %v pushed tag %v", repo, p.UserUsername,
+				strings.TrimPrefix(p.Ref, "refs/tags/")),
+		}, nil
+
+	case "merge_request":
+		var action string
+		switch attrs.Action {
+		case "open":
+			action = "opened"
+		case "reopen":
+			action = "reopened"
+		case "close":
+			action = "closed"
+		case "merge":
+			action = "merged"
+		default:
+			return nil, nil
+		}
+		return &Event{
+			Kind:   EventPullRequest,
+			Repo:   repo,
+			Branch: attrs.TargetBranch,
+			Msg: fmt.Sprintf("[%v] %v %v MR !%d: %v (%v → %v) %v", repo,
+				p.User.Username, action, attrs.IID, attrs.Title,
+				attrs.SourceBranch, attrs.TargetBranch, attrs.URL),
+		}, nil
+
+	case "release":
+		if p.Action != "create" {
+			return nil, nil
+		}
+		msg := fmt.Sprintf("[%v] published release %v", repo, p.Tag)
+		if p.Name != "" && p.Name != p.Tag {
+			msg += ": " + p.Name
+		}
+		return &Event{
+			Kind: EventRelease,
+			Repo: repo,
+			Msg:  msg + " " + p.URL,
+		}, nil
+
+	case "pipeline":
+		switch attrs.Status {
+		case "success", "failed", "canceled":
+		default:
+			return nil, nil
+		}
+		msg := fmt.Sprintf("[%v] CI pipeline #%d on %v: %v", repo, attrs.ID,
+			attrs.Ref, attrs.Status)
+		if p.Project.WebURL != "" {
+			msg += fmt.Sprintf(" %v/-/pipelines/%d", p.Project.WebURL, attrs.ID)
+		}
+		return &Event{
+			Kind:   EventCI,
+			Repo:   repo,
+			Branch: attrs.Ref,
+			Msg:    msg,
+		}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,703 @@
+// Package gateway implements an authenticated HTTP API to send messages,
+// files and tips through the bot.
+//
+// Requests carry a token in an "Authorization: Bearer <token>" header. Each
+// token is limited to a set of actions, GCs and users, and to a request rate.
+// Requests with an Idempotency-Key header are only performed once per token
+// and key: retries get the stored result of the first successful request.
+// Failed requests are not stored, so they may be retried with the same key.
+//
+// Endpoints:
+//
+//	POST /v1/gc    {"gc": "<alias>", "msg": "<text>"}
+//	POST /v1/pm    {"user": "<id or nick>", "msg": "<text>"}
+//	POST /v1/tip   {"user": "<id or nick>", "amount_dcr": <amount>}
+//	POST /v1/file  multipart form with a "user" field and a "file" part
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/slog"
+)
+
+// Actions a token may be allowed to perform.
+const (
+	ActionGC   = "gc"
+	ActionPM   = "pm"
+	ActionFile = "file"
+	ActionTip  = "tip"
+)
+
+// Delivery statuses of a Result.
+const (
+	// StatusSent means the message or file was handed to the client for
+	// delivery.
+	StatusSent = "sent"
+
+	// StatusTipRequested means the tip was requested. The payment
+	// happens in the background and may still fail.
+	StatusTipRequested = "tip_requested"
+
+	StatusFailed = "failed"
+)
+
+const (
+	defaultRatePerMinute = 60
+	defaultBurst         = 10
+	defaultMaxUpload     = 10 << 20
+	defaultTipAttempts   = 3
+
+	maxJSONBody    = 64 << 10
+	idempotencyTTL = 24 * time.Hour
+	uploadTTL      = 24 * time.Hour
+	pruneInterval  = time.Hour
+)
+
+// Token grants access to the gateway.
+type Token struct {
+	// Name identifies the token in logs.
+	Name  string
+	Token string
+
+	// Actions are the Action constants the token may perform.
+	Actions []string
+
+	// GCs are the aliases of the GCs the token may send to. Users are the
+	// IDs or nicks of the users the token may send to or tip. "*" allows
+	// any GC or user. Nicks are resolved to IDs by New, so the token
+	// keeps reaching the same users if they or others change nicks.
+	GCs   []string
+	Users []string
+
+	// MaxTip is the largest tip the token may send. Tips are refused when
+	// zero.
+	MaxTip dcrutil.Amount
+
+	// RatePerMinute and Burst limit the requests made with the token.
+	// They default to 60 and 10.
+	RatePerMinute int
+	Burst         int
+
+	// anyUser and userIDs are Users resolved by New.
+	anyUser bool
+	userIDs map[zkidentity.ShortID]bool
+}
+
+func (t *Token) allows(action string) bool {
+	for _, a := range t.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Token) allowsGC(gc string) bool {
+	for _, g := range t.GCs {
+		if g == "*" || strings.EqualFold(g, gc) {
+			return true
+		}
+	}
+	return false
+}
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot bot.API
+
+	// Addr is the address Run listens on. It should be a loopback
+	// address unless the gateway is behind a TLS terminating proxy.
+	Addr   string
+	Tokens []Token
+
+	// MaxUploadSize is the largest file accepted by /v1/file. Defaults
+	// to 10MiB.
+	MaxUploadSize int64
+}
+
+// Result is the JSON body of every response.
+type Result struct {
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type limiter struct {
+	tokens float64
+	last   time.Time
+}
+
+// idempotent is the stored result of a request with an Idempotency-Key.
+// Entries without a result are in progress and are not persisted.
+type idempotent struct {
+	Hash    string `json:"hash"`
+	Code    int    `json:"code"`
+	Result  Result `json:"result"`
+	Created int64  `json:"created"`
+	done    bool
+}
+
+// Gateway serves the HTTP API.
+type Gateway struct {
+	bot       bot.API
+	log       slog.Logger
+	addr      string
+	tokens    []*Token
+	maxUpload int64
+	uploadDir string
+
+	limitersMtx sync.Mutex
+	limiters    map[string]*limiter
+
+	idemMtx  sync.Mutex
+	idem     map[string]*idempotent
+	idemFile string
+}
+
+func New(cfg Config) (*Gateway, error) {
+	tokens := make([]*Token, 0, len(cfg.Tokens))
+	for i := range cfg.Tokens {
+		t := cfg.Tokens[i]
+		if t.Name == "" {
+			return nil, errors.New("token without name")
+		}
+		if len(t.Token) < 16 {
+			return nil, fmt.Errorf("token %v: must have at least 16 characters", t.Name)
+		}
+		for _, a := range t.Actions {
+			switch a {
+			case ActionGC, ActionPM, ActionFile, ActionTip:
+			default:
+				return nil, fmt.Errorf("token %v: unknown action %q", t.Name, a)
+			}
+		}
+		if t.RatePerMinute <= 0 {
+			t.RatePerMinute = defaultRatePerMinute
+		}
+		if t.Burst <= 0 {
+			t.Burst = defaultBurst
+		}
+		t.userIDs = make(map[zkidentity.ShortID]bool, len(t.Users))
+		for _, u := range t.Users {
+			if u == "*" {
+				t.anyUser = true
+				continue
+			}
+			id, err := cfg.Bot.ResolveUser(bot.ParseUserRef(u))
+			if err != nil {
+				return nil, fmt.Errorf("token %v: user %q: %w", t.Name, u, err)
+			}
+			t.userIDs[id] = true
+		}
+		tokens = append(tokens, &t)
+	}
+
+	maxUpload := cfg.MaxUploadSize
+	if maxUpload <= 0 {
+		maxUpload = defaultMaxUpload
+	}
+
+	dir := filepath.Join(cfg.DataDir, "gateway")
+	uploadDir := filepath.Join(dir, "uploads")
+	if err := os.MkdirAll(uploadDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	idem := make(map[string]*idempotent)
+	idemFile := filepath.Join(dir, "idempotency.json")
+	idemBytes, err := os.ReadFile(idemFile)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(idemBytes, &idem); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range idem {
+		e.done = true
+	}
+
+	return &Gateway{
+		bot:       cfg.Bot,
+		log:       cfg.Log,
+		addr:      cfg.Addr,
+		tokens:    tokens,
+		maxUpload: maxUpload,
+		uploadDir: uploadDir,
+
+		limiters: make(map[string]*limiter),
+
+		idem:     idem,
+		idemFile: idemFile,
+	}, nil
+}
+
+// Run serves the API on the configured address and prunes old uploads and
+// idempotency keys until the context is canceled.
+func (g *Gateway) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", g.addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           g.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	go g.pruneLoop(ctx)
+
+	g.log.Infof("Serving gateway on http://%v", l.Addr())
+	err = srv.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (g *Gateway) pruneLoop(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		if err := g.prune(time.Now()); err != nil {
+			g.log.Errorf("failed to prune gateway state: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune removes expired idempotency keys and uploads. Uploads are kept for a
+// while since the client reads them in the background while sending.
+func (g *Gateway) prune(now time.Time) error {
+	g.idemMtx.Lock()
+	var changed bool
+	for k, e := range g.idem {
+		if e.done && now.Sub(time.Unix(e.Created, 0)) > idempotencyTTL {
+			delete(g.idem, k)
+			changed = true
+		}
+	}
+	var err error
+	if changed {
+		err = g.saveIdem()
+	}
+	g.idemMtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(g.uploadDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) > uploadTTL {
+			if err := os.Remove(filepath.Join(g.uploadDir, e.Name())); err != nil {
+				g.log.Warnf("failed to remove upload %v: %v", e.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// saveIdem writes the completed idempotency entries to disk.
+// Must be called with the mutex held.
+func (g *Gateway) saveIdem() error {
+	done := make(map[string]*idempotent, len(g.idem))
+	for k, e := range g.idem {
+		if e.done {
+			done[k] = e
+		}
+	}
+	raw, err := json.Marshal(done)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(g.idemFile, raw, 0o600)
+}
+
+// Handler returns the HTTP handler of the API.
+func (g *Gateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/gc", g.endpoint(ActionGC, g.handleGC))
+	mux.Handle("/v1/pm", g.endpoint(ActionPM, g.handlePM))
+	mux.Handle("/v1/tip", g.endpoint(ActionTip, g.handleTip))
+	mux.Handle("/v1/file", g.endpoint(ActionFile, g.handleFile))
+	return mux
+}
+
+// httpError is an error with the status code it is reported with.
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func errorf(code int, format string, args ...interface{}) error {
+	return &httpError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+type handlerFunc func(ctx context.Context, t *Token, r *http.Request, body []byte) (Result, error)
+
+func writeResult(w http.ResponseWriter, code int, res *Result) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// outcome returns the status code and result reported for the values
+// returned by a handler. Errors other than httpError come from the bot and
+// are reported as 502.
+func outcome(res Result, err error) (int, Result) {
+	if err == nil {
+		return http.StatusOK, res
+	}
+	code := http.StatusBadGateway
+	var herr *httpError
+	if errors.As(err, &herr) {
+		code = herr.code
+	}
+	res.Status = StatusFailed
+	res.Error = err.Error()
+	return code, res
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code, res := outcome(Result{}, err)
+	writeResult(w, code, &res)
+}
+
+func (g *Gateway) authenticate(r *http.Request) *Token {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil
+	}
+	secret := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for _, t := range g.tokens {
+		if subtle.ConstantTimeCompare(secret, []byte(t.Token)) == 1 {
+			return t
+		}
+	}
+	return nil
+}
+
+// allow takes a request from the token bucket of t. It returns how long to
+// wait before retrying when the bucket is empty.
+func (g *Gateway) allow(t *Token, now time.Time) (bool, time.Duration) {
+	defer g.limitersMtx.Unlock()
+	g.limitersMtx.Lock()
+
+	rate := float64(t.RatePerMinute) / 60
+	l, ok := g.limiters[t.Name]
+	if !ok {
+		l = &limiter{tokens: float64(t.Burst), last: now}
+		g.limiters[t.Name] = l
+	}
+	l.tokens = math.Min(float64(t.Burst), l.tokens+now.Sub(l.last).Seconds()*rate)
+	l.last = now
+	if l.tokens < 1 {
+		wait := time.Duration((1 - l.tokens) / rate * float64(time.Second))
+		return false, wait
+	}
+	l.tokens--
+	return true, 0
+}
+
+func newID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// endpoint wraps h with authentication, authorization of action, rate
+// limiting and idempotency keys.
+func (g *Gateway) endpoint(action string, h handlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, errorf(http.StatusMethodNotAllowed, "method not allowed"))
+			return
+		}
+		t := g.authenticate(r)
+		if t == nil {
+			writeError(w, errorf(http.StatusUnauthorized, "invalid token"))
+			return
+		}
+		if !t.allows(action) {
+			writeError(w, errorf(http.StatusForbidden, "token may not %v", action))
+			return
+		}
+		if ok, wait := g.allow(t, time.Now()); !ok {
+			secs := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			writeError(w, errorf(http.StatusTooManyRequests, "rate limit exceeded"))
+			return
+		}
+
+		limit := int64(maxJSONBody)
+		if action == ActionFile {
+			limit = g.maxUpload + 1<<20
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		if err != nil {
+			writeError(w, errorf(http.StatusRequestEntityTooLarge, "request too large"))
+			return
+		}
+
+		var code int
+		var res Result
+		if key := r.Header.Get("Idempotency-Key"); key != "" {
+			code, res = g.callIdempotent(r.Context(), h, t, r, body, action, key)
+		} else {
+			code, res = outcome(g.call(r.Context(), h, t, r, body, action))
+		}
+		writeResult(w, code, &res)
+	})
+}
+
+func (g *Gateway) call(ctx context.Context, h handlerFunc, t *Token, r *http.Request,
+	body []byte, action string) (Result, error) {
+
+	res, err := h(ctx, t, r, body)
+	if err != nil {
+		g.log.Warnf("Token %v: %v request failed: %v", t.Name, action, err)
+		return res, err
+	}
+	g.log.Infof("Token %v: %v request %v %v", t.Name, action, res.ID, res.Status)
+	return res, nil
+}
+
+func (g *Gateway) callIdempotent(ctx context.Context, h handlerFunc, t *Token, r *http.Request,
+	body []byte, action, key string) (int, Result) {
+
+	sum := sha256.Sum256(append([]byte(r.URL.Path+"\x00"), body...))
+	hash := hex.EncodeToString(sum[:])
+	mapKey := t.Name + "\x00" + key
+
+	g.idemMtx.Lock()
+	if e, ok := g.idem[mapKey]; ok {
+		g.idemMtx.Unlock()
+		switch {
+		case e.Hash != hash:
+			return http.StatusUnprocessableEntity, Result{Status: StatusFailed,
+				Error: "idempotency key reused with a different request"}
+		case !e.done:
+			return http.StatusConflict, Result{Status: StatusFailed,
+				Error: "request with this idempotency key is in progress"}
+		}
+		return e.Code, e.Result
+	}
+	e := &idempotent{Hash: hash, Created: time.Now().Unix()}
+	g.idem[mapKey] = e
+	g.idemMtx.Unlock()
+
+	code, res := outcome(g.call(ctx, h, t, r, body, action))
+	if code != http.StatusOK {
+		// Only successful results are stored, so a failed request
+		// may be retried with the same key.
+		g.idemMtx.Lock()
+		delete(g.idem, mapKey)
+		g.idemMtx.Unlock()
+		return code, res
+	}
+
+	defer g.idemMtx.Unlock()
+	g.idemMtx.Lock()
+	e.Code = code
+	e.Result = res
+	e.done = true
+	if err := g.saveIdem(); err != nil {
+		g.log.Errorf("failed to save idempotency keys: %v", err)
+	}
+	return code, res
+}
+
+// resolveUser resolves ref and checks t may reach the user.
+func (g *Gateway) resolveUser(t *Token, ref string) (zkidentity.ShortID, error) {
+	if ref == "" {
+		return zkidentity.ShortID{}, errorf(http.StatusBadRequest, "user is required")
+	}
+	id, err := g.bot.ResolveUser(bot.ParseUserRef(ref))
+	if err != nil {
+		return id, errorf(http.StatusNotFound, "%v", err)
+	}
+	if t.anyUser || t.userIDs[id] {
+		return id, nil
+	}
+	return id, errorf(http.StatusForbidden, "token may not reach user %v", ref)
+}
+
+func decodeJSON(body []byte, v interface{}) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return errorf(http.StatusBadRequest, "invalid request: %v", err)
+	}
+	return nil
+}
+
+type gcRequest struct {
+	GC  string `json:"gc"`
+	Msg string `json:"msg"`
+}
+
+func (g *Gateway) handleGC(ctx context.Context, t *Token, _ *http.Request, body []byte) (Result, error) {
+	var req gcRequest
+	if err := decodeJSON(body, &req); err != nil {
+		return Result{}, err
+	}
+	if req.GC == "" || req.Msg == "" {
+		return Result{}, errorf(http.StatusBadRequest, "gc and msg are required")
+	}
+	if !t.allowsGC(req.GC) {
+		return Result{}, errorf(http.StatusForbidden, "token may not reach gc %v", req.GC)
+	}
+	res := Result{ID: newID()}
+	if err := g.bot.SendGC(ctx, req.GC, req.Msg); err != nil {
+		return res, err
+	}
+	res.Status = StatusSent
+	return res, nil
+}
+
+type pmRequest struct {
+	User string `json:"user"`
+	Msg  string `json:"msg"`
+}
+
+func (g *Gateway) handlePM(ctx context.Context, t *Token, _ *http.Request, body []byte) (Result, error) {
+	var req pmRequest
+	if err := decodeJSON(body, &req); err != nil {
+		return Result{}, err
+	}
+	if req.Msg == "" {
+		return Result{}, errorf(http.StatusBadRequest, "msg is required")
+	}
+	id, err := g.resolveUser(t, req.User)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{ID: newID()}
+	if err := g.bot.SendPMUser(ctx, bot.UserRefID(id), req.Msg); err != nil {
+		return res, err
+	}
+	res.Status = StatusSent
+	return res, nil
+}
+
+type tipRequest struct {
+	User      string  `json:"user"`
+	AmountDCR float64 `json:"amount_dcr"`
+}
+
+func (g *Gateway) handleTip(ctx context.Context, t *Token, _ *http.Request, body []byte) (Result, error) {
+	var req tipRequest
+	if err := decodeJSON(body, &req); err != nil {
+		return Result{}, err
+	}
+	amt, err := dcrutil.NewAmount(req.AmountDCR)
+	if err != nil || amt <= 0 {
+		return Result{}, errorf(http.StatusBadRequest, "invalid amount")
+	}
+	if amt > t.MaxTip {
+		return Result{}, errorf(http.StatusForbidden, "amount exceeds the token limit of %v", t.MaxTip)
+	}
+	id, err := g.resolveUser(t, req.User)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{ID: newID()}
+	if err := g.bot.PayTip(ctx, id, amt, defaultTipAttempts); err != nil {
+		return res, err
+	}
+	res.Status = StatusTipRequested
+	return res, nil
+}
+
+func (g *Gateway) handleFile(ctx context.Context, t *Token, r *http.Request, body []byte) (Result, error) {
+	// The body was already read, so parse the form from it.
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := r.ParseMultipartForm(g.maxUpload); err != nil {
+		return Result{}, errorf(http.StatusBadRequest, "invalid form: %v", err)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	id, err := g.resolveUser(t, r.FormValue("user"))
+	if err != nil {
+		return Result{}, err
+	}
+	f, fh, err := r.FormFile("file")
+	if err != nil {
+		return Result{}, errorf(http.StatusBadRequest, "file is required")
+	}
+	defer f.Close()
+	if fh.Size > g.maxUpload {
+		return Result{}, errorf(http.StatusRequestEntityTooLarge, "file too large")
+	}
+
+	res := Result{ID: newID()}
+	name := filepath.Base(fh.Filename)
+	if name == "." || name == string(filepath.Separator) {
+		name = "upload"
+	}
+	path := filepath.Join(g.uploadDir, res.ID+"-"+name)
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return res, err
+	}
+	_, err = io.Copy(out, f)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return res, err
+	}
+
+	if err := g.bot.SendFile(ctx, id.String(), path); err != nil {
+		return res, err
+	}
+	res.Status = StatusSent
+	return res, nil
+}
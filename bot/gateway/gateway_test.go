@@ -0,0 +1,225 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/slog"
+)
+
+// newTestBot returns a running bot and the channel of the PMs it receives.
+func newTestBot(t *testing.T) (*bottest.Server, *bot.Bot, chan types.ReceivedPM) {
+	t.Helper()
+	pmChan := make(chan types.ReceivedPM)
+	_, srv, b := bottest.NewBot(t, func(cfg *bot.Config) { cfg.PMChan = pmChan })
+	go b.Run()
+	return srv, b, pmChan
+}
+
+// learnNick makes the bot see uid with nick. The bot records the nick
+// before handing the PM to pmChan.
+func learnNick(t *testing.T, srv *bottest.Server, pmChan chan types.ReceivedPM,
+	uid zkidentity.ShortID, nick string) {
+
+	t.Helper()
+	srv.InjectPM(uid[:], nick, "hi")
+	select {
+	case <-pmChan:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("pm from %v not received", nick)
+	}
+}
+
+func post(t *testing.T, h http.Handler, token, path, body string) (int, Result) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var res Result
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("%v: invalid response %q: %v", path, w.Body.String(), err)
+	}
+	return w.Code, res
+}
+
+func TestTokenScopes(t *testing.T) {
+	srv, b, pmChan := newTestBot(t)
+
+	var alice, bob zkidentity.ShortID
+	alice[0], bob[0] = 1, 2
+	learnNick(t, srv, pmChan, alice, "alice")
+
+	g, err := New(Config{
+		DataDir: t.TempDir(),
+		Log:     slog.Disabled,
+		Bot:     b,
+		Tokens: []Token{{
+			Name:    "ci",
+			Token:   "0123456789abcdef",
+			Actions: []string{ActionPM, ActionTip},
+			GCs:     []string{"dev"},
+			Users:   []string{"alice"},
+			MaxTip:  dcrutil.Amount(1e7),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := g.Handler()
+	const token = "0123456789abcdef"
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		code int
+	}{
+		{"bad token", "/v1/pm", `{"user":"alice","msg":"hi"}`, http.StatusUnauthorized},
+		{"action", "/v1/gc", `{"gc":"dev","msg":"hi"}`, http.StatusForbidden},
+		{"user out of scope", "/v1/pm", `{"user":"` + bob.String() + `","msg":"hi"}`, http.StatusForbidden},
+		{"tip above limit", "/v1/tip", `{"user":"alice","amount_dcr":0.2}`, http.StatusForbidden},
+		{"pm", "/v1/pm", `{"user":"alice","msg":"hi"}`, http.StatusOK},
+		{"tip", "/v1/tip", `{"user":"` + alice.String() + `","amount_dcr":0.1}`, http.StatusOK},
+	}
+	for _, tc := range tests {
+		tok := token
+		if tc.name == "bad token" {
+			tok = "fedcba9876543210"
+		}
+		code, res := post(t, h, tok, tc.path, tc.body)
+		if code != tc.code {
+			t.Fatalf("%s: code %d (%v), want %d", tc.name, code, res.Error, tc.code)
+		}
+	}
+	if got := len(srv.Tips()); got != 1 {
+		t.Fatalf("%d tips requested, want 1", got)
+	}
+	if got := len(srv.GCMs()); got != 0 {
+		t.Fatalf("%d gc messages sent, want 0", got)
+	}
+}
+
+func TestTokenUsersPinnedToIDs(t *testing.T) {
+	srv, b, pmChan := newTestBot(t)
+
+	var alice, mallory zkidentity.ShortID
+	alice[0], mallory[0] = 1, 2
+	learnNick(t, srv, pmChan, alice, "alice")
+
+	if _, err := New(Config{
+		DataDir: t.TempDir(),
+		Log:     slog.Disabled,
+		Bot:     b,
+		Tokens: []Token{{
+			Name:  "ci",
+			Token: "0123456789abcdef",
+			Users: []string{"bob"},
+		}},
+	}); err == nil {
+		t.Fatal("token with an unknown user was accepted")
+	}
+
+	g, err := New(Config{
+		DataDir: t.TempDir(),
+		Log:     slog.Disabled,
+		Bot:     b,
+		Tokens: []Token{{
+			Name:    "ci",
+			Token:   "0123456789abcdef",
+			Actions: []string{ActionPM},
+			Users:   []string{"alice"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := g.Handler()
+
+	// alice becomes alice2 and mallory takes the old nick.
+	learnNick(t, srv, pmChan, alice, "alice2")
+	learnNick(t, srv, pmChan, mallory, "alice")
+
+	code, res := post(t, h, "0123456789abcdef", "/v1/pm", `{"user":"alice","msg":"hi"}`)
+	if code != http.StatusForbidden {
+		t.Fatalf("pm to the new alice: code %d (%v), want %d", code, res.Error, http.StatusForbidden)
+	}
+	code, res = post(t, h, "0123456789abcdef", "/v1/pm", `{"user":"alice2","msg":"hi"}`)
+	if code != http.StatusOK {
+		t.Fatalf("pm to the original alice: code %d (%v), want %d", code, res.Error, http.StatusOK)
+	}
+	pms := srv.PMs()
+	if len(pms) != 1 || pms[0].User != alice.String() {
+		t.Fatalf("unexpected pms %v", pms)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	srv, b, _ := newTestBot(t)
+	var alice zkidentity.ShortID
+	alice[0] = 1
+
+	g, err := New(Config{
+		DataDir: t.TempDir(),
+		Log:     slog.Disabled,
+		Bot:     b,
+		Tokens: []Token{{
+			Name:    "ci",
+			Token:   "0123456789abcdef",
+			Actions: []string{ActionTip},
+			Users:   []string{alice.String()},
+			MaxTip:  dcrutil.Amount(1e8),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := g.Handler()
+	tip := func(key, body string) (int, Result) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/v1/tip", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer 0123456789abcdef")
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		var res Result
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+		}
+		return w.Code, res
+	}
+	body := `{"user":"` + alice.String() + `","amount_dcr":0.1}`
+
+	// A failed request is not stored and may be retried with its key.
+	srv.SetTipUserErr(errors.New("no route"))
+	if code, res := tip("k1", body); code != http.StatusBadGateway {
+		t.Fatalf("code %d (%v), want %d", code, res.Error, http.StatusBadGateway)
+	}
+	srv.SetTipUserErr(nil)
+	code, first := tip("k1", body)
+	if code != http.StatusOK {
+		t.Fatalf("code %d (%v), want %d", code, first.Error, http.StatusOK)
+	}
+
+	// The successful result is replayed without tipping again.
+	code, again := tip("k1", body)
+	if code != http.StatusOK || again.ID != first.ID {
+		t.Fatalf("replayed %d %+v, want %+v", code, again, first)
+	}
+	if code, _ := tip("k1", `{"user":"`+alice.String()+`","amount_dcr":0.2}`); code != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused with another body: code %d", code)
+	}
+	if got := len(srv.Tips()); got != 1 {
+		t.Fatalf("%d tips paid, want 1", got)
+	}
+}
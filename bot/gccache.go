@@ -0,0 +1,429 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+const (
+	defaultGCRefreshInterval = 10 * time.Minute
+
+	// maxMemberSamples is the number of membership count changes kept per
+	// GC.
+	maxMemberSamples = 500
+)
+
+var (
+	ErrUnknownGC   = errors.New("unknown GC")
+	ErrAmbiguousGC = errors.New("GC name matches more than one GC")
+)
+
+type GCEventType int
+
+const (
+	GCJoined GCEventType = iota
+	GCLeft
+	GCRenamed
+)
+
+func (t GCEventType) String() string {
+	switch t {
+	case GCJoined:
+		return "joined"
+	case GCLeft:
+		return "left"
+	case GCRenamed:
+		return "renamed"
+	default:
+		return fmt.Sprintf("GCEventType(%d)", int(t))
+	}
+}
+
+// GCEvent is sent on Config.GCEventChan when the GC cache notices the bot
+// joined or left a GC, or that a GC was renamed.
+type GCEvent struct {
+	Type    GCEventType
+	GC      GCInfo
+	OldName string
+}
+
+// MemberCount is the number of members of a GC at a point in time.
+type MemberCount struct {
+	Time  time.Time `json:"time"`
+	Count uint32    `json:"count"`
+}
+
+// GCInfo is the cached metadata of a GC.
+type GCInfo struct {
+	ID      string        `json:"id"`
+	Name    string        `json:"name"`
+	Version uint32        `json:"version"`
+	Members uint32        `json:"members"`
+	Updated time.Time     `json:"updated"`
+	History []MemberCount `json:"history,omitempty"`
+}
+
+func (g *GCInfo) clone() GCInfo {
+	c := *g
+	c.History = append([]MemberCount(nil), g.History...)
+	return c
+}
+
+// refreshGCs lists the GCs the bot is in, updates the cache and returns the
+// events generated by the changes since the last refresh.
+func (b *Bot) refreshGCs(ctx context.Context) ([]GCEvent, error) {
+	var req types.ListGCsRequest
+	var rep types.ListGCsResponse
+	defer b.beginCall("GCService.List", &req)()
+	if err := b.gcService.List(ctx, &req, &rep); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	defer b.gcsMtx.Unlock()
+	b.gcsMtx.Lock()
+
+	var events []GCEvent
+	seen := make(map[string]bool, len(rep.Gcs))
+	for _, info := range rep.Gcs {
+		var id zkidentity.ShortID
+		if len(info.Id) != len(id) {
+			continue
+		}
+		id.FromBytes(info.Id)
+		sid := id.String()
+		seen[sid] = true
+
+		gc, ok := b.gcs[sid]
+		var event *GCEvent
+		switch {
+		case !ok:
+			gc = &GCInfo{ID: sid}
+			b.gcs[sid] = gc
+			if b.gcsLoaded {
+				event = &GCEvent{Type: GCJoined}
+			}
+		case gc.Name != info.Name:
+			event = &GCEvent{Type: GCRenamed, OldName: gc.Name}
+		}
+
+		gc.Name = info.Name
+		gc.Version = info.Version
+		gc.Updated = now
+		if gc.Members != info.NbMembers || len(gc.History) == 0 {
+			gc.History = append(gc.History, MemberCount{Time: now, Count: info.NbMembers})
+			if len(gc.History) > maxMemberSamples {
+				gc.History = gc.History[len(gc.History)-maxMemberSamples:]
+			}
+		}
+		gc.Members = info.NbMembers
+
+		if event != nil {
+			event.GC = gc.clone()
+			events = append(events, *event)
+		}
+	}
+
+	for sid, gc := range b.gcs {
+		if seen[sid] {
+			continue
+		}
+		delete(b.gcs, sid)
+		events = append(events, GCEvent{Type: GCLeft, GC: gc.clone()})
+	}
+	b.gcsLoaded = true
+
+	if err := b.saveGCs(); err != nil {
+		return events, err
+	}
+	return events, nil
+}
+
+// saveGCs writes the GC cache to disk. Must be called with gcsMtx held.
+func (b *Bot) saveGCs() error {
+	raw, err := json.Marshal(b.gcs)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(b.gcsFile, raw, 0o600)
+}
+
+// RefreshGCs asks the GC cache to refresh as soon as possible.
+func (b *Bot) RefreshGCs() {
+	select {
+	case b.gcsRefresh <- struct{}{}:
+	default:
+	}
+}
+
+func (b *Bot) gcCache(ctx context.Context) error {
+	interval := b.gcsInterval
+	if interval <= 0 {
+		interval = defaultGCRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		events, err := b.refreshGCs(ctx)
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		if err != nil {
+			b.gcLog.Errorf("failed to refresh GC cache: %v", err)
+		}
+		for _, e := range events {
+			b.gcLog.Infof("GC %v (%v) %v", e.GC.Name, e.GC.ID, e.Type)
+			if b.gcEventChan == nil {
+				continue
+			}
+			select {
+			case b.gcEventChan <- e:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-b.gcsRefresh:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *Bot) gcMembersAddedNtfns(ctx context.Context) error {
+	chk := b.streamCheck(RecordMembersAdded)
+	var req types.GCMembersAddedRequest
+	var ackReq types.AckRequest
+	var ackRes types.AckResponse
+	for retry := false; ; retry = true {
+		if retry {
+			b.metrics.reconnects.Inc(RecordMembersAdded)
+		}
+		stream, err := b.gcService.MembersAdded(ctx, &req)
+		if errors.Is(err, context.Canceled) {
+			// Program is done.
+			return err
+		}
+		if err != nil {
+			b.gcLog.Warnf("Error while obtaining GC members added stream: %v", err)
+			chk.Fail(err.Error())
+			time.Sleep(time.Second) // Wait to try again.
+			continue
+		}
+		chk.Progress("subscribed")
+		for {
+			var e types.GCMembersAddedEvent
+			err := stream.Recv(&e)
+			if errors.Is(err, context.Canceled) {
+				// Program is done.
+				return err
+			}
+			if err != nil {
+				b.gcLog.Warnf("Error while receiving GC members added stream: %v", err)
+				chk.Fail(err.Error())
+				break
+			}
+			req.UnackedFrom = e.SequenceId
+			ackReq.SequenceId = e.SequenceId
+			if err = b.gcService.AckMembersAdded(ctx, &ackReq, &ackRes); err != nil {
+				b.gcLog.Errorf("failed to acknowledge GC members added: %v", err)
+				b.metrics.ackFailures.Inc(RecordMembersAdded)
+				chk.Fail(err.Error())
+				break
+			}
+			b.setCursor(RecordMembersAdded, e.SequenceId)
+			b.metrics.received.Inc(RecordMembersAdded)
+			b.recordIn(RecordMembersAdded, &e)
+			b.RefreshGCs()
+		}
+	}
+}
+
+func (b *Bot) gcMembersRemovedNtfns(ctx context.Context) error {
+	chk := b.streamCheck(RecordMembersRemoved)
+	var req types.GCMembersRemovedRequest
+	var ackReq types.AckRequest
+	var ackRes types.AckResponse
+	for retry := false; ; retry = true {
+		if retry {
+			b.metrics.reconnects.Inc(RecordMembersRemoved)
+		}
+		stream, err := b.gcService.MembersRemoved(ctx, &req)
+		if errors.Is(err, context.Canceled) {
+			// Program is done.
+			return err
+		}
+		if err != nil {
+			b.gcLog.Warnf("Error while obtaining GC members removed stream: %v", err)
+			chk.Fail(err.Error())
+			time.Sleep(time.Second) // Wait to try again.
+			continue
+		}
+		chk.Progress("subscribed")
+		for {
+			var e types.GCMembersRemovedEvent
+			err := stream.Recv(&e)
+			if errors.Is(err, context.Canceled) {
+				// Program is done.
+				return err
+			}
+			if err != nil {
+				b.gcLog.Warnf("Error while receiving GC members removed stream: %v", err)
+				chk.Fail(err.Error())
+				break
+			}
+			req.UnackedFrom = e.SequenceId
+			ackReq.SequenceId = e.SequenceId
+			if err = b.gcService.AckMembersRemoved(ctx, &ackReq, &ackRes); err != nil {
+				b.gcLog.Errorf("failed to acknowledge GC members removed: %v", err)
+				b.metrics.ackFailures.Inc(RecordMembersRemoved)
+				chk.Fail(err.Error())
+				break
+			}
+			b.setCursor(RecordMembersRemoved, e.SequenceId)
+			b.metrics.received.Inc(RecordMembersRemoved)
+			b.recordIn(RecordMembersRemoved, &e)
+			b.RefreshGCs()
+		}
+	}
+}
+
+func (b *Bot) joinedGCNtfns(ctx context.Context) error {
+	chk := b.streamCheck(RecordJoinedGC)
+	var req types.JoinedGCsRequest
+	var ackReq types.AckRequest
+	var ackRes types.AckResponse
+	for retry := false; ; retry = true {
+		if retry {
+			b.metrics.reconnects.Inc(RecordJoinedGC)
+		}
+		stream, err := b.gcService.JoinedGCs(ctx, &req)
+		if errors.Is(err, context.Canceled) {
+			// Program is done.
+			return err
+		}
+		if err != nil {
+			b.gcLog.Warnf("Error while obtaining joined GCs stream: %v", err)
+			chk.Fail(err.Error())
+			time.Sleep(time.Second) // Wait to try again.
+			continue
+		}
+		chk.Progress("subscribed")
+		for {
+			var e types.JoinedGCEvent
+			err := stream.Recv(&e)
+			if errors.Is(err, context.Canceled) {
+				// Program is done.
+				return err
+			}
+			if err != nil {
+				b.gcLog.Warnf("Error while receiving joined GCs stream: %v", err)
+				chk.Fail(err.Error())
+				break
+			}
+			req.UnackedFrom = e.SequenceId
+			ackReq.SequenceId = e.SequenceId
+			if err = b.gcService.AckJoinedGCs(ctx, &ackReq, &ackRes); err != nil {
+				b.gcLog.Errorf("failed to acknowledge joined GC: %v", err)
+				b.metrics.ackFailures.Inc(RecordJoinedGC)
+				chk.Fail(err.Error())
+				break
+			}
+			b.setCursor(RecordJoinedGC, e.SequenceId)
+			b.metrics.received.Inc(RecordJoinedGC)
+			b.recordIn(RecordJoinedGC, &e)
+			b.RefreshGCs()
+		}
+	}
+}
+
+// CachedGCs returns the cached GCs, most members first. The cache is filled
+// on first use if it was never refreshed.
+func (b *Bot) CachedGCs(ctx context.Context) ([]GCInfo, error) {
+	if err := b.ensureGCs(ctx); err != nil {
+		return nil, err
+	}
+
+	defer b.gcsMtx.Unlock()
+	b.gcsMtx.Lock()
+
+	res := make([]GCInfo, 0, len(b.gcs))
+	for _, gc := range b.gcs {
+		res = append(res, gc.clone())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Members > res[j].Members
+	})
+	return res, nil
+}
+
+// FindGC looks up a cached GC by ID, by alias or by a partial name that
+// matches a single GC. Names shared by several GCs are ambiguous, even when
+// they match exactly.
+func (b *Bot) FindGC(ctx context.Context, s string) (GCInfo, error) {
+	if err := b.ensureGCs(ctx); err != nil {
+		return GCInfo{}, err
+	}
+
+	defer b.gcsMtx.Unlock()
+	b.gcsMtx.Lock()
+
+	if gc, ok := b.gcs[strings.ToLower(s)]; ok {
+		return gc.clone(), nil
+	}
+	var exact *GCInfo
+	for _, gc := range b.gcs {
+		if gc.Name != s {
+			continue
+		}
+		if exact != nil {
+			return GCInfo{}, fmt.Errorf("%w: %q", ErrAmbiguousGC, s)
+		}
+		exact = gc
+	}
+	if exact != nil {
+		return exact.clone(), nil
+	}
+
+	var match *GCInfo
+	needle := strings.ToLower(s)
+	for _, gc := range b.gcs {
+		if !strings.Contains(strings.ToLower(gc.Name), needle) {
+			continue
+		}
+		if match != nil {
+			return GCInfo{}, fmt.Errorf("%w: %q", ErrAmbiguousGC, s)
+		}
+		match = gc
+	}
+	if match == nil {
+		return GCInfo{}, fmt.Errorf("%w: %q", ErrUnknownGC, s)
+	}
+	return match.clone(), nil
+}
+
+func (b *Bot) ensureGCs(ctx context.Context) error {
+	b.gcsMtx.Lock()
+	loaded := b.gcsLoaded
+	b.gcsMtx.Unlock()
+	if loaded {
+		return nil
+	}
+
+	// Without a previous state there is nothing to compare against, so
+	// this refresh generates no events.
+	_, err := b.refreshGCs(ctx)
+	return err
+}
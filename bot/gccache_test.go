@@ -0,0 +1,95 @@
+package bot_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+func gcInfo(id byte, name string, members uint32) *types.ListGCsResponse_GCInfo {
+	var gid zkidentity.ShortID
+	gid[0] = id
+	return &types.ListGCsResponse_GCInfo{Id: gid[:], Name: name, NbMembers: members}
+}
+
+func TestFindGC(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	srv.SetGCs([]*types.ListGCsResponse_GCInfo{
+		gcInfo(1, "dev", 3),
+		gcInfo(2, "Dev Team", 5),
+		gcInfo(3, "ops", 1),
+		gcInfo(4, "ops", 2),
+	})
+
+	var dev zkidentity.ShortID
+	dev[0] = 1
+	finds := []struct {
+		s    string
+		name string
+		err  error
+	}{
+		{dev.String(), "dev", nil},
+		{"dev", "dev", nil},
+		{"team", "Dev Team", nil},
+		{"DE", "", bot.ErrAmbiguousGC},
+		{"ops", "", bot.ErrAmbiguousGC},
+		{"qa", "", bot.ErrUnknownGC},
+	}
+	for _, f := range finds {
+		gc, err := b.FindGC(ctx, f.s)
+		if !errors.Is(err, f.err) || gc.Name != f.name {
+			t.Fatalf("%q: found %q, %v", f.s, gc.Name, err)
+		}
+	}
+
+	gcs, err := b.CachedGCs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gcs) != 4 || gcs[0].Name != "Dev Team" || gcs[3].Members != 1 {
+		t.Fatalf("unexpected cached gcs %+v", gcs)
+	}
+}
+
+func TestGCEvents(t *testing.T) {
+	events := make(chan bot.GCEvent, 3)
+	ctx, srv, b := bottest.NewBot(t, func(cfg *bot.Config) { cfg.GCEventChan = events })
+	srv.SetGCs([]*types.ListGCsResponse_GCInfo{gcInfo(1, "dev", 3), gcInfo(2, "ops", 2)})
+	go b.Run()
+
+	// The first refresh fills the cache without events.
+	if err := srv.WaitFor(ctx, func() bool {
+		gc, err := b.FindGC(ctx, "ops")
+		return err == nil && gc.Members == 2
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.SetGCs([]*types.ListGCsResponse_GCInfo{gcInfo(1, "devs", 4), gcInfo(3, "qa", 1)})
+	b.RefreshGCs()
+	got := make(map[bot.GCEventType]bot.GCEvent)
+	for i := 0; i < 3; i++ {
+		select {
+		case e := <-events:
+			got[e.Type] = e
+		case <-ctx.Done():
+			t.Fatal("gc events not received")
+		}
+	}
+	if e := got[bot.GCRenamed]; e.OldName != "dev" || e.GC.Name != "devs" || e.GC.Members != 4 {
+		t.Fatalf("unexpected rename %+v", e)
+	}
+	if e := got[bot.GCJoined]; e.GC.Name != "qa" {
+		t.Fatalf("unexpected join %+v", e)
+	}
+	if e := got[bot.GCLeft]; e.GC.Name != "ops" {
+		t.Fatalf("unexpected leave %+v", e)
+	}
+	if gc, err := b.FindGC(ctx, "devs"); err != nil || len(gc.History) != 2 {
+		t.Fatalf("unexpected member history %+v, %v", gc, err)
+	}
+}
@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot/health"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+)
+
+const defaultHealthStaleAfter = 2 * time.Minute
+
+// Health returns the registry holding the bot health checks. Callers may
+// register their own checks in it to have them served by the bot.
+func (b *Bot) Health() *health.Registry {
+	return b.healthReg
+}
+
+// streamCheck returns the readiness check of a notification stream.
+func (b *Bot) streamCheck(kind string) *health.Check {
+	return b.healthReg.Register("stream_"+kind, false, 0)
+}
+
+// probeClientRPC periodically calls VersionService.Version to verify the
+// clientrpc websocket is up.
+func (b *Bot) probeClientRPC(ctx context.Context) error {
+	chk := b.healthReg.Register("clientrpc", true, b.healthStaleAfter)
+	interval := b.healthStaleAfter / 4
+	if interval < 5*time.Second {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		reqCtx, cancel := context.WithTimeout(ctx, interval)
+		var res types.VersionResponse
+		err := b.versionService.Version(reqCtx, &types.VersionRequest{}, &res)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			chk.Fail(err.Error())
+		default:
+			chk.Progress(fmt.Sprintf("%v %v", res.AppName, res.AppVersion))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
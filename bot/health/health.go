@@ -0,0 +1,164 @@
+// Package health tracks the state of named checks and serves them on
+// /healthz and /readyz style endpoints.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Check is a single named check. Its state is updated by whatever it
+// monitors through Progress and Fail.
+type Check struct {
+	name       string
+	liveness   bool
+	staleAfter time.Duration
+
+	mtx          sync.Mutex
+	ok           bool
+	detail       string
+	lastProgress time.Time
+	changed      time.Time
+}
+
+// Progress marks the check as passing and records that it made progress.
+func (c *Check) Progress(detail string) {
+	now := time.Now()
+	defer c.mtx.Unlock()
+	c.mtx.Lock()
+	if !c.ok {
+		c.changed = now
+	}
+	c.ok = true
+	c.detail = detail
+	c.lastProgress = now
+}
+
+// Fail marks the check as failing.
+func (c *Check) Fail(detail string) {
+	defer c.mtx.Unlock()
+	c.mtx.Lock()
+	if c.ok || c.changed.IsZero() {
+		c.changed = time.Now()
+	}
+	c.ok = false
+	c.detail = detail
+}
+
+// Status is the JSON representation of a check.
+type Status struct {
+	OK           bool      `json:"ok"`
+	Detail       string    `json:"detail,omitempty"`
+	LastProgress time.Time `json:"last_progress,omitempty"`
+	Since        time.Time `json:"since,omitempty"`
+}
+
+// Status returns the current state of the check. A passing check with a
+// stale timeout fails once it made no progress for that long.
+func (c *Check) Status() Status {
+	now := time.Now()
+	defer c.mtx.Unlock()
+	c.mtx.Lock()
+
+	s := Status{
+		OK:           c.ok,
+		Detail:       c.detail,
+		LastProgress: c.lastProgress,
+		Since:        c.changed,
+	}
+	if s.OK && c.staleAfter > 0 && now.Sub(c.lastProgress) > c.staleAfter {
+		s.OK = false
+		s.Detail = "no progress since " + c.lastProgress.UTC().Format(time.RFC3339)
+		s.Since = c.lastProgress.Add(c.staleAfter)
+	}
+	if s.Detail == "" && s.LastProgress.IsZero() && !s.OK {
+		s.Detail = "not started"
+	}
+	return s
+}
+
+// Registry holds the checks of a process.
+type Registry struct {
+	mtx    sync.Mutex
+	checks map[string]*Check
+}
+
+func NewRegistry() *Registry {
+	return &Registry{checks: make(map[string]*Check)}
+}
+
+// Register adds a check, or returns the existing one with the same name. All
+// checks are reported by /readyz; liveness checks are also reported by
+// /healthz. A staleAfter greater than zero makes the check fail when it made
+// no progress for that long.
+func (r *Registry) Register(name string, liveness bool, staleAfter time.Duration) *Check {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	if c, ok := r.checks[name]; ok {
+		return c
+	}
+	c := &Check{name: name, liveness: liveness, staleAfter: staleAfter}
+	r.checks[name] = c
+	return c
+}
+
+// Report is the JSON document served by the endpoints.
+type Report struct {
+	OK     bool              `json:"ok"`
+	Checks map[string]Status `json:"checks"`
+}
+
+// Report returns the status of every check, or only of the liveness checks
+// when livenessOnly is set.
+func (r *Registry) Report(livenessOnly bool) Report {
+	r.mtx.Lock()
+	checks := make([]*Check, 0, len(r.checks))
+	for _, c := range r.checks {
+		if livenessOnly && !c.liveness {
+			continue
+		}
+		checks = append(checks, c)
+	}
+	r.mtx.Unlock()
+	sort.Slice(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+
+	rep := Report{OK: true, Checks: make(map[string]Status, len(checks))}
+	for _, c := range checks {
+		s := c.Status()
+		rep.Checks[c.name] = s
+		rep.OK = rep.OK && s.OK
+	}
+	return rep
+}
+
+func (r *Registry) serve(w http.ResponseWriter, livenessOnly bool) {
+	rep := r.Report(livenessOnly)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !rep.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(&rep)
+}
+
+// HealthzHandler serves the liveness checks. It responds with 503 when any of
+// them fails.
+func (r *Registry) HealthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		r.serve(w, true)
+	})
+}
+
+// ReadyzHandler serves every check. It responds with 503 when any of them
+// fails.
+func (r *Registry) ReadyzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		r.serve(w, false)
+	})
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"context"
+
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+// Messenger sends messages and files to users and GCs.
+type Messenger interface {
+	SendPM(ctx context.Context, nick, msg string) error
+	SendPMUser(ctx context.Context, ref UserRef, msg string) error
+	SendGC(ctx context.Context, gc, msg string) error
+	SendFile(ctx context.Context, uid, filename string) error
+}
+
+// GCManager manages the GCs the bot is a member of.
+type GCManager interface {
+	GetGCs(ctx context.Context) ([]*types.ListGCsResponse_GCInfo, error)
+	CachedGCs(ctx context.Context) ([]GCInfo, error)
+	FindGC(ctx context.Context, s string) (GCInfo, error)
+	IsGCMember(ctx context.Context, gc string, uid zkidentity.ShortID) (bool, error)
+	RefreshGCs()
+	AcceptGCInvite(ctx context.Context, id string) error
+	InviteToGC(ctx context.Context, gc, id string) error
+	InviteToGCUser(ctx context.Context, gc string, ref UserRef) error
+	WriteNewInvite(ctx context.Context, amt dcrutil.Amount, gc string) ([]byte, string, error)
+}
+
+// Payer sends tips.
+type Payer interface {
+	PayTip(ctx context.Context, uid zkidentity.ShortID, tipAmt dcrutil.Amount, maxAttempts int32) error
+}
+
+// PostSubscriber subscribes to the posts of other users.
+type PostSubscriber interface {
+	SubscribeToUserPosts(ctx context.Context, user zkidentity.ShortID) error
+}
+
+// KXMediator requests mediated key exchanges.
+type KXMediator interface {
+	MediateKX(ctx context.Context, mediator, target string) error
+	MediateKXUser(ctx context.Context, mediator, target UserRef) error
+}
+
+// UserDirectory resolves users by nick or ID.
+type UserDirectory interface {
+	ResolveUser(ref UserRef) (zkidentity.ShortID, error)
+	LookupUser(id zkidentity.ShortID) (User, bool)
+	Users() []User
+}
+
+// Whitelist manages the users allowed to run privileged commands.
+type Whitelist interface {
+	IsWhitelisted(id zkidentity.ShortID) bool
+	WhitelistAdd(id zkidentity.ShortID) error
+	WhitelistEntries() []string
+	WhitelistRemove(id zkidentity.ShortID) error
+}
+
+// API is every operation offered by Bot. Modules take an API instead of a
+// *Bot so tests can substitute fakes, usually by embedding an API and
+// overriding the methods they need.
+type API interface {
+	Messenger
+	GCManager
+	Payer
+	PostSubscriber
+	KXMediator
+	UserDirectory
+	Whitelist
+}
+
+var _ API = (*Bot)(nil)
@@ -0,0 +1,394 @@
+// Package intro keeps an opt-in directory of users and asks them for
+// consent before telling others how to be introduced to them.
+//
+// The consent is advisory. The bot relays any mediated KX its client is
+// asked for, so a user who knows the ID of a target can run
+// "/mi <bot> <target>" without asking. The service keeps directory members
+// from being contacted without warning through the directory; it does not
+// stop a requester who skips it.
+package intro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+const (
+	defaultRequestTTL  = 24 * time.Hour
+	defaultRateWindow  = 24 * time.Hour
+	defaultMaxRequests = 3
+)
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot bot.API
+
+	// BotNick is the nick users know the bot by, used in the
+	// instructions sent after an introduction is accepted.
+	BotNick string
+
+	// RequestTTL is how long a target has to answer a request.
+	RequestTTL time.Duration
+
+	// MaxRequests is the number of requests a user may make per
+	// RateWindow.
+	MaxRequests int
+	RateWindow  time.Duration
+}
+
+// Member is a user listed in the directory.
+type Member struct {
+	UID   string    `json:"uid"`
+	Nick  string    `json:"nick"`
+	About string    `json:"about,omitempty"`
+	Since time.Time `json:"since"`
+}
+
+// Request is a pending or answered introduction request.
+type Request struct {
+	ID            int       `json:"id"`
+	Requester     string    `json:"requester"`
+	RequesterNick string    `json:"requester_nick"`
+	Target        string    `json:"target"`
+	TargetNick    string    `json:"target_nick"`
+	Created       time.Time `json:"created"`
+	Answered      time.Time `json:"answered,omitempty"`
+	Accepted      bool      `json:"accepted"`
+}
+
+type state struct {
+	NextID    int                `json:"next_id"`
+	Directory map[string]*Member `json:"directory"`
+	Requests  []*Request         `json:"requests"`
+}
+
+// Service introduces users to members of its directory once they consent.
+// See the package documentation for the limits of that consent.
+//
+// clientrpc's MediateKX only makes the local client KX with a target through
+// a mediator, so the bot cannot start a KX between two other users. Once a
+// target accepts, the requester is told to ask their own client to mediate
+// through the bot, which relays the KX.
+type Service struct {
+	bot         bot.API
+	log         slog.Logger
+	file        string
+	botNick     string
+	requestTTL  time.Duration
+	maxRequests int
+	rateWindow  time.Duration
+
+	mtx   sync.Mutex
+	state state
+}
+
+func New(cfg Config) (*Service, error) {
+	s := &Service{
+		bot:         cfg.Bot,
+		log:         cfg.Log,
+		file:        filepath.Join(cfg.DataDir, "intro.json"),
+		botNick:     cfg.BotNick,
+		requestTTL:  cfg.RequestTTL,
+		maxRequests: cfg.MaxRequests,
+		rateWindow:  cfg.RateWindow,
+		state: state{
+			NextID:    1,
+			Directory: make(map[string]*Member),
+		},
+	}
+	if s.botNick == "" {
+		s.botNick = "<bot nick>"
+	}
+	if s.requestTTL <= 0 {
+		s.requestTTL = defaultRequestTTL
+	}
+	if s.maxRequests <= 0 {
+		s.maxRequests = defaultMaxRequests
+	}
+	if s.rateWindow <= 0 {
+		s.rateWindow = defaultRateWindow
+	}
+
+	raw, err := os.ReadFile(s.file)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(raw, &s.state); err != nil {
+			return nil, err
+		}
+		if s.state.Directory == nil {
+			s.state.Directory = make(map[string]*Member)
+		}
+	}
+
+	return s, nil
+}
+
+// HandleCommand handles the !intro command sent by PM:
+//
+//	!intro optin [about text]
+//	!intro optout
+//	!intro list
+//	!intro <nick or id>
+//	!intro accept <id>
+//	!intro decline <id>
+//
+// Consent is not enforced, since anyone may ask their client to mediate a
+// KX through the bot without using !intro.
+func (s *Service) HandleCommand(ctx context.Context, uid zkidentity.ShortID, nick string, args []string) error {
+	reply := func(f string, a ...interface{}) error {
+		return s.bot.SendPMUser(ctx, bot.UserRefID(uid), fmt.Sprintf(f, a...))
+	}
+	if len(args) == 0 {
+		return reply("usage: !intro optin [about] | optout | list | <nick> | accept <id> | decline <id>\n" +
+			"Asking for consent is a courtesy: the bot cannot stop users from " +
+			"mediating a KX through it (/mi) without asking.")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "optin":
+		if err := s.optIn(uid.String(), nick, strings.Join(args[1:], " ")); err != nil {
+			return err
+		}
+		return reply("you are now listed in the directory")
+
+	case "optout":
+		if err := s.optOut(uid.String()); err != nil {
+			return err
+		}
+		return reply("you are no longer listed in the directory")
+
+	case "list":
+		return reply("%s", s.list())
+
+	case "accept", "decline":
+		if len(args) < 2 {
+			return reply("usage: !intro %v <id>", args[0])
+		}
+		var id int
+		if _, err := fmt.Sscanf(args[1], "%d", &id); err != nil {
+			return reply("invalid request id %q", args[1])
+		}
+		return s.answer(ctx, uid, nick, id, strings.EqualFold(args[0], "accept"))
+	}
+
+	return s.request(ctx, uid, nick, strings.Join(args, " "))
+}
+
+func (s *Service) optIn(uid, nick, about string) error {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	m, ok := s.state.Directory[uid]
+	if !ok {
+		m = &Member{UID: uid, Since: time.Now()}
+		s.state.Directory[uid] = m
+	}
+	m.Nick = nick
+	m.About = about
+	return s.save()
+}
+
+func (s *Service) optOut(uid string) error {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	delete(s.state.Directory, uid)
+	return s.save()
+}
+
+// Directory returns the users who opted in to be discoverable.
+func (s *Service) Directory() []Member {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	res := make([]Member, 0, len(s.state.Directory))
+	for _, m := range s.state.Directory {
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Nick < res[j].Nick })
+	return res
+}
+
+func (s *Service) list() string {
+	members := s.Directory()
+	if len(members) == 0 {
+		return "the directory is empty"
+	}
+	var sb strings.Builder
+	for _, m := range members {
+		sb.WriteString(m.Nick)
+		if m.About != "" {
+			fmt.Fprintf(&sb, ": %v", m.About)
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (s *Service) request(ctx context.Context, uid zkidentity.ShortID, nick, target string) error {
+	now := time.Now()
+	from := uid.String()
+
+	s.mtx.Lock()
+	s.prune(now)
+	var (
+		member *Member
+		errMsg string
+	)
+	for _, m := range s.state.Directory {
+		if strings.EqualFold(m.UID, target) || m.Nick == target {
+			member = m
+			break
+		}
+	}
+	var recent int
+	for _, req := range s.state.Requests {
+		if req.Requester == from && now.Sub(req.Created) < s.rateWindow {
+			recent++
+		}
+	}
+	switch {
+	case member == nil:
+		errMsg = fmt.Sprintf("%q is not in the directory", target)
+	case member.UID == from:
+		errMsg = "you cannot be introduced to yourself"
+	case recent >= s.maxRequests:
+		errMsg = "too many introduction requests, try again later"
+	}
+	if errMsg != "" {
+		s.mtx.Unlock()
+		return s.bot.SendPMUser(ctx, bot.UserRefID(uid), errMsg)
+	}
+
+	req := &Request{
+		ID:            s.state.NextID,
+		Requester:     from,
+		RequesterNick: nick,
+		Target:        member.UID,
+		TargetNick:    member.Nick,
+		Created:       now,
+	}
+	s.state.NextID++
+	s.state.Requests = append(s.state.Requests, req)
+	err := s.save()
+	s.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.log.Infof("introduction %d requested by %v to %v", req.ID, nick, req.TargetNick)
+	msg := fmt.Sprintf("%v (%v) would like to be introduced to you. "+
+		"Reply with !intro accept %d or !intro decline %d",
+		nick, from, req.ID, req.ID)
+	if err := s.bot.SendPMUser(ctx, bot.ParseUserRef(req.Target), msg); err != nil {
+		return err
+	}
+	return s.bot.SendPMUser(ctx, bot.UserRefID(uid), fmt.Sprintf("asked %v for consent", req.TargetNick))
+}
+
+func (s *Service) answer(ctx context.Context, uid zkidentity.ShortID, nick string, id int, accept bool) error {
+	now := time.Now()
+	target := uid.String()
+
+	s.mtx.Lock()
+	s.prune(now)
+	var req *Request
+	for _, r := range s.state.Requests {
+		if r.ID == id && r.Target == target && r.Answered.IsZero() &&
+			now.Sub(r.Created) < s.requestTTL {
+			req = r
+			break
+		}
+	}
+	if req == nil {
+		s.mtx.Unlock()
+		return s.bot.SendPMUser(ctx, bot.UserRefID(uid), fmt.Sprintf("no pending request %d", id))
+	}
+	req.Answered = now
+	req.Accepted = accept
+	res := *req
+	err := s.save()
+	s.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if !accept {
+		s.log.Infof("introduction %d declined", id)
+		if err := s.bot.SendPMUser(ctx, bot.ParseUserRef(res.Requester), fmt.Sprintf(
+			"%v declined the introduction", res.TargetNick)); err != nil {
+			return err
+		}
+		return s.bot.SendPMUser(ctx, bot.UserRefID(uid), "introduction declined")
+	}
+
+	s.log.Infof("introduction %d accepted", id)
+	msg := fmt.Sprintf("%v accepted the introduction. Ask your client to "+
+		"mediate the KX through me, e.g. /mi %v %v",
+		res.TargetNick, s.botNick, res.Target)
+	if err := s.bot.SendPMUser(ctx, bot.ParseUserRef(res.Requester), msg); err != nil {
+		return err
+	}
+	return s.bot.SendPMUser(ctx, bot.UserRefID(uid), fmt.Sprintf(
+		"introduction accepted, %v will contact you", res.RequesterNick))
+}
+
+// prune drops the declined and expired requests that are older than the rate
+// window and no longer count towards the rate limit. Accepted introductions
+// are kept. The change is written with the next save. Must be called with
+// the mutex held.
+func (s *Service) prune(now time.Time) {
+	reqs := s.state.Requests[:0]
+	for _, req := range s.state.Requests {
+		if req.Accepted {
+			reqs = append(reqs, req)
+			continue
+		}
+		done := !req.Answered.IsZero() || now.Sub(req.Created) >= s.requestTTL
+		if done && now.Sub(req.Created) >= s.rateWindow {
+			continue
+		}
+		reqs = append(reqs, req)
+	}
+	s.state.Requests = reqs
+}
+
+// Introductions returns the accepted introductions.
+func (s *Service) Introductions() []Request {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	var res []Request
+	for _, req := range s.state.Requests {
+		if req.Accepted {
+			res = append(res, *req)
+		}
+	}
+	return res
+}
+
+// save writes the directory and requests to disk. Must be called with the
+// mutex held.
+func (s *Service) save() error {
+	raw, err := json.Marshal(&s.state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, raw, 0o600)
+}
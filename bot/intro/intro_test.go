@@ -0,0 +1,102 @@
+package intro
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+func TestIntroduction(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	dataDir := t.TempDir()
+	cfg := Config{DataDir: dataDir, Log: slog.Disabled, Bot: b, BotNick: "brbot", MaxRequests: 2}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var alice, bob zkidentity.ShortID
+	alice[0], bob[0] = 1, 2
+	cmds := []struct {
+		uid   zkidentity.ShortID
+		nick  string
+		args  string
+		reply string
+	}{
+		{bob, "bob", "list", "the directory is empty"},
+		{alice, "alice", "optin likes go", "you are now listed in the directory"},
+		{bob, "bob", "list", "alice: likes go"},
+		{alice, "alice", "alice", "you cannot be introduced to yourself"},
+		{bob, "bob", "carol", `"carol" is not in the directory`},
+		{bob, "bob", "alice", "asked alice for consent"},
+		{bob, "bob", "decline 1", "no pending request 1"},
+		{alice, "alice", "decline 1", "introduction declined"},
+		{alice, "alice", "accept 1", "no pending request 1"},
+		{bob, "bob", "alice", "asked alice for consent"},
+		{alice, "alice", "accept 2", "introduction accepted, bob will contact you"},
+		{bob, "bob", "alice", "too many introduction requests, try again later"},
+	}
+	for _, c := range cmds {
+		if err := s.HandleCommand(ctx, c.uid, c.nick, strings.Fields(c.args)); err != nil {
+			t.Fatal(err)
+		}
+		pms := srv.PMs()
+		last := pms[len(pms)-1]
+		if last.User != c.uid.String() || last.Msg.Message != c.reply {
+			t.Fatalf("%v: reply %q to %v, want %q", c.args, last.Msg.Message,
+				last.User, c.reply)
+		}
+	}
+
+	// The target is asked for consent and the requester is told how to
+	// be introduced once they accept.
+	var relayed []string
+	for _, pm := range srv.PMs() {
+		if strings.Contains(pm.Msg.Message, "would like to be introduced") ||
+			strings.Contains(pm.Msg.Message, "the introduction") {
+			relayed = append(relayed, pm.User+": "+pm.Msg.Message)
+		}
+	}
+	request := alice.String() + ": bob (" + bob.String() + ") would like to be " +
+		"introduced to you. Reply with !intro accept %d or !intro decline %d"
+	want := []string{
+		fmt.Sprintf(request, 1, 1),
+		bob.String() + ": alice declined the introduction",
+		fmt.Sprintf(request, 2, 2),
+		bob.String() + ": alice accepted the introduction. Ask your client to " +
+			"mediate the KX through me, e.g. /mi brbot " + alice.String(),
+	}
+	if strings.Join(relayed, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected relayed messages:\n%v", strings.Join(relayed, "\n"))
+	}
+
+	// Old declined requests are pruned, accepted ones are kept.
+	s.mtx.Lock()
+	s.prune(time.Now().Add(defaultRateWindow))
+	err = s.save()
+	s.mtx.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intros := s.Introductions()
+	if len(intros) != 1 || intros[0].ID != 2 || len(s.state.Requests) != 1 {
+		t.Fatalf("unexpected requests after prune %+v", s.state.Requests)
+	}
+
+	// Opting out removes the member from the directory.
+	if err := s.HandleCommand(ctx, alice, "alice", []string{"optout"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.list(); got != "the directory is empty" {
+		t.Fatalf("directory after opt out %q", got)
+	}
+}
@@ -0,0 +1,338 @@
+// Package metrics implements counters, gauges and histograms exposed in the
+// Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/decred/slog"
+)
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+// DefaultBuckets are the histogram buckets used when none are given, in
+// seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// series is the value of a metric for one combination of label values.
+type series struct {
+	labelValues []string
+	value       float64
+
+	// Histograms only.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64
+
+	mtx    sync.Mutex
+	series map[string]*series
+	fn     func() float64
+}
+
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %v: got %d label values, want %d",
+			f.name, len(labelValues), len(f.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.typ == typeHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Registry holds metric families and writes them out.
+type Registry struct {
+	mtx      sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+func (r *Registry) register(f *family) *family {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	if old, ok := r.families[f.name]; ok {
+		if old.typ != f.typ || strings.Join(old.labels, ",") != strings.Join(f.labels, ",") {
+			panic(fmt.Sprintf("metric %v registered twice with different types or labels", f.name))
+		}
+		return old
+	}
+	f.series = make(map[string]*series)
+	r.families[f.name] = f
+	return f
+}
+
+// Counter is a monotonically increasing metric, partitioned by labels.
+type Counter struct {
+	f *family
+}
+
+// Counter registers a counter, or returns the existing one with the same
+// name.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(&family{name: name, help: help, typ: typeCounter, labels: labels})}
+}
+
+// Add increases the counter for the given label values. Negative values are
+// ignored.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	defer c.f.mtx.Unlock()
+	c.f.mtx.Lock()
+	c.f.get(labelValues).value += v
+}
+
+// Inc increases the counter by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Gauge is a metric that can go up and down, partitioned by labels.
+type Gauge struct {
+	f *family
+}
+
+// Gauge registers a gauge, or returns the existing one with the same name.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(&family{name: name, help: help, typ: typeGauge, labels: labels})}
+}
+
+// GaugeFunc registers an unlabeled gauge whose value is read from fn when
+// the metrics are written.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&family{name: name, help: help, typ: typeGauge, fn: fn})
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	defer g.f.mtx.Unlock()
+	g.f.mtx.Lock()
+	g.f.get(labelValues).value = v
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	defer g.f.mtx.Unlock()
+	g.f.mtx.Lock()
+	g.f.get(labelValues).value += v
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Histogram samples observations into buckets, partitioned by labels.
+type Histogram struct {
+	f *family
+}
+
+// Histogram registers a histogram, or returns the existing one with the same
+// name. DefaultBuckets is used when buckets is nil.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(&family{name: name, help: help, typ: typeHistogram,
+		labels: labels, buckets: buckets})}
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	defer h.f.mtx.Unlock()
+	h.f.mtx.Lock()
+	s := h.f.get(labelValues)
+	for i, b := range h.f.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// ObserveSince observes the time elapsed since start, in seconds.
+func (h *Histogram) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func writeLabels(w io.Writer, names, values []string, extraName, extraValue string) {
+	if len(names) == 0 && extraName == "" {
+		return
+	}
+	io.WriteString(w, "{")
+	for i, n := range names {
+		if i > 0 {
+			io.WriteString(w, ",")
+		}
+		fmt.Fprintf(w, `%s="%s"`, n, labelEscaper.Replace(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			io.WriteString(w, ",")
+		}
+		fmt.Fprintf(w, `%s="%s"`, extraName, extraValue)
+	}
+	io.WriteString(w, "}")
+}
+
+// WriteTo writes every metric in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mtx.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mtx.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		fmt.Fprintf(cw, "# HELP %s %s\n", f.name, helpEscaper.Replace(f.help))
+		fmt.Fprintf(cw, "# TYPE %s %s\n", f.name, f.typ)
+
+		if f.fn != nil {
+			fmt.Fprintf(cw, "%s %s\n", f.name, formatFloat(f.fn()))
+			continue
+		}
+
+		f.mtx.Lock()
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := f.series[k]
+			if f.typ != typeHistogram {
+				io.WriteString(cw, f.name)
+				writeLabels(cw, f.labels, s.labelValues, "", "")
+				fmt.Fprintf(cw, " %s\n", formatFloat(s.value))
+				continue
+			}
+			for i, b := range f.buckets {
+				io.WriteString(cw, f.name+"_bucket")
+				writeLabels(cw, f.labels, s.labelValues, "le", formatFloat(b))
+				fmt.Fprintf(cw, " %d\n", s.counts[i])
+			}
+			io.WriteString(cw, f.name+"_bucket")
+			writeLabels(cw, f.labels, s.labelValues, "le", "+Inf")
+			fmt.Fprintf(cw, " %d\n", s.count)
+			io.WriteString(cw, f.name+"_sum")
+			writeLabels(cw, f.labels, s.labelValues, "", "")
+			fmt.Fprintf(cw, " %s\n", formatFloat(s.sum))
+			io.WriteString(cw, f.name+"_count")
+			writeLabels(cw, f.labels, s.labelValues, "", "")
+			fmt.Fprintf(cw, " %d\n", s.count)
+		}
+		f.mtx.Unlock()
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// ServeHTTP writes the metrics in response to a scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+// ListenAndServe serves mux on addr until ctx is done.
+func ListenAndServe(ctx context.Context, addr string, mux *http.ServeMux, log slog.Logger) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Infof("Serving metrics on http://%v/metrics", l.Addr())
+	err = srv.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return ctx.Err()
+	}
+	return err
+}
@@ -9,9 +9,13 @@ import (
 )
 
 func (b *Bot) gcNtfns(ctx context.Context) error {
+	chk := b.streamCheck(RecordGCM)
 	var ackRes types.AckResponse
 	var ackReq types.AckRequest
-	for {
+	for retry := false; ; retry = true {
+		if retry {
+			b.metrics.reconnects.Inc(RecordGCM)
+		}
 		// Keep requesting a new stream if the connection breaks.
 		streamReq := types.GCMStreamRequest{UnackedFrom: ackReq.SequenceId}
 		stream, err := b.chatService.GCMStream(ctx, &streamReq)
@@ -21,9 +25,11 @@ func (b *Bot) gcNtfns(ctx context.Context) error {
 		}
 		if err != nil {
 			b.gcLog.Errorf("failed to get GC stream: %v", err)
+			chk.Fail(err.Error())
 			time.Sleep(time.Second) // Wait to try again.
 			continue
 		}
+		chk.Progress("subscribed")
 
 		b.gcLog.Info("Listening for GC msgs...")
 		for {
@@ -35,23 +41,35 @@ func (b *Bot) gcNtfns(ctx context.Context) error {
 			}
 			if err != nil {
 				b.gcLog.Errorf("failed to receive from stream: %v", err)
+				chk.Fail(err.Error())
 				break
 			}
 			ackReq.SequenceId = pm.SequenceId
 			err = b.chatService.AckReceivedGCM(ctx, &ackReq, &ackRes)
 			if err != nil {
 				b.gcLog.Errorf("failed to acknowledge received gc: %v", err)
+				b.metrics.ackFailures.Inc(RecordGCM)
+				chk.Fail(err.Error())
 				break
 			}
+			b.learnUser(b.gcLog, pm.Uid, pm.Nick)
+			b.setCursor(RecordGCM, pm.SequenceId)
+			b.metrics.received.Inc(RecordGCM)
+			b.metrics.gcReceived.Inc(pm.GcAlias)
+			b.recordIn(RecordGCM, &pm)
 			b.gcChan <- pm
 		}
 	}
 }
 
 func (b *Bot) inviteNtfns(ctx context.Context) error {
+	chk := b.streamCheck(RecordGCInvite)
 	var ackRes types.AckResponse
 	var ackReq types.AckRequest
-	for {
+	for retry := false; ; retry = true {
+		if retry {
+			b.metrics.reconnects.Inc(RecordGCInvite)
+		}
 		// Keep requesting a new stream if the connection breaks. Also
 		// request any messages received since the last one we acked.
 		streamReq := types.ReceivedGCInvitesRequest{UnackedFrom: ackReq.SequenceId}
@@ -62,9 +80,11 @@ func (b *Bot) inviteNtfns(ctx context.Context) error {
 		}
 		if err != nil {
 			b.gcLog.Warnf("Error while obtaining GC invite stream: %v", err)
+			chk.Fail(err.Error())
 			time.Sleep(time.Second) // Wait to try again.
 			continue
 		}
+		chk.Progress("subscribed")
 
 		b.gcLog.Info("Listening for GC invites...")
 		for {
@@ -76,23 +96,33 @@ func (b *Bot) inviteNtfns(ctx context.Context) error {
 			}
 			if err != nil {
 				b.gcLog.Warnf("Error while receiving invite stream: %v", err)
+				chk.Fail(err.Error())
 				break
 			}
 			ackReq.SequenceId = pm.SequenceId
 			if err = b.gcService.AckReceivedGCInvites(ctx, &ackReq, &ackRes); err != nil {
 				b.gcLog.Errorf("failed to acknowledge kx: %v", err)
+				b.metrics.ackFailures.Inc(RecordGCInvite)
+				chk.Fail(err.Error())
 				break
 			}
+			b.setCursor(RecordGCInvite, pm.SequenceId)
+			b.metrics.received.Inc(RecordGCInvite)
+			b.recordIn(RecordGCInvite, &pm)
 			b.inviteChan <- pm
 		}
 	}
 }
 
 func (b *Bot) kxNtfns(ctx context.Context) error {
+	chk := b.streamCheck(RecordKX)
 	var ksr types.KXStreamRequest
 	var ackReq types.AckRequest
 	var ackRes types.AckResponse
-	for {
+	for retry := false; ; retry = true {
+		if retry {
+			b.metrics.reconnects.Inc(RecordKX)
+		}
 		stream, err := b.chatService.KXStream(ctx, &ksr)
 		if errors.Is(err, context.Canceled) {
 			// Program is done.
@@ -100,9 +130,11 @@ func (b *Bot) kxNtfns(ctx context.Context) error {
 		}
 		if err != nil {
 			b.kxLog.Warnf("Error while obtaining KX stream: %v", err)
+			chk.Fail(err.Error())
 			time.Sleep(time.Second) // Wait to try again.
 			continue
 		}
+		chk.Progress("subscribed")
 		b.kxLog.Info("Listening for kxs...")
 		for {
 			var pm types.KXCompleted
@@ -113,23 +145,34 @@ func (b *Bot) kxNtfns(ctx context.Context) error {
 			}
 			if err != nil {
 				b.kxLog.Warnf("Error while receiving stream: %v", err)
+				chk.Fail(err.Error())
 				break
 			}
 			ksr.UnackedFrom = pm.SequenceId
 			ackReq.SequenceId = pm.SequenceId
 			if err = b.chatService.AckKXCompleted(ctx, &ackReq, &ackRes); err != nil {
 				b.kxLog.Errorf("failed to acknowledge kx: %v", err)
+				b.metrics.ackFailures.Inc(RecordKX)
+				chk.Fail(err.Error())
 				break
 			}
+			b.learnUser(b.kxLog, pm.Uid, pm.Nick)
+			b.setCursor(RecordKX, pm.SequenceId)
+			b.metrics.received.Inc(RecordKX)
+			b.recordIn(RecordKX, &pm)
 			b.kxChan <- pm
 		}
 	}
 }
 
 func (b *Bot) pmNtfns(ctx context.Context) error {
+	chk := b.streamCheck(RecordPM)
 	var ackRes types.AckResponse
 	var ackReq types.AckRequest
-	for {
+	for retry := false; ; retry = true {
+		if retry {
+			b.metrics.reconnects.Inc(RecordPM)
+		}
 		// Keep requesting a new stream if the connection breaks.
 		streamReq := types.PMStreamRequest{UnackedFrom: ackReq.SequenceId}
 		stream, err := b.chatService.PMStream(ctx, &streamReq)
@@ -139,9 +182,11 @@ func (b *Bot) pmNtfns(ctx context.Context) error {
 		}
 		if err != nil {
 			b.pmLog.Errorf("failed to get PM stream: %v", err)
+			chk.Fail(err.Error())
 			time.Sleep(time.Second) // Wait to try again.
 			continue
 		}
+		chk.Progress("subscribed")
 
 		b.pmLog.Info("Listening for private messages...")
 		for {
@@ -153,24 +198,35 @@ func (b *Bot) pmNtfns(ctx context.Context) error {
 			}
 			if err != nil {
 				b.pmLog.Errorf("failed to receive from stream: %v", err)
+				chk.Fail(err.Error())
 				break
 			}
 			ackReq.SequenceId = pm.SequenceId
 			err = b.chatService.AckReceivedPM(ctx, &ackReq, &ackRes)
 			if err != nil {
 				b.pmLog.Errorf("failed to acknowledge received gc: %v", err)
+				b.metrics.ackFailures.Inc(RecordPM)
+				chk.Fail(err.Error())
 				break
 			}
+			b.learnUser(b.pmLog, pm.Uid, pm.Nick)
+			b.setCursor(RecordPM, pm.SequenceId)
+			b.metrics.received.Inc(RecordPM)
+			b.recordIn(RecordPM, &pm)
 			b.pmChan <- pm
 		}
 	}
 }
 
 func (b *Bot) postNtfns(ctx context.Context) error {
+	chk := b.streamCheck(RecordPost)
 	var psr types.PostsStreamRequest
 	var ackReq types.AckRequest
 	var ackRes types.AckResponse
-	for {
+	for retry := false; ; retry = true {
+		if retry {
+			b.metrics.reconnects.Inc(RecordPost)
+		}
 		stream, err := b.postService.PostsStream(ctx, &psr)
 		if errors.Is(err, context.Canceled) {
 			// Program is done.
@@ -178,9 +234,11 @@ func (b *Bot) postNtfns(ctx context.Context) error {
 		}
 		if err != nil {
 			b.postLog.Errorf("failed to setup posts stream: %v", err)
+			chk.Fail(err.Error())
 			time.Sleep(time.Second) // Wait to try again.
 			continue
 		}
+		chk.Progress("subscribed")
 		b.postLog.Info("Listening for posts...")
 		for {
 			var pm types.ReceivedPost
@@ -191,24 +249,34 @@ func (b *Bot) postNtfns(ctx context.Context) error {
 			}
 			if err != nil {
 				b.postLog.Errorf("failed to receive from stream: %v", err)
+				chk.Fail(err.Error())
 				break
 			}
 			psr.UnackedFrom = pm.SequenceId
 			ackReq.SequenceId = pm.SequenceId
 			if err = b.postService.AckReceivedPost(ctx, &ackReq, &ackRes); err != nil {
 				b.postLog.Errorf("failed to acknowledge post: %v", err)
+				b.metrics.ackFailures.Inc(RecordPost)
+				chk.Fail(err.Error())
 				break
 			}
+			b.setCursor(RecordPost, pm.SequenceId)
+			b.metrics.received.Inc(RecordPost)
+			b.recordIn(RecordPost, &pm)
 			b.postChan <- pm
 		}
 	}
 }
 
 func (b *Bot) postStatusNtfns(ctx context.Context) error {
+	chk := b.streamCheck(RecordPostStatus)
 	var psr types.PostsStatusStreamRequest
 	var ackReq types.AckRequest
 	var ackRes types.AckResponse
-	for {
+	for retry := false; ; retry = true {
+		if retry {
+			b.metrics.reconnects.Inc(RecordPostStatus)
+		}
 		stream, err := b.postService.PostsStatusStream(ctx, &psr)
 		if errors.Is(err, context.Canceled) {
 			// Program is done.
@@ -216,9 +284,11 @@ func (b *Bot) postStatusNtfns(ctx context.Context) error {
 		}
 		if err != nil {
 			b.postStatusLog.Warnf("Error while obtaining posts status stream: %v", err)
+			chk.Fail(err.Error())
 			time.Sleep(time.Second) // Wait to try again.
 			continue
 		}
+		chk.Progress("subscribed")
 		b.postStatusLog.Info("Listening for comments...")
 		for {
 			var pm types.ReceivedPostStatus
@@ -229,24 +299,34 @@ func (b *Bot) postStatusNtfns(ctx context.Context) error {
 			}
 			if err != nil {
 				b.postStatusLog.Warnf("Error while receiving posts status stream: %v", err)
+				chk.Fail(err.Error())
 				break
 			}
 			psr.UnackedFrom = pm.SequenceId
 			ackReq.SequenceId = pm.SequenceId
 			if err = b.postService.AckReceivedPostStatus(ctx, &ackReq, &ackRes); err != nil {
 				b.postStatusLog.Errorf("Failed to acknowledge post status: %v", err)
+				b.metrics.ackFailures.Inc(RecordPostStatus)
+				chk.Fail(err.Error())
 				break
 			}
+			b.setCursor(RecordPostStatus, pm.SequenceId)
+			b.metrics.received.Inc(RecordPostStatus)
+			b.recordIn(RecordPostStatus, &pm)
 			b.postStatusChan <- pm
 		}
 	}
 }
 
 func (b *Bot) tipProgress(ctx context.Context) error {
+	chk := b.streamCheck(RecordTipProgress)
 	var tpr types.TipProgressRequest
 	var ackReq types.AckRequest
 	var ackRes types.AckResponse
-	for {
+	for retry := false; ; retry = true {
+		if retry {
+			b.metrics.reconnects.Inc(RecordTipProgress)
+		}
 		stream, err := b.paymentService.TipProgress(ctx, &tpr)
 		if errors.Is(err, context.Canceled) {
 			// Program is done.
@@ -254,9 +334,11 @@ func (b *Bot) tipProgress(ctx context.Context) error {
 		}
 		if err != nil {
 			b.tipLog.Warnf("Error while creating tip progress stream: %v", err)
+			chk.Fail(err.Error())
 			time.Sleep(time.Second) // Wait to try again.
 			continue
 		}
+		chk.Progress("subscribed")
 		b.tipLog.Info("Listening for tip progress...")
 		for {
 			var pm types.TipProgressEvent
@@ -267,15 +349,27 @@ func (b *Bot) tipProgress(ctx context.Context) error {
 			}
 			if err != nil {
 				b.tipLog.Warnf("Error while receiving stream: %v", err)
+				chk.Fail(err.Error())
 				break
 			}
 			tpr.UnackedFrom = pm.SequenceId
 			ackReq.SequenceId = pm.SequenceId
 			if err = b.paymentService.AckTipProgress(ctx, &ackReq, &ackRes); err != nil {
 				b.tipLog.Errorf("Failed to acknowledge tip progress: %v", err)
+				b.metrics.ackFailures.Inc(RecordTipProgress)
+				chk.Fail(err.Error())
 				break
 			}
-			b.tipChan <- pm
+			b.setCursor(RecordTipProgress, pm.SequenceId)
+			b.metrics.received.Inc(RecordTipProgress)
+			b.countTipProgress(&pm)
+			b.recordIn(RecordTipProgress, &pm)
+			if b.claimTip(ctx, &pm) {
+				b.tipLog.Debugf("Tip progress %d claimed by a module", pm.SequenceId)
+			}
+			if b.tipChan != nil {
+				b.tipChan <- pm
+			}
 		}
 	}
 }
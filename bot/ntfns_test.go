@@ -0,0 +1,50 @@
+package bot_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay-bot/bot/metrics"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+func TestAckFailureReconnects(t *testing.T) {
+	pmChan := make(chan types.ReceivedPM, 1)
+	reg := metrics.NewRegistry()
+	ctx, srv, b := bottest.NewBot(t, func(cfg *bot.Config) {
+		cfg.PMChan = pmChan
+		cfg.Metrics = reg
+	})
+	go b.Run()
+
+	// The failed ack makes the bot request the stream again, which is
+	// counted once as a reconnect.
+	var alice zkidentity.ShortID
+	alice[0] = 1
+	srv.FailAcks(bottest.StreamPM, 1)
+	srv.InjectPM(alice[:], "alice", "hello")
+	srv.InjectPM(alice[:], "alice", "hello again")
+	select {
+	case <-pmChan:
+	case <-ctx.Done():
+		t.Fatal("pm not received")
+	}
+
+	var buf bytes.Buffer
+	if _, err := reg.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`bot_ack_failures_total{stream="pm"} 1`,
+		`bot_stream_reconnects_total{stream="pm"} 1`,
+		`bot_received_total{stream="pm"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Fatalf("missing %v in\n%v", want, buf.String())
+		}
+	}
+}
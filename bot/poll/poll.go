@@ -0,0 +1,450 @@
+package poll
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+const (
+	defaultTallyInterval = 30 * time.Second
+	minOptions           = 2
+	maxOptions           = 10
+)
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot bot.API
+
+	// TallyInterval is the minimum time between live tallies posted to
+	// a GC.
+	TallyInterval time.Duration
+}
+
+// Poll is a question asked in a GC.
+type Poll struct {
+	ID        int       `json:"id"`
+	GC        string    `json:"gc"`
+	Question  string    `json:"question"`
+	Options   []string  `json:"options"`
+	Creator   string    `json:"creator"`
+	Anonymous bool      `json:"anonymous"`
+	Whitelist bool      `json:"whitelist"`
+	Created   time.Time `json:"created"`
+	Closes    time.Time `json:"closes,omitempty"`
+	Closed    bool      `json:"closed"`
+
+	// Votes maps voter ids to the index of the chosen option.
+	Votes map[string]int `json:"votes"`
+
+	// Nicks maps voter ids to their nick at the time of voting.
+	Nicks map[string]string `json:"nicks"`
+
+	tallyPending bool
+	lastTally    time.Time
+}
+
+type state struct {
+	NextID int           `json:"next_id"`
+	Polls  map[int]*Poll `json:"polls"`
+}
+
+// Polls runs polls in GCs.
+type Polls struct {
+	bot           bot.API
+	log           slog.Logger
+	file          string
+	tallyInterval time.Duration
+
+	mtx   sync.Mutex
+	state state
+}
+
+func New(cfg Config) (*Polls, error) {
+	tallyInterval := cfg.TallyInterval
+	if tallyInterval <= 0 {
+		tallyInterval = defaultTallyInterval
+	}
+
+	p := &Polls{
+		bot:           cfg.Bot,
+		log:           cfg.Log,
+		file:          filepath.Join(cfg.DataDir, "polls.json"),
+		tallyInterval: tallyInterval,
+		state: state{
+			NextID: 1,
+			Polls:  make(map[int]*Poll),
+		},
+	}
+
+	raw, err := os.ReadFile(p.file)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(raw, &p.state); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// HandlePoll handles the !poll command sent to a GC:
+//
+//	!poll "Question?" opt1 opt2 [opt3...] [-anon] [-whitelist] [-close 2h]
+//	!poll close <id>
+//	!poll results <id>
+func (p *Polls) HandlePoll(ctx context.Context, gc string, uid zkidentity.ShortID, nick string, args []string) error {
+	reply := func(f string, a ...interface{}) error {
+		return p.bot.SendGC(ctx, gc, fmt.Sprintf(f, a...))
+	}
+	if len(args) == 0 {
+		return reply(`usage: !poll "Question?" opt1 opt2 [-anon] [-whitelist] [-close 2h]`)
+	}
+
+	switch args[0] {
+	case "close":
+		poll, err := p.lookup(args[1:], gc)
+		if err != nil {
+			return reply("%v", err)
+		}
+		if poll.Creator != uid.String() && !p.bot.IsWhitelisted(uid) {
+			return reply("only the creator of poll %d can close it", poll.ID)
+		}
+		return p.close(ctx, poll.ID)
+
+	case "results":
+		poll, err := p.lookup(args[1:], gc)
+		if err != nil {
+			return reply("%v", err)
+		}
+		return reply("%s", p.Tally(poll.ID))
+	}
+
+	poll := &Poll{
+		GC:      gc,
+		Creator: uid.String(),
+		Created: time.Now(),
+		Votes:   make(map[string]int),
+		Nicks:   make(map[string]string),
+	}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-anon":
+			poll.Anonymous = true
+		case "-whitelist":
+			poll.Whitelist = true
+		case "-close":
+			if i+1 >= len(args) {
+				return reply("-close needs a duration")
+			}
+			i++
+			d, err := time.ParseDuration(args[i])
+			if err != nil || d <= 0 {
+				return reply("invalid close duration %q", args[i])
+			}
+			poll.Closes = poll.Created.Add(d)
+		default:
+			if poll.Question == "" {
+				poll.Question = arg
+				continue
+			}
+			poll.Options = append(poll.Options, arg)
+		}
+	}
+	if len(poll.Options) < minOptions || len(poll.Options) > maxOptions {
+		return reply("a poll needs between %d and %d options", minOptions, maxOptions)
+	}
+
+	p.mtx.Lock()
+	poll.ID = p.state.NextID
+	p.state.NextID++
+	p.state.Polls[poll.ID] = poll
+	err := p.save()
+	p.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	p.log.Infof("poll %d created in %v by %v", poll.ID, gc, nick)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Poll %d by %v: %v\n", poll.ID, nick, poll.Question)
+	for i, opt := range poll.Options {
+		fmt.Fprintf(&sb, "  %d) %v\n", i+1, opt)
+	}
+	if poll.Anonymous {
+		fmt.Fprintf(&sb, "Vote by PM to the bot with: !vote %d <option>", poll.ID)
+	} else {
+		fmt.Fprintf(&sb, "Vote with: !vote %d <option>", poll.ID)
+	}
+	if !poll.Closes.IsZero() {
+		fmt.Fprintf(&sb, "\nCloses at %v", poll.Closes.UTC().Format("2006-01-02 15:04 MST"))
+	}
+	return reply("%s", sb.String())
+}
+
+// HandleVote handles the !vote <id> <option> command. gc is empty when the
+// vote was sent by PM, which is required for anonymous polls. Votes by PM
+// are only accepted from members of the GC of the poll and whitelisted
+// users.
+func (p *Polls) HandleVote(ctx context.Context, gc string, uid zkidentity.ShortID, nick string, args []string) error {
+	reply := func(f string, a ...interface{}) error {
+		msg := fmt.Sprintf(f, a...)
+		if gc == "" {
+			return p.bot.SendPMUser(ctx, bot.UserRefID(uid), msg)
+		}
+		return p.bot.SendGC(ctx, gc, msg)
+	}
+	if len(args) < 2 {
+		return reply("usage: !vote <poll id> <option>")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return reply("invalid poll id %q", args[0])
+	}
+	if gc == "" {
+		ok, err := p.mayVoteByPM(ctx, id, uid)
+		if err != nil {
+			p.log.Warnf("Unable to check access of %v to poll %d: %v", nick, id, err)
+		}
+		if !ok {
+			return reply("you are not allowed to vote on poll %d", id)
+		}
+	}
+
+	msg, err := p.vote(id, gc, uid, nick, strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+	if msg == "" {
+		return nil
+	}
+	return reply("%s", msg)
+}
+
+// mayVoteByPM returns whether uid may vote by PM on poll id: whitelisted
+// users and the members of the GC of the poll. Unknown polls are left to
+// vote to report.
+func (p *Polls) mayVoteByPM(ctx context.Context, id int, uid zkidentity.ShortID) (bool, error) {
+	p.mtx.Lock()
+	poll, ok := p.state.Polls[id]
+	var gc string
+	if ok {
+		gc = poll.GC
+	}
+	p.mtx.Unlock()
+	if !ok || p.bot.IsWhitelisted(uid) {
+		return true, nil
+	}
+
+	info, err := p.bot.FindGC(ctx, gc)
+	if err != nil {
+		return false, err
+	}
+	if !strings.EqualFold(info.Name, gc) {
+		return false, nil
+	}
+	return p.bot.IsGCMember(ctx, info.ID, uid)
+}
+
+// vote records a vote and returns the reply to send to the voter, if any.
+func (p *Polls) vote(id int, gc string, uid zkidentity.ShortID, nick, choice string) (string, error) {
+	defer p.mtx.Unlock()
+	p.mtx.Lock()
+
+	poll, ok := p.state.Polls[id]
+	switch {
+	case !ok:
+		return fmt.Sprintf("unknown poll %d", id), nil
+	case poll.Closed:
+		return fmt.Sprintf("poll %d is closed", id), nil
+	case gc != "" && !strings.EqualFold(gc, poll.GC):
+		return fmt.Sprintf("poll %d belongs to another gc", id), nil
+	case poll.Anonymous && gc != "":
+		return fmt.Sprintf("poll %d is anonymous, vote by PM", id), nil
+	case poll.Whitelist && !p.bot.IsWhitelisted(uid):
+		return fmt.Sprintf("you are not allowed to vote on poll %d", id), nil
+	}
+
+	opt := poll.option(choice)
+	if opt < 0 {
+		return fmt.Sprintf("unknown option %q", choice), nil
+	}
+	poll.Votes[uid.String()] = opt
+	poll.Nicks[uid.String()] = nick
+	poll.tallyPending = true
+	if err := p.save(); err != nil {
+		return "", err
+	}
+	if gc == "" {
+		return fmt.Sprintf("vote on poll %d recorded", id), nil
+	}
+	return "", nil
+}
+
+// Tally returns the current results of a poll.
+func (p *Polls) Tally(id int) string {
+	defer p.mtx.Unlock()
+	p.mtx.Lock()
+
+	poll, ok := p.state.Polls[id]
+	if !ok {
+		return fmt.Sprintf("unknown poll %d", id)
+	}
+	return poll.tally()
+}
+
+// Run posts live tallies and closes expired polls until the context is
+// canceled.
+func (p *Polls) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			p.tick(ctx, now)
+		}
+	}
+}
+
+func (p *Polls) tick(ctx context.Context, now time.Time) {
+	type tally struct {
+		gc  string
+		msg string
+	}
+	var (
+		tallies []tally
+		expired []int
+	)
+
+	p.mtx.Lock()
+	for id, poll := range p.state.Polls {
+		if poll.Closed {
+			continue
+		}
+		if !poll.Closes.IsZero() && !now.Before(poll.Closes) {
+			expired = append(expired, id)
+			continue
+		}
+		if poll.tallyPending && now.Sub(poll.lastTally) >= p.tallyInterval {
+			poll.tallyPending = false
+			poll.lastTally = now
+			tallies = append(tallies, tally{poll.GC, poll.tally()})
+		}
+	}
+	p.mtx.Unlock()
+
+	for _, t := range tallies {
+		if err := p.bot.SendGC(ctx, t.gc, t.msg); err != nil {
+			p.log.Errorf("failed to send tally to %v: %v", t.gc, err)
+		}
+	}
+	sort.Ints(expired)
+	for _, id := range expired {
+		if err := p.close(ctx, id); err != nil {
+			p.log.Errorf("failed to close poll %d: %v", id, err)
+		}
+	}
+}
+
+// close closes a poll and posts its final results.
+func (p *Polls) close(ctx context.Context, id int) error {
+	p.mtx.Lock()
+	poll, ok := p.state.Polls[id]
+	if !ok || poll.Closed {
+		p.mtx.Unlock()
+		return nil
+	}
+	poll.Closed = true
+	poll.tallyPending = false
+	err := p.save()
+	gc, msg := poll.GC, "Final results of "+poll.tally()
+	p.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	p.log.Infof("poll %d closed", id)
+	return p.bot.SendGC(ctx, gc, msg)
+}
+
+// lookup returns the poll with the id in args, which must belong to gc.
+func (p *Polls) lookup(args []string, gc string) (*Poll, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing poll id")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid poll id %q", args[0])
+	}
+
+	defer p.mtx.Unlock()
+	p.mtx.Lock()
+	poll, ok := p.state.Polls[id]
+	if !ok || !strings.EqualFold(poll.GC, gc) {
+		return nil, fmt.Errorf("unknown poll %d", id)
+	}
+	return poll, nil
+}
+
+// save writes the polls to disk. Must be called with the mutex held.
+func (p *Polls) save() error {
+	raw, err := json.Marshal(&p.state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.file, raw, 0o600)
+}
+
+// option returns the index of the option given by number or text, or -1.
+func (poll *Poll) option(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n >= 1 && n <= len(poll.Options) {
+			return n - 1
+		}
+		return -1
+	}
+	for i, opt := range poll.Options {
+		if strings.EqualFold(opt, s) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (poll *Poll) tally() string {
+	counts := make([]int, len(poll.Options))
+	voters := make([][]string, len(poll.Options))
+	for uid, opt := range poll.Votes {
+		counts[opt]++
+		voters[opt] = append(voters[opt], poll.Nicks[uid])
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "poll %d: %v (%d votes)\n", poll.ID, poll.Question, len(poll.Votes))
+	for i, opt := range poll.Options {
+		fmt.Fprintf(&sb, "  %d) %v: %d", i+1, opt, counts[i])
+		if !poll.Anonymous && len(voters[i]) > 0 {
+			sort.Strings(voters[i])
+			fmt.Fprintf(&sb, " (%v)", strings.Join(voters[i], ", "))
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
@@ -0,0 +1,147 @@
+package poll
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+func TestPoll(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	p, err := New(Config{DataDir: t.TempDir(), Log: slog.Disabled, Bot: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var alice, bob zkidentity.ShortID
+	alice[0], bob[0] = 1, 2
+
+	lastGCM := func() string {
+		t.Helper()
+		gcms := srv.GCMs()
+		if len(gcms) == 0 {
+			t.Fatal("no gc message sent")
+		}
+		return gcms[len(gcms)-1].Msg
+	}
+
+	if err := p.HandlePoll(ctx, "dev", alice, "alice", []string{"Lunch?", "pizza"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastGCM(); got != "a poll needs between 2 and 10 options" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+	args := []string{"Lunch?", "pizza", "sushi", "-close", "1h"}
+	if err := p.HandlePoll(ctx, "dev", alice, "alice", args); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastGCM(); !strings.HasPrefix(got, "Poll 1 by alice: Lunch?\n  1) pizza\n  2) sushi\nVote with: !vote 1 <option>") {
+		t.Fatalf("unexpected announcement %q", got)
+	}
+
+	votes := []struct {
+		gc    string
+		uid   zkidentity.ShortID
+		nick  string
+		args  []string
+		reply string
+	}{
+		{"dev", alice, "alice", []string{"1", "2"}, ""},
+		{"dev", bob, "bob", []string{"1", "PIZZA"}, ""},
+		{"dev", bob, "bob", []string{"1", "sushi"}, ""},
+		{"dev", bob, "bob", []string{"1", "tacos"}, `unknown option "tacos"`},
+		{"ops", bob, "bob", []string{"1", "1"}, "poll 1 belongs to another gc"},
+		{"dev", bob, "bob", []string{"2", "1"}, "unknown poll 2"},
+	}
+	for _, v := range votes {
+		before := len(srv.GCMs())
+		if err := p.HandleVote(ctx, v.gc, v.uid, v.nick, v.args); err != nil {
+			t.Fatal(err)
+		}
+		if v.reply == "" {
+			if len(srv.GCMs()) != before {
+				t.Fatalf("%v: unexpected reply %q", v.args, lastGCM())
+			}
+			continue
+		}
+		if got := lastGCM(); got != v.reply {
+			t.Fatalf("%v: reply %q, want %q", v.args, got, v.reply)
+		}
+	}
+	want := "poll 1: Lunch? (2 votes)\n  1) pizza: 0\n  2) sushi: 2 (alice, bob)"
+	if got := p.Tally(1); got != want {
+		t.Fatalf("tally %q, want %q", got, want)
+	}
+
+	// Only the creator or an admin may close the poll, which also closes
+	// when it expires.
+	if err := p.HandlePoll(ctx, "dev", bob, "bob", []string{"close", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastGCM(); got != "only the creator of poll 1 can close it" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+	p.tick(ctx, time.Now().Add(2*time.Hour))
+	if got := lastGCM(); got != "Final results of "+want {
+		t.Fatalf("unexpected final results %q", got)
+	}
+	if err := p.HandleVote(ctx, "dev", bob, "bob", []string{"1", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastGCM(); got != "poll 1 is closed" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+}
+
+func TestAnonymousPollVotes(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	p, err := New(Config{DataDir: t.TempDir(), Log: slog.Disabled, Bot: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var alice, member, outsider zkidentity.ShortID
+	alice[0], member[0], outsider[0] = 1, 2, 3
+	gcID := bytes.Repeat([]byte{9}, 32)
+	srv.SetGCs([]*types.ListGCsResponse_GCInfo{{Id: gcID, Name: "dev", NbMembers: 2}})
+	srv.SetGCMembers(gcID, alice[:], member[:])
+
+	args := []string{"Secret?", "yes", "no", "-anon"}
+	if err := p.HandlePoll(ctx, "dev", alice, "alice", args); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.HandleVote(ctx, "dev", member, "member", []string{"1", "yes"}); err != nil {
+		t.Fatal(err)
+	}
+	gcms := srv.GCMs()
+	if got := gcms[len(gcms)-1].Msg; got != "poll 1 is anonymous, vote by PM" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+
+	votes := []struct {
+		uid   zkidentity.ShortID
+		nick  string
+		reply string
+	}{
+		{outsider, "outsider", "you are not allowed to vote on poll 1"},
+		{member, "member", "vote on poll 1 recorded"},
+	}
+	for _, v := range votes {
+		if err := p.HandleVote(ctx, "", v.uid, v.nick, []string{"1", "yes"}); err != nil {
+			t.Fatal(err)
+		}
+		pms := srv.PMs()
+		if got := pms[len(pms)-1].Msg.Message; got != v.reply {
+			t.Fatalf("%v: reply %q, want %q", v.nick, got, v.reply)
+		}
+	}
+	want := "poll 1: Secret? (1 votes)\n  1) yes: 1\n  2) no: 0"
+	if got := p.Tally(1); got != want {
+		t.Fatalf("tally %q, want %q", got, want)
+	}
+}
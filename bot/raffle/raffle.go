@@ -0,0 +1,657 @@
+package raffle
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/slog"
+)
+
+const (
+	checkInterval      = 5 * time.Second
+	defaultMaxAttempts = 3
+
+	// maxPayoutTries is the number of times a payout is requested before
+	// it is left to !raffle repay, waiting payoutRetryDelay after each
+	// failure.
+	maxPayoutTries   = 3
+	payoutRetryDelay = 10 * time.Minute
+)
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot bot.API
+
+	// MaxAttempts is the number of attempts to pay each winner.
+	MaxAttempts int32
+}
+
+// Entry is a user entered in a raffle.
+type Entry struct {
+	UID  string `json:"uid"`
+	Nick string `json:"nick"`
+}
+
+// Winner is a drawn entry and the state of its payout.
+//
+// A winner that is not paid is either pending or, when SentAt is set, has a
+// tip in flight. Pending winners are paid by Run, which is how payouts
+// interrupted by a restart or failed for good are retried.
+type Winner struct {
+	Entry
+	Amount dcrutil.Amount `json:"amount"`
+	Paid   bool           `json:"paid"`
+	Err    string         `json:"err,omitempty"`
+
+	SentAt   time.Time `json:"sent_at,omitempty"`
+	FailedAt time.Time `json:"failed_at,omitempty"`
+	Tries    int       `json:"tries,omitempty"`
+}
+
+// inFlight returns whether a tip to the winner was requested and has not
+// completed or failed yet.
+func (w *Winner) inFlight() bool {
+	return !w.Paid && !w.SentAt.IsZero()
+}
+
+// pending returns whether the winner still needs a tip to be requested.
+func (w *Winner) pending() bool {
+	return !w.Paid && w.SentAt.IsZero()
+}
+
+// failed returns the winner to pending after a failed payout.
+func (w *Winner) failed(reason string, now time.Time) {
+	w.SentAt = time.Time{}
+	w.FailedAt = now
+	w.Err = reason
+}
+
+// Raffle is a giveaway in a GC.
+type Raffle struct {
+	ID       int            `json:"id"`
+	GC       string         `json:"gc"`
+	Prize    dcrutil.Amount `json:"prize"`
+	Winners  int            `json:"winners"`
+	Deadline time.Time      `json:"deadline"`
+	Entries  []Entry        `json:"entries"`
+
+	// Seed is kept secret until the draw. Commitment is the sha256 of
+	// the seed and is published when the raffle opens.
+	Seed       string `json:"seed"`
+	Commitment string `json:"commitment"`
+
+	Drawn   bool     `json:"drawn"`
+	Results []Winner `json:"results,omitempty"`
+}
+
+type state struct {
+	NextID  int             `json:"next_id"`
+	Raffles map[int]*Raffle `json:"raffles"`
+}
+
+// Raffles runs giveaways in GCs and pays the winners with tips.
+type Raffles struct {
+	bot         bot.API
+	log         slog.Logger
+	file        string
+	maxAttempts int32
+
+	mtx   sync.Mutex
+	state state
+}
+
+func New(cfg Config) (*Raffles, error) {
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	r := &Raffles{
+		bot:         cfg.Bot,
+		log:         cfg.Log,
+		file:        filepath.Join(cfg.DataDir, "raffles.json"),
+		maxAttempts: maxAttempts,
+		state: state{
+			NextID:  1,
+			Raffles: make(map[int]*Raffle),
+		},
+	}
+
+	raw, err := os.ReadFile(r.file)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(raw, &r.state); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// HandleCommand handles the !raffle command sent to a GC:
+//
+//	!raffle open <prize in DCR> <duration> [winners]
+//	!raffle enter
+//	!raffle status
+//	!raffle verify <id>
+//	!raffle repay <id>
+//
+// Only whitelisted users may open raffles and retry the payouts that failed.
+// Entry fees are not supported because clientrpc does not report tips
+// received by the bot.
+func (r *Raffles) HandleCommand(ctx context.Context, gc string, uid zkidentity.ShortID, nick string, args []string) error {
+	reply := func(f string, a ...interface{}) error {
+		return r.bot.SendGC(ctx, gc, fmt.Sprintf(f, a...))
+	}
+	if len(args) == 0 {
+		return reply("usage: !raffle open <prize> <duration> [winners] | enter | status | verify <id> | repay <id>")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "open":
+		if !r.bot.IsWhitelisted(uid) {
+			return reply("only admins can open raffles")
+		}
+		if len(args) < 3 {
+			return reply("usage: !raffle open <prize in DCR> <duration> [winners]")
+		}
+		raffle, err := r.open(gc, args[1:])
+		if err != nil {
+			return reply("%v", err)
+		}
+		return reply("Raffle %d opened: %v for %d winner(s), drawn at %v. "+
+			"Enter with !raffle enter. Seed commitment: %v",
+			raffle.ID, raffle.Prize, raffle.Winners,
+			raffle.Deadline.UTC().Format("2006-01-02 15:04 MST"),
+			raffle.Commitment)
+
+	case "enter":
+		msg, err := r.enter(gc, Entry{UID: uid.String(), Nick: nick})
+		if err != nil {
+			return err
+		}
+		return reply("%s", msg)
+
+	case "status":
+		raffle := r.openRaffle(gc)
+		if raffle == nil {
+			return reply("no open raffle")
+		}
+		return reply("Raffle %d: %v, %d entries, drawn at %v",
+			raffle.ID, raffle.Prize, len(raffle.Entries),
+			raffle.Deadline.UTC().Format("2006-01-02 15:04 MST"))
+
+	case "verify":
+		if len(args) < 2 {
+			return reply("usage: !raffle verify <id>")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return reply("invalid raffle id %q", args[1])
+		}
+		return reply("%s", r.Proof(id))
+
+	case "repay":
+		if !r.bot.IsWhitelisted(uid) {
+			return reply("only admins can retry payouts")
+		}
+		if len(args) < 2 {
+			return reply("usage: !raffle repay <id>")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return reply("invalid raffle id %q", args[1])
+		}
+		return reply("%s", r.repay(ctx, id))
+
+	default:
+		return reply("unknown raffle command %q", args[0])
+	}
+}
+
+func (r *Raffles) open(gc string, args []string) (*Raffle, error) {
+	dcr, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prize %q", args[0])
+	}
+	prize, err := dcrutil.NewAmount(dcr)
+	if err != nil || prize <= 0 {
+		return nil, fmt.Errorf("invalid prize %q", args[0])
+	}
+	d, err := time.ParseDuration(args[1])
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid duration %q", args[1])
+	}
+	winners := 1
+	if len(args) > 2 {
+		winners, err = strconv.Atoi(args[2])
+		if err != nil || winners < 1 {
+			return nil, fmt.Errorf("invalid number of winners %q", args[2])
+		}
+	}
+
+	var seed [32]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, err
+	}
+	commitment := sha256.Sum256(seed[:])
+
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	if r.openRaffleLocked(gc) != nil {
+		return nil, fmt.Errorf("a raffle is already open in %v", gc)
+	}
+	raffle := &Raffle{
+		ID:         r.state.NextID,
+		GC:         gc,
+		Prize:      prize,
+		Winners:    winners,
+		Deadline:   time.Now().Add(d),
+		Seed:       hex.EncodeToString(seed[:]),
+		Commitment: hex.EncodeToString(commitment[:]),
+	}
+	r.state.NextID++
+	r.state.Raffles[raffle.ID] = raffle
+	if err := r.save(); err != nil {
+		return nil, err
+	}
+	r.log.Infof("raffle %d opened in %v", raffle.ID, gc)
+	return raffle, nil
+}
+
+func (r *Raffles) enter(gc string, entry Entry) (string, error) {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	raffle := r.openRaffleLocked(gc)
+	if raffle == nil {
+		return "no open raffle", nil
+	}
+	for _, e := range raffle.Entries {
+		if e.UID == entry.UID {
+			return fmt.Sprintf("%v is already entered", entry.Nick), nil
+		}
+	}
+	raffle.Entries = append(raffle.Entries, entry)
+	if err := r.save(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%v entered raffle %d (%d entries)", entry.Nick,
+		raffle.ID, len(raffle.Entries)), nil
+}
+
+func (r *Raffles) openRaffle(gc string) *Raffle {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	raffle := r.openRaffleLocked(gc)
+	if raffle == nil {
+		return nil
+	}
+	res := *raffle
+	return &res
+}
+
+// openRaffleLocked returns the undrawn raffle of a GC. Must be called with
+// the mutex held.
+func (r *Raffles) openRaffleLocked(gc string) *Raffle {
+	for _, raffle := range r.state.Raffles {
+		if !raffle.Drawn && strings.EqualFold(raffle.GC, gc) {
+			return raffle
+		}
+	}
+	return nil
+}
+
+// Run draws raffles at their deadline and pays the pending winners until
+// the context is canceled. Winners left pending by a previous run are paid
+// right away.
+func (r *Raffles) Run(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	now := time.Now()
+	for {
+		r.drawDue(ctx, now)
+		r.payPending(ctx, now)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now = <-ticker.C:
+		}
+	}
+}
+
+func (r *Raffles) drawDue(ctx context.Context, now time.Time) {
+	r.mtx.Lock()
+	var due []Raffle
+	for _, raffle := range r.state.Raffles {
+		if raffle.Drawn || raffle.Deadline.After(now) {
+			continue
+		}
+		raffle.Results = draw(raffle)
+		raffle.Drawn = true
+		due = append(due, *raffle)
+	}
+	var err error
+	if len(due) > 0 {
+		err = r.save()
+	}
+	r.mtx.Unlock()
+	if err != nil {
+		r.log.Errorf("failed to save raffles: %v", err)
+		return
+	}
+
+	for _, raffle := range due {
+		r.announce(ctx, &raffle)
+	}
+}
+
+func (r *Raffles) announce(ctx context.Context, raffle *Raffle) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Raffle %d drawn with %d entries.\n", raffle.ID, len(raffle.Entries))
+	if len(raffle.Results) == 0 {
+		sb.WriteString("No entries, no winners.\n")
+	}
+	for i, w := range raffle.Results {
+		fmt.Fprintf(&sb, "Winner %d: %v (%v)\n", i+1, w.Nick, w.Amount)
+	}
+	fmt.Fprintf(&sb, "Seed: %v\nCommitment: %v\nCheck with !raffle verify %d",
+		raffle.Seed, raffle.Commitment, raffle.ID)
+	if err := r.bot.SendGC(ctx, raffle.GC, sb.String()); err != nil {
+		r.log.Errorf("failed to announce raffle %d: %v", raffle.ID, err)
+	}
+}
+
+// payPending requests the tips of the pending winners of drawn raffles that
+// have not used up their tries.
+func (r *Raffles) payPending(ctx context.Context, now time.Time) {
+	type payout struct{ id, idx int }
+	var payouts []payout
+
+	r.mtx.Lock()
+	for _, raffle := range r.state.Raffles {
+		if !raffle.Drawn {
+			continue
+		}
+		for i := range raffle.Results {
+			w := &raffle.Results[i]
+			if !w.pending() || w.Tries >= maxPayoutTries ||
+				now.Sub(w.FailedAt) < payoutRetryDelay {
+				continue
+			}
+			payouts = append(payouts, payout{raffle.ID, i})
+		}
+	}
+	r.mtx.Unlock()
+
+	for _, p := range payouts {
+		r.payWinner(ctx, p.id, p.idx)
+	}
+}
+
+// repay requests the tips of every pending winner of a raffle, regardless
+// of their previous tries.
+func (r *Raffles) repay(ctx context.Context, id int) string {
+	r.mtx.Lock()
+	raffle, ok := r.state.Raffles[id]
+	if !ok || !raffle.Drawn {
+		r.mtx.Unlock()
+		return fmt.Sprintf("raffle %d is not drawn", id)
+	}
+	var idxs []int
+	var inFlight int
+	for i := range raffle.Results {
+		w := &raffle.Results[i]
+		switch {
+		case w.pending():
+			w.Tries = 0
+			idxs = append(idxs, i)
+		case w.inFlight():
+			inFlight++
+		}
+	}
+	r.mtx.Unlock()
+
+	if len(idxs) == 0 {
+		if inFlight > 0 {
+			return fmt.Sprintf("raffle %d: %d payout(s) still in progress", id, inFlight)
+		}
+		return fmt.Sprintf("raffle %d: every winner is paid", id)
+	}
+	var failed int
+	for _, i := range idxs {
+		if !r.payWinner(ctx, id, i) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Sprintf("raffle %d: %d of %d payout(s) could not be requested",
+			id, failed, len(idxs))
+	}
+	return fmt.Sprintf("raffle %d: retrying %d payout(s)", id, len(idxs))
+}
+
+// payWinner requests the tip of a pending winner. The tip is marked in
+// flight before it is requested, so a restart never pays a winner twice.
+// It returns whether the tip was requested.
+func (r *Raffles) payWinner(ctx context.Context, id, idx int) bool {
+	r.mtx.Lock()
+	raffle, ok := r.state.Raffles[id]
+	if !ok || idx >= len(raffle.Results) || !raffle.Results[idx].pending() {
+		r.mtx.Unlock()
+		return false
+	}
+	w := &raffle.Results[idx]
+	w.SentAt = time.Now()
+	w.Tries++
+	w.Err = ""
+	winner := *w
+	err := r.save()
+	r.mtx.Unlock()
+	if err != nil {
+		r.log.Errorf("failed to save raffles: %v", err)
+		return false
+	}
+
+	var uid zkidentity.ShortID
+	err = uid.FromString(winner.UID)
+	if err == nil {
+		err = r.bot.PayTip(ctx, uid, winner.Amount, r.maxAttempts)
+	}
+	if err == nil {
+		r.log.Infof("paying raffle %d winner %v %v", id, winner.Nick, winner.Amount)
+		return true
+	}
+
+	r.log.Errorf("failed to pay raffle %d winner %v: %v", id, winner.Nick, err)
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+	if raffle, ok := r.state.Raffles[id]; ok && idx < len(raffle.Results) {
+		raffle.Results[idx].failed(err.Error(), time.Now())
+		if err := r.save(); err != nil {
+			r.log.Errorf("failed to save raffles: %v", err)
+		}
+	}
+	return false
+}
+
+var _ bot.TipClaimer = (*Raffles)(nil)
+
+// PendingTip returns when the oldest payout to uid for amount that is still
+// in flight was requested.
+func (r *Raffles) PendingTip(uid zkidentity.ShortID, amount dcrutil.Amount) (time.Time, bool) {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	_, w := r.oldestInFlight(uid.String(), amount)
+	if w == nil {
+		return time.Time{}, false
+	}
+	return w.SentAt, true
+}
+
+// oldestInFlight returns the winner with the oldest payout to uid for amount
+// still in flight. Must be called with the mutex held.
+func (r *Raffles) oldestInFlight(uid string, amount dcrutil.Amount) (*Raffle, *Winner) {
+	var (
+		raffle *Raffle
+		w      *Winner
+	)
+	for _, cand := range r.state.Raffles {
+		for i := range cand.Results {
+			cw := &cand.Results[i]
+			if !cw.inFlight() || cw.UID != uid || cw.Amount != amount {
+				continue
+			}
+			if w == nil || cw.SentAt.Before(w.SentAt) {
+				raffle, w = cand, cw
+			}
+		}
+	}
+	return raffle, w
+}
+
+// HandleTipProgress records the outcome of payouts to winners. Winners whose
+// tip failed for good are paid again by Run. Once registered with
+// bot.AddTipClaimer, the bot only hands it events that no older payout of
+// another module matches.
+//
+// Progress events do not identify the tip, so they are matched by user and
+// amount against the tips in flight to winners, oldest first. Events that
+// match no tip in flight are ignored.
+func (r *Raffles) HandleTipProgress(ctx context.Context, ev *types.TipProgressEvent) {
+	uid := hex.EncodeToString(ev.Uid)
+	amount := dcrutil.Amount(ev.AmountMatoms / 1000)
+
+	r.mtx.Lock()
+	raffle, w := r.oldestInFlight(uid, amount)
+	var gc, msg string
+	if w != nil {
+		gc = raffle.GC
+		switch {
+		case ev.Completed:
+			w.Paid, w.Err = true, ""
+			w.SentAt = time.Time{}
+			msg = fmt.Sprintf("Raffle %d: paid %v to %v", raffle.ID, w.Amount, w.Nick)
+		case ev.AttemptErr != "" && !ev.WillRetry:
+			w.failed(ev.AttemptErr, time.Now())
+			r.log.Errorf("failed to pay raffle %d winner %v: %v", raffle.ID,
+				w.Nick, ev.AttemptErr)
+			if w.Tries >= maxPayoutTries {
+				msg = fmt.Sprintf("Raffle %d: payout of %v to %v failed, "+
+					"an admin may retry with !raffle repay %d",
+					raffle.ID, w.Amount, w.Nick, raffle.ID)
+			}
+		case ev.AttemptErr != "":
+			w.Err = ev.AttemptErr
+		}
+		if err := r.save(); err != nil {
+			r.log.Errorf("failed to save raffles: %v", err)
+		}
+	}
+	r.mtx.Unlock()
+
+	if msg != "" {
+		if err := r.bot.SendGC(ctx, gc, msg); err != nil {
+			r.log.Errorf("failed to announce payout: %v", err)
+		}
+	}
+}
+
+// Proof returns the data needed to verify the draw of a raffle.
+func (r *Raffles) Proof(id int) string {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	raffle, ok := r.state.Raffles[id]
+	if !ok {
+		return fmt.Sprintf("unknown raffle %d", id)
+	}
+	if !raffle.Drawn {
+		return fmt.Sprintf("raffle %d is not drawn yet, commitment %v",
+			id, raffle.Commitment)
+	}
+	entries := sortedEntries(raffle.Entries)
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.UID
+	}
+	return fmt.Sprintf("Raffle %d: sha256(seed) must equal the commitment "+
+		"%v. Entries sorted by id: %v. Digest d = sha256(seed || ids "+
+		"joined by commas). Winner k (from 0) is the entry at index "+
+		"uint64be(sha256(d || uint64be(k))[:8]) mod the number of remaining "+
+		"entries, removing each winner before the next draw. Seed: %v",
+		id, raffle.Commitment, strings.Join(ids, ","), raffle.Seed)
+}
+
+// draw picks the winners of a raffle from its seed and entries.
+func draw(raffle *Raffle) []Winner {
+	seed, err := hex.DecodeString(raffle.Seed)
+	if err != nil || len(raffle.Entries) == 0 {
+		return nil
+	}
+	remaining := sortedEntries(raffle.Entries)
+	ids := make([]string, len(remaining))
+	for i, e := range remaining {
+		ids[i] = e.UID
+	}
+
+	h := sha256.New()
+	h.Write(seed)
+	h.Write([]byte(strings.Join(ids, ",")))
+	digest := h.Sum(nil)
+
+	n := raffle.Winners
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	amount := raffle.Prize / dcrutil.Amount(n)
+	winners := make([]Winner, 0, n)
+	for k := 0; k < n; k++ {
+		var kb [8]byte
+		binary.BigEndian.PutUint64(kb[:], uint64(k))
+		kh := sha256.Sum256(append(append([]byte{}, digest...), kb[:]...))
+		idx := binary.BigEndian.Uint64(kh[:8]) % uint64(len(remaining))
+		winners = append(winners, Winner{Entry: remaining[idx], Amount: amount})
+		remaining = append(remaining[:idx], remaining[idx+1:]...)
+	}
+	return winners
+}
+
+func sortedEntries(entries []Entry) []Entry {
+	res := append([]Entry(nil), entries...)
+	sort.Slice(res, func(i, j int) bool { return res[i].UID < res[j].UID })
+	return res
+}
+
+// save writes the raffles to disk. Must be called with the mutex held.
+func (r *Raffles) save() error {
+	raw, err := json.Marshal(&r.state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.file, raw, 0o600)
+}
@@ -0,0 +1,187 @@
+package raffle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+// drawnRaffle opens a raffle for a single winner, enters uid and draws it.
+func drawnRaffle(ctx context.Context, t *testing.T, r *Raffles, uid zkidentity.ShortID) *Raffle {
+	t.Helper()
+	raffle, err := r.open("dev", []string{"1", "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.enter("dev", Entry{UID: uid.String(), Nick: "winner"}); err != nil {
+		t.Fatal(err)
+	}
+	r.drawDue(ctx, raffle.Deadline.Add(time.Second))
+	return r.state.Raffles[raffle.ID]
+}
+
+func TestPayoutResumedAfterRestart(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	dataDir := t.TempDir()
+	cfg := Config{DataDir: dataDir, Log: slog.Disabled, Bot: b}
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var winner zkidentity.ShortID
+	winner[0] = 1
+	raffle := drawnRaffle(ctx, t, r, winner)
+	if !raffle.Drawn || len(raffle.Results) != 1 || !raffle.Results[0].pending() {
+		t.Fatalf("unexpected raffle after the draw: %+v", raffle)
+	}
+	if got := len(srv.Tips()); got != 0 {
+		t.Fatalf("%d tips requested before Run, want 0", got)
+	}
+
+	// The bot stopped between the draw and the payout. Run pays the
+	// winner on startup.
+	r, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- r.Run(runCtx) }()
+	t.Cleanup(func() {
+		stop()
+		<-done
+	})
+	if err := srv.WaitFor(ctx, func() bool { return len(srv.Tips()) == 1 }); err != nil {
+		t.Fatal(err)
+	}
+	tip := srv.Tips()[0]
+	if tip.User != winner.String() || tip.DcrAmount != 1 {
+		t.Fatalf("unexpected tip %v", tip)
+	}
+
+	// The payout is recorded as in flight before the tip is requested, so
+	// it is not requested again after another restart.
+	r, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.state.Raffles[raffle.ID].Results[0].inFlight() {
+		t.Fatal("payout not in flight")
+	}
+}
+
+func TestFailedPayoutRepay(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	r, err := New(Config{DataDir: t.TempDir(), Log: slog.Disabled, Bot: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var admin, winner zkidentity.ShortID
+	admin[0], winner[0] = 1, 2
+	if err := b.WhitelistAdd(admin); err != nil {
+		t.Fatal(err)
+	}
+	raffle := drawnRaffle(ctx, t, r, winner)
+	id := raffle.ID
+
+	// Every try fails to be requested.
+	srv.SetTipUserErr(errors.New("no route to user"))
+	now := time.Now()
+	for i := 0; i < maxPayoutTries+1; i++ {
+		r.payPending(ctx, now)
+		now = now.Add(payoutRetryDelay)
+	}
+	w := r.state.Raffles[id].Results[0]
+	if !w.pending() || w.Tries != maxPayoutTries || w.Err == "" {
+		t.Fatalf("unexpected winner after failed tries: %+v", w)
+	}
+	srv.SetTipUserErr(nil)
+	r.payPending(ctx, now)
+	if got := len(srv.Tips()); got != 0 {
+		t.Fatalf("%d tips requested after the last try, want 0", got)
+	}
+
+	// Only admins may retry.
+	if err := r.HandleCommand(ctx, "dev", winner, "winner", []string{"repay", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.Tips()); got != 0 {
+		t.Fatalf("%d tips requested by a non admin, want 0", got)
+	}
+	if err := r.HandleCommand(ctx, "dev", admin, "admin", []string{"repay", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.Tips()); got != 1 {
+		t.Fatalf("%d tips requested by repay, want 1", got)
+	}
+	gcms := srv.GCMs()
+	if last := gcms[len(gcms)-1].Msg; !strings.Contains(last, "retrying 1 payout") {
+		t.Fatalf("unexpected repay reply %q", last)
+	}
+
+	// A payout in flight is not requested again.
+	if err := r.HandleCommand(ctx, "dev", admin, "admin", []string{"repay", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.Tips()); got != 1 {
+		t.Fatalf("%d tips requested, want 1", got)
+	}
+
+	// A final failure returns the winner to pending for Run to retry.
+	ev := &types.TipProgressEvent{
+		Uid:          winner[:],
+		AmountMatoms: int64(w.Amount) * 1000,
+		AttemptErr:   "invoice expired",
+	}
+	r.HandleTipProgress(ctx, ev)
+	if w := r.state.Raffles[id].Results[0]; !w.pending() || w.Err != "invoice expired" {
+		t.Fatalf("unexpected winner after a final failure: %+v", w)
+	}
+	r.payPending(ctx, time.Now().Add(payoutRetryDelay))
+	if got := len(srv.Tips()); got != 2 {
+		t.Fatalf("%d tips requested, want 2", got)
+	}
+
+	ev = &types.TipProgressEvent{
+		Uid:          winner[:],
+		AmountMatoms: int64(w.Amount) * 1000,
+		Completed:    true,
+	}
+	r.HandleTipProgress(ctx, ev)
+	if w := r.state.Raffles[id].Results[0]; !w.Paid {
+		t.Fatalf("winner not paid: %+v", w)
+	}
+}
+
+func TestTipProgressOfOtherModulesIgnored(t *testing.T) {
+	ctx, _, b := bottest.NewBot(t)
+	r, err := New(Config{DataDir: t.TempDir(), Log: slog.Disabled, Bot: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var winner zkidentity.ShortID
+	winner[0] = 1
+	raffle := drawnRaffle(ctx, t, r, winner)
+
+	// The winner was not paid yet, so a tip of the same amount comes
+	// from another module.
+	ev := &types.TipProgressEvent{
+		Uid:          winner[:],
+		AmountMatoms: int64(raffle.Results[0].Amount) * 1000,
+		Completed:    true,
+	}
+	r.HandleTipProgress(ctx, ev)
+	if w := r.state.Raffles[raffle.ID].Results[0]; w.Paid {
+		t.Fatal("winner marked as paid by a tip the raffle did not send")
+	}
+}
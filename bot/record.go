@@ -0,0 +1,145 @@
+package bot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Kinds of recorded inbound notifications.
+const (
+	RecordPM             = "pm"
+	RecordGCM            = "gcm"
+	RecordKX             = "kx"
+	RecordGCInvite       = "gcinvite"
+	RecordMembersAdded   = "membersadded"
+	RecordMembersRemoved = "membersremoved"
+	RecordJoinedGC       = "joinedgc"
+	RecordPost           = "post"
+	RecordPostStatus     = "poststatus"
+	RecordTipProgress    = "tipprogress"
+)
+
+// RecordedEvent is a single line of a recording. Inbound events have In set
+// and Kind is one of the Record constants. Outbound events have Kind set to
+// the name of the called RPC method, such as "ChatService.PM".
+type RecordedEvent struct {
+	Time time.Time       `json:"time"`
+	In   bool            `json:"in"`
+	Kind string          `json:"kind"`
+	Msg  json.RawMessage `json:"msg"`
+}
+
+// Recorder writes events to a gzip compressed JSONL file.
+type Recorder struct {
+	mtx sync.Mutex
+	f   *os.File
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+// NewRecorder creates a recording in dir named after the current time.
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("events-%s.jsonl.gz", time.Now().UTC().Format("20060102-150405"))
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	return &Recorder{f: f, gz: gz, enc: json.NewEncoder(gz)}, nil
+}
+
+// Record writes an event. The compressed stream is flushed after every event
+// so the recording survives a crash.
+func (r *Recorder) Record(in bool, kind string, m proto.Message) error {
+	raw, err := protojson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	e := RecordedEvent{
+		Time: time.Now(),
+		In:   in,
+		Kind: kind,
+		Msg:  raw,
+	}
+
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+	if err := r.enc.Encode(&e); err != nil {
+		return err
+	}
+	return r.gz.Flush()
+}
+
+// Close finishes the recording.
+func (r *Recorder) Close() error {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+	if err := r.gz.Close(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
+
+// ReadRecording calls f for every event of the recording at path, in order.
+// A truncated recording, as left by a crash, is read up to its last complete
+// event.
+func ReadRecording(path string, f func(e *RecordedEvent) error) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	gz, err := gzip.NewReader(fd)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	for {
+		var e RecordedEvent
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := f(&e); err != nil {
+			return err
+		}
+	}
+}
+
+// recordIn records an inbound notification when recording is enabled.
+func (b *Bot) recordIn(kind string, m proto.Message) {
+	b.record(true, kind, m)
+}
+
+// recordOut records an outbound call when recording is enabled.
+func (b *Bot) recordOut(method string, m proto.Message) {
+	b.record(false, method, m)
+}
+
+func (b *Bot) record(in bool, kind string, m proto.Message) {
+	if b.recorder == nil {
+		return
+	}
+	if err := b.recorder.Record(in, kind, m); err != nil {
+		b.log.Errorf("Unable to record %v event: %v", kind, err)
+	}
+}
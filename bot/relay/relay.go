@@ -0,0 +1,259 @@
+package relay
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+const (
+	defaultExcerptLen = 280
+
+	// seenTTL is how long relayed posts are remembered.
+	seenTTL = 30 * 24 * time.Hour
+)
+
+// Route forwards posts to a GC. An empty Authors or Keywords list matches
+// every post.
+type Route struct {
+	GC string
+
+	// Authors are the ids or nicks of post authors to forward.
+	Authors []string
+
+	// Keywords are matched case insensitively against the post title and
+	// body. A post is forwarded if any keyword matches.
+	Keywords []string
+}
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot    bot.API
+	Routes []Route
+
+	// ExcerptLen is the maximum number of characters of the post body
+	// included in the summary.
+	ExcerptLen int
+}
+
+// Relay forwards summaries of received posts to GCs.
+type Relay struct {
+	bot        bot.API
+	log        slog.Logger
+	routes     []Route
+	excerptLen int
+
+	// seen maps "<post id>/<gc>" to the time the post was relayed to
+	// the GC.
+	seen     map[string]int64
+	seenFile string
+	seenMtx  sync.Mutex
+}
+
+func New(cfg Config) (*Relay, error) {
+	for _, route := range cfg.Routes {
+		if route.GC == "" {
+			return nil, fmt.Errorf("route without gc")
+		}
+	}
+
+	excerptLen := cfg.ExcerptLen
+	if excerptLen <= 0 {
+		excerptLen = defaultExcerptLen
+	}
+
+	seen := make(map[string]int64)
+	seenFile := filepath.Join(cfg.DataDir, "relay.json")
+	seenBytes, err := os.ReadFile(seenFile)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(seenBytes, &seen); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Relay{
+		bot:        cfg.Bot,
+		log:        cfg.Log,
+		routes:     cfg.Routes,
+		excerptLen: excerptLen,
+
+		seen:     seen,
+		seenFile: seenFile,
+	}, nil
+}
+
+// Subscribe subscribes to the posts of every author given by id in the
+// routes.
+func (r *Relay) Subscribe(ctx context.Context) error {
+	for _, route := range r.routes {
+		for _, author := range route.Authors {
+			var id zkidentity.ShortID
+			if err := id.FromString(author); err != nil {
+				// Nick, nothing to subscribe to.
+				continue
+			}
+			if err := r.bot.SubscribeToUserPosts(ctx, id); err != nil {
+				return fmt.Errorf("subscribe to %v: %w", author, err)
+			}
+		}
+	}
+	return nil
+}
+
+// HandlePost forwards a summary of the post to every GC with a matching
+// route. GCs the post was already forwarded to are skipped, so a post may be
+// handled again to retry the GCs it failed to reach, which are returned in
+// the error.
+func (r *Relay) HandlePost(ctx context.Context, p *types.ReceivedPost) error {
+	if p.Summary == nil || p.Post == nil {
+		return nil
+	}
+	postID := hex.EncodeToString(p.Summary.Id)
+
+	authorID := hex.EncodeToString(p.Summary.AuthorId)
+	title := postTitle(p)
+	body := p.Post.Attributes["main"]
+
+	var (
+		summary string
+		failed  []string
+	)
+	for _, route := range r.routes {
+		if !matchAuthor(route.Authors, authorID, p.Summary.AuthorNick) {
+			continue
+		}
+		if !matchKeywords(route.Keywords, title, body) {
+			continue
+		}
+		if r.relayed(postID, route.GC) {
+			r.log.Debugf("post %v already relayed to gc %v", postID, route.GC)
+			continue
+		}
+		if summary == "" {
+			summary = r.summarize(p, postID, title, body)
+		}
+		if err := r.bot.SendGC(ctx, route.GC, summary); err != nil {
+			r.log.Errorf("failed to relay post %v to gc %v: %v",
+				postID, route.GC, err)
+			failed = append(failed, route.GC)
+			continue
+		}
+		r.log.Infof("relayed post %v to gc %v", postID, route.GC)
+		if err := r.markSeen(postID, route.GC, time.Now()); err != nil {
+			r.log.Errorf("failed to save relayed posts: %v", err)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to relay post %v to %v", postID,
+			strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (r *Relay) relayed(postID, gc string) bool {
+	defer r.seenMtx.Unlock()
+	r.seenMtx.Lock()
+
+	_, ok := r.seen[postID+"/"+gc]
+	return ok
+}
+
+// markSeen records that the post was relayed to gc and forgets the posts
+// relayed more than seenTTL ago.
+func (r *Relay) markSeen(postID, gc string, now time.Time) error {
+	defer r.seenMtx.Unlock()
+	r.seenMtx.Lock()
+
+	for k, ts := range r.seen {
+		if now.Sub(time.Unix(ts, 0)) > seenTTL {
+			delete(r.seen, k)
+		}
+	}
+	r.seen[postID+"/"+gc] = now.Unix()
+	raw, err := json.Marshal(r.seen)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.seenFile, raw, 0o600)
+}
+
+func (r *Relay) summarize(p *types.ReceivedPost, postID, title, body string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "New post by %v: %v\n", p.Summary.AuthorNick, title)
+	if excerpt := excerpt(body, r.excerptLen); excerpt != "" {
+		sb.WriteString(excerpt)
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(&sb, "Post %v from %v", postID,
+		hex.EncodeToString(p.Summary.AuthorId))
+	return sb.String()
+}
+
+func postTitle(p *types.ReceivedPost) string {
+	if title := p.Post.Attributes["title"]; title != "" {
+		return title
+	}
+	if p.Summary.Title != "" {
+		return p.Summary.Title
+	}
+	return "(untitled)"
+}
+
+func matchAuthor(authors []string, id, nick string) bool {
+	if len(authors) == 0 {
+		return true
+	}
+	for _, author := range authors {
+		if strings.EqualFold(author, id) || author == nick {
+			return true
+		}
+	}
+	return false
+}
+
+func matchKeywords(keywords []string, title, body string) bool {
+	if len(keywords) == 0 {
+		return true
+	}
+	title = strings.ToLower(title)
+	body = strings.ToLower(body)
+	for _, kw := range keywords {
+		kw = strings.ToLower(kw)
+		if strings.Contains(title, kw) || strings.Contains(body, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+var embedRegexp = regexp.MustCompile(`--embed\[.*?\]--`)
+
+// excerpt returns the first n characters of the body, with embeds removed
+// and whitespace collapsed.
+func excerpt(body string, n int) string {
+	body = embedRegexp.ReplaceAllString(body, "")
+	body = strings.Join(strings.Fields(body), " ")
+	runes := []rune(body)
+	if len(runes) <= n {
+		return body
+	}
+	return strings.TrimSpace(string(runes[:n])) + "…"
+}
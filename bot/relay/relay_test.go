@@ -0,0 +1,125 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/decred/slog"
+)
+
+// downGC fails to send to one GC.
+type downGC struct {
+	bot.API
+	gc string
+}
+
+func (b *downGC) SendGC(ctx context.Context, gc, msg string) error {
+	if gc == b.gc {
+		return errors.New("gc unavailable")
+	}
+	return b.API.SendGC(ctx, gc, msg)
+}
+
+func testPost(id byte, title, body string) *types.ReceivedPost {
+	return &types.ReceivedPost{
+		Summary: &types.PostSummary{
+			Id:         []byte{id},
+			AuthorId:   []byte{9},
+			AuthorNick: "alice",
+		},
+		Post: &types.PostMetadata{
+			Attributes: map[string]string{"title": title, "main": body},
+		},
+	}
+}
+
+func TestHandlePost(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+	fb := &downGC{API: b, gc: "ops"}
+	dataDir := t.TempDir()
+	cfg := Config{
+		DataDir: dataDir,
+		Log:     slog.Disabled,
+		Bot:     fb,
+		Routes: []Route{
+			{GC: "dev", Keywords: []string{"release"}},
+			{GC: "ops", Authors: []string{"alice"}},
+			{GC: "news", Authors: []string{"bob"}},
+		},
+	}
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := testPost(1, "New Release", "--embed[data]-- notes   here")
+	if err := r.HandlePost(ctx, p); err == nil || !strings.Contains(err.Error(), "ops") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	gcms := srv.GCMs()
+	if len(gcms) != 1 || gcms[0].Gc != "dev" {
+		t.Fatalf("unexpected gc messages %v", gcms)
+	}
+	if !strings.Contains(gcms[0].Msg, "New post by alice: New Release\nnotes here\n") {
+		t.Fatalf("unexpected summary %q", gcms[0].Msg)
+	}
+
+	// Handling the post again, after a restart, only retries the GC that
+	// failed.
+	fb.gc = ""
+	r, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.HandlePost(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.HandlePost(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	gcms = srv.GCMs()
+	if len(gcms) != 2 || gcms[1].Gc != "ops" {
+		t.Fatalf("unexpected gc messages %v", gcms)
+	}
+}
+
+func TestMarkSeenPrunes(t *testing.T) {
+	r, err := New(Config{DataDir: t.TempDir(), Log: slog.Disabled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := r.markSeen("01", "dev", now.Add(-seenTTL-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.markSeen("02", "dev", now); err != nil {
+		t.Fatal(err)
+	}
+	if r.relayed("01", "dev") || !r.relayed("02", "dev") || r.relayed("02", "ops") {
+		t.Fatalf("unexpected seen posts %v", r.seen)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		body string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"a  b\n\tc", 10, "a b c"},
+		{"héllo wörld", 5, "héllo…"},
+		{"x --embed[type=image/png,data=AAAA]-- y", 10, "x y"},
+	}
+	for _, tc := range tests {
+		if got := excerpt(tc.body, tc.n); got != tc.want {
+			t.Errorf("excerpt(%q, %d) = %q, want %q", tc.body, tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package remind
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence describes how a reminder repeats. The zero value does not
+// repeat.
+type Recurrence struct {
+	// Interval repeats the reminder every fixed duration.
+	Interval time.Duration `json:"interval,omitempty"`
+
+	// Daily and Weekly repeat the reminder at Hour:Minute in the
+	// user's time zone, every day or every Weekday.
+	Daily   bool         `json:"daily,omitempty"`
+	Weekly  bool         `json:"weekly,omitempty"`
+	Weekday time.Weekday `json:"weekday,omitempty"`
+	Hour    int          `json:"hour,omitempty"`
+	Minute  int          `json:"minute,omitempty"`
+}
+
+func (r Recurrence) IsZero() bool {
+	return r.Interval == 0 && !r.Daily && !r.Weekly
+}
+
+// next returns the first occurrence after t.
+func (r Recurrence) next(t time.Time, loc *time.Location) time.Time {
+	if r.Interval > 0 {
+		return t.Add(r.Interval)
+	}
+	t = t.In(loc)
+	next := time.Date(t.Year(), t.Month(), t.Day(), r.Hour, r.Minute, 0, 0, loc)
+	if r.Weekly {
+		days := (int(r.Weekday) - int(next.Weekday()) + 7) % 7
+		next = next.AddDate(0, 0, days)
+	}
+	for !next.After(t) {
+		if r.Weekly {
+			next = next.AddDate(0, 0, 7)
+		} else {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next
+}
+
+func (r Recurrence) String() string {
+	switch {
+	case r.Interval > 0:
+		return "every " + r.Interval.String()
+	case r.Weekly:
+		return fmt.Sprintf("every %v at %02d:%02d", r.Weekday, r.Hour, r.Minute)
+	case r.Daily:
+		return fmt.Sprintf("every day at %02d:%02d", r.Hour, r.Minute)
+	}
+	return ""
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+var units = map[string]time.Duration{
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute,
+	"minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour,
+	"hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour,
+	"weeks": 7 * 24 * time.Hour,
+}
+
+// parseWhen parses the time part of a reminder at the start of words and
+// returns when it is first due, how it repeats and the remaining words.
+//
+// Supported forms:
+//
+//	in 2h | in 90 minutes | in 3 days
+//	at 17:30 | today 17:30 | tomorrow [09:00]
+//	friday [10:00] | 2024-05-01 [14:00]
+//	every 2h | every day 09:00 | every monday 10:00
+func parseWhen(words []string, now time.Time, loc *time.Location) (time.Time, Recurrence, []string, error) {
+	var zero Recurrence
+	if len(words) == 0 {
+		return time.Time{}, zero, nil, fmt.Errorf("missing time")
+	}
+	now = now.In(loc)
+	first := strings.ToLower(words[0])
+
+	switch first {
+	case "in":
+		d, rest, err := parseDuration(words[1:])
+		if err != nil {
+			return time.Time{}, zero, nil, err
+		}
+		return now.Add(d), zero, rest, nil
+
+	case "every":
+		if len(words) < 2 {
+			return time.Time{}, zero, nil, fmt.Errorf("every what?")
+		}
+		second := strings.ToLower(words[1])
+		var r Recurrence
+		rest := words[2:]
+		if wd, ok := weekdays[second]; ok {
+			r.Weekly, r.Weekday = true, wd
+		} else if second == "day" {
+			r.Daily = true
+		} else {
+			d, rest, err := parseDuration(words[1:])
+			if err != nil {
+				return time.Time{}, zero, nil, err
+			}
+			if d < time.Minute {
+				return time.Time{}, zero, nil, fmt.Errorf("interval too short")
+			}
+			r.Interval = d
+			return now.Add(d), r, rest, nil
+		}
+		r.Hour, r.Minute, rest = parseOptionalClock(rest, 9, 0)
+		return r.next(now, loc), r, rest, nil
+	}
+
+	var day time.Time
+	rest := words[1:]
+	switch {
+	case first == "at":
+		if len(rest) == 0 {
+			return time.Time{}, zero, nil, fmt.Errorf("missing time after at")
+		}
+		h, m, ok := parseClock(rest[0])
+		if !ok {
+			return time.Time{}, zero, nil, fmt.Errorf("invalid time after at")
+		}
+		t := time.Date(now.Year(), now.Month(), now.Day(), h, m, 0, 0, loc)
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, zero, rest[1:], nil
+	case first == "today":
+		day = now
+	case first == "tomorrow":
+		day = now.AddDate(0, 0, 1)
+	default:
+		if wd, ok := weekdays[first]; ok {
+			days := (int(wd) - int(now.Weekday()) + 7) % 7
+			if days == 0 {
+				days = 7
+			}
+			day = now.AddDate(0, 0, days)
+		} else if t, err := time.ParseInLocation("2006-01-02", first, loc); err == nil {
+			day = t
+		} else {
+			return time.Time{}, zero, nil, fmt.Errorf("unknown time %q", words[0])
+		}
+	}
+	h, m, rest := parseOptionalClock(rest, 9, 0)
+	t := time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, loc)
+	if !t.After(now) {
+		return time.Time{}, zero, nil, fmt.Errorf("%v is in the past",
+			t.Format("2006-01-02 15:04"))
+	}
+	return t, zero, rest, nil
+}
+
+// maxDuration is the longest duration accepted by parseDuration.
+const maxDuration = 10 * 365 * 24 * time.Hour
+
+// parseDuration parses "2h", "2 hours" or "1h30m" at the start of words.
+func parseDuration(words []string) (time.Duration, []string, error) {
+	if len(words) == 0 {
+		return 0, nil, fmt.Errorf("missing duration")
+	}
+	if d, err := time.ParseDuration(words[0]); err == nil && d > 0 {
+		if d > maxDuration {
+			return 0, nil, fmt.Errorf("duration %q is too long", words[0])
+		}
+		return d, words[1:], nil
+	}
+
+	// Number followed by unit, either joined ("3d") or separate
+	// ("3 days").
+	num, unit := words[0], ""
+	rest := words[1:]
+	if i := strings.IndexFunc(num, func(r rune) bool { return r < '0' || r > '9' }); i > 0 {
+		num, unit = num[:i], num[i:]
+	} else if len(rest) > 0 {
+		unit, rest = rest[0], rest[1:]
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n <= 0 {
+		return 0, nil, fmt.Errorf("invalid duration %q", words[0])
+	}
+	mult, ok := units[strings.ToLower(unit)]
+	if !ok {
+		return 0, nil, fmt.Errorf("unknown time unit %q", unit)
+	}
+	if n > int(maxDuration/mult) {
+		return 0, nil, fmt.Errorf("duration %q is too long", words[0])
+	}
+	return time.Duration(n) * mult, rest, nil
+}
+
+// parseOptionalClock parses an optional "15:04" or "at 15:04" at the start
+// of words, returning the default time when there is none.
+func parseOptionalClock(words []string, defHour, defMin int) (int, int, []string) {
+	rest := words
+	if len(rest) > 1 && strings.EqualFold(rest[0], "at") {
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		if h, m, ok := parseClock(rest[0]); ok {
+			return h, m, rest[1:]
+		}
+	}
+	return defHour, defMin, words
+}
+
+func parseClock(s string) (int, int, bool) {
+	for _, layout := range []string{"15:04", "3:04pm", "3pm"} {
+		if t, err := time.Parse(layout, strings.ToLower(s)); err == nil {
+			return t.Hour(), t.Minute(), true
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,401 @@
+package remind
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+const (
+	checkInterval     = 5 * time.Second
+	defaultMaxPerUser = 20
+)
+
+// ErrTooManyReminders is returned by Add when the owner already has the
+// maximum number of pending reminders.
+var ErrTooManyReminders = errors.New("too many pending reminders")
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot bot.API
+
+	// DefaultZone is the time zone of users that have not set one.
+	// Defaults to UTC.
+	DefaultZone *time.Location
+
+	// MaxPerUser is the number of pending reminders a user may have.
+	// Defaults to 20.
+	MaxPerUser int
+}
+
+// Reminder is a message scheduled for delivery to a user or GC.
+type Reminder struct {
+	ID      int        `json:"id"`
+	Owner   string     `json:"owner"`
+	Nick    string     `json:"nick"`
+	GC      string     `json:"gc,omitempty"`
+	Msg     string     `json:"msg"`
+	Due     time.Time  `json:"due"`
+	Repeat  Recurrence `json:"repeat"`
+	Created time.Time  `json:"created"`
+}
+
+type state struct {
+	NextID    int               `json:"next_id"`
+	Reminders map[int]*Reminder `json:"reminders"`
+
+	// Zones maps user ids to time zone names.
+	Zones map[string]string `json:"zones"`
+}
+
+// Reminders delivers scheduled messages through PMs and GC messages.
+type Reminders struct {
+	bot         bot.API
+	log         slog.Logger
+	file        string
+	defaultZone *time.Location
+	maxPerUser  int
+
+	mtx   sync.Mutex
+	state state
+}
+
+func New(cfg Config) (*Reminders, error) {
+	defaultZone := cfg.DefaultZone
+	if defaultZone == nil {
+		defaultZone = time.UTC
+	}
+
+	maxPerUser := cfg.MaxPerUser
+	if maxPerUser <= 0 {
+		maxPerUser = defaultMaxPerUser
+	}
+
+	r := &Reminders{
+		bot:         cfg.Bot,
+		log:         cfg.Log,
+		file:        filepath.Join(cfg.DataDir, "reminders.json"),
+		defaultZone: defaultZone,
+		maxPerUser:  maxPerUser,
+		state: state{
+			NextID:    1,
+			Reminders: make(map[int]*Reminder),
+			Zones:     make(map[string]string),
+		},
+	}
+
+	raw, err := os.ReadFile(r.file)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(raw, &r.state); err != nil {
+			return nil, err
+		}
+		if r.state.Zones == nil {
+			r.state.Zones = make(map[string]string)
+		}
+	}
+
+	return r, nil
+}
+
+// HandleCommand handles the !remind command, sent either by PM (gc is
+// empty) or in a GC:
+//
+//	!remind me in 2h to deploy
+//	!remind #gc tomorrow 09:00 standup
+//	!remind me every monday 10:00 weekly report
+//	!remind list
+//	!remind cancel <id>
+//	!remind tz Europe/Lisbon
+//
+// Reminders to a GC may only be set by its members and whitelisted users.
+// The list of reminders is always sent by PM.
+func (r *Reminders) HandleCommand(ctx context.Context, gc string, uid zkidentity.ShortID, nick string, args []string) error {
+	reply := func(f string, a ...interface{}) error {
+		msg := fmt.Sprintf(f, a...)
+		if gc == "" {
+			return r.bot.SendPMUser(ctx, bot.UserRefID(uid), msg)
+		}
+		return r.bot.SendGC(ctx, gc, msg)
+	}
+	if len(args) == 0 {
+		return reply("usage: !remind me|#gc <when> <message> | list | cancel <id> | tz <zone>")
+	}
+
+	owner := uid.String()
+	switch strings.ToLower(args[0]) {
+	case "list":
+		return r.bot.SendPMUser(ctx, bot.UserRefID(uid), r.list(owner))
+
+	case "cancel":
+		if len(args) < 2 {
+			return reply("usage: !remind cancel <id>")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return reply("invalid reminder id %q", args[1])
+		}
+		if err := r.Cancel(owner, id); err != nil {
+			return reply("%v", err)
+		}
+		return reply("reminder %d canceled", id)
+
+	case "tz":
+		if len(args) < 2 {
+			return reply("your time zone is %v", r.zone(owner))
+		}
+		loc, err := time.LoadLocation(args[1])
+		if err != nil {
+			return reply("unknown time zone %q", args[1])
+		}
+		if err := r.setZone(owner, loc.String()); err != nil {
+			return err
+		}
+		return reply("time zone set to %v", loc)
+	}
+
+	rem := &Reminder{
+		Owner:   owner,
+		Nick:    nick,
+		Created: time.Now(),
+	}
+	switch target := args[0]; {
+	case strings.EqualFold(target, "me"):
+	case strings.HasPrefix(target, "#") && len(target) > 1:
+		rem.GC = target[1:]
+		ok, err := r.mayRemindGC(ctx, gc, uid, rem.GC)
+		if err != nil {
+			r.log.Warnf("Unable to check access of %v to gc %v: %v", nick, rem.GC, err)
+		}
+		if !ok {
+			return reply("you are not a member of %v", rem.GC)
+		}
+	default:
+		return reply("remind whom? use me or #gc")
+	}
+
+	loc := r.zone(owner)
+	due, repeat, rest, err := parseWhen(args[1:], rem.Created, loc)
+	if err != nil {
+		return reply("%v", err)
+	}
+	if len(rest) > 0 && strings.EqualFold(rest[0], "to") {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return reply("remind about what?")
+	}
+	rem.Msg = strings.Join(rest, " ")
+	rem.Due = due
+	rem.Repeat = repeat
+
+	id, err := r.Add(rem)
+	if errors.Is(err, ErrTooManyReminders) {
+		return reply("you already have %d pending reminders", r.maxPerUser)
+	}
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("reminder %d set for %v", id,
+		due.In(loc).Format("Mon 2006-01-02 15:04 MST"))
+	if !repeat.IsZero() {
+		msg += " (" + repeat.String() + ")"
+	}
+	return reply("%s", msg)
+}
+
+// mayRemindGC returns whether uid, who sent the command in fromGC, may set a
+// reminder to target: whitelisted users and the members of target.
+func (r *Reminders) mayRemindGC(ctx context.Context, fromGC string, uid zkidentity.ShortID, target string) (bool, error) {
+	if r.bot.IsWhitelisted(uid) || (fromGC != "" && strings.EqualFold(fromGC, target)) {
+		return true, nil
+	}
+	info, err := r.bot.FindGC(ctx, target)
+	if err != nil {
+		return false, err
+	}
+	if !strings.EqualFold(info.Name, target) {
+		return false, nil
+	}
+	return r.bot.IsGCMember(ctx, info.ID, uid)
+}
+
+// Add schedules a reminder and returns its id. It fails with
+// ErrTooManyReminders when the owner has too many pending reminders.
+func (r *Reminders) Add(rem *Reminder) (int, error) {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	var pending int
+	for _, other := range r.state.Reminders {
+		if other.Owner == rem.Owner {
+			pending++
+		}
+	}
+	if pending >= r.maxPerUser {
+		return 0, ErrTooManyReminders
+	}
+
+	rem.ID = r.state.NextID
+	r.state.NextID++
+	r.state.Reminders[rem.ID] = rem
+	r.log.Infof("reminder %d from %v due at %v", rem.ID, rem.Nick, rem.Due)
+	return rem.ID, r.save()
+}
+
+// Cancel removes a reminder of the given owner.
+func (r *Reminders) Cancel(owner string, id int) error {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	rem, ok := r.state.Reminders[id]
+	if !ok || rem.Owner != owner {
+		return fmt.Errorf("unknown reminder %d", id)
+	}
+	delete(r.state.Reminders, id)
+	return r.save()
+}
+
+// List returns the pending reminders of a user, soonest first.
+func (r *Reminders) List(owner string) []Reminder {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	var res []Reminder
+	for _, rem := range r.state.Reminders {
+		if rem.Owner == owner {
+			res = append(res, *rem)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Due.Before(res[j].Due)
+	})
+	return res
+}
+
+func (r *Reminders) list(owner string) string {
+	rems := r.List(owner)
+	if len(rems) == 0 {
+		return "no pending reminders"
+	}
+	loc := r.zone(owner)
+	var sb strings.Builder
+	for _, rem := range rems {
+		target := "you"
+		if rem.GC != "" {
+			target = "#" + rem.GC
+		}
+		fmt.Fprintf(&sb, "%d) %v to %v: %v", rem.ID,
+			rem.Due.In(loc).Format("Mon 2006-01-02 15:04"), target, rem.Msg)
+		if !rem.Repeat.IsZero() {
+			fmt.Fprintf(&sb, " (%v)", rem.Repeat)
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// Run delivers due reminders until the context is canceled.
+func (r *Reminders) Run(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			r.deliver(ctx, now)
+		}
+	}
+}
+
+func (r *Reminders) deliver(ctx context.Context, now time.Time) {
+	r.mtx.Lock()
+	var due []Reminder
+	for id, rem := range r.state.Reminders {
+		if rem.Due.After(now) {
+			continue
+		}
+		due = append(due, *rem)
+		if rem.Repeat.IsZero() {
+			delete(r.state.Reminders, id)
+			continue
+		}
+		rem.Due = rem.Repeat.next(now, r.zoneLocked(rem.Owner))
+	}
+	var err error
+	if len(due) > 0 {
+		err = r.save()
+	}
+	r.mtx.Unlock()
+	if err != nil {
+		r.log.Errorf("failed to save reminders: %v", err)
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+	for _, rem := range due {
+		var err error
+		if rem.GC != "" {
+			err = r.bot.SendGC(ctx, rem.GC, fmt.Sprintf("Reminder from %v: %v", rem.Nick, rem.Msg))
+		} else {
+			err = r.bot.SendPM(ctx, rem.Owner, "Reminder: "+rem.Msg)
+		}
+		if err != nil {
+			r.log.Errorf("failed to deliver reminder %d: %v", rem.ID, err)
+		}
+	}
+}
+
+func (r *Reminders) setZone(owner, zone string) error {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+
+	r.state.Zones[owner] = zone
+	return r.save()
+}
+
+func (r *Reminders) zone(owner string) *time.Location {
+	defer r.mtx.Unlock()
+	r.mtx.Lock()
+	return r.zoneLocked(owner)
+}
+
+// zoneLocked returns the time zone of a user. Must be called with the mutex
+// held.
+func (r *Reminders) zoneLocked(owner string) *time.Location {
+	name, ok := r.state.Zones[owner]
+	if !ok {
+		return r.defaultZone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return r.defaultZone
+	}
+	return loc
+}
+
+// save writes the reminders to disk. Must be called with the mutex held.
+func (r *Reminders) save() error {
+	raw, err := json.Marshal(&r.state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.file, raw, 0o600)
+}
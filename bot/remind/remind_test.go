@@ -0,0 +1,140 @@
+package remind
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+func newTestReminders(t *testing.T, maxPerUser int) (context.Context, *bottest.Server, *Reminders) {
+	t.Helper()
+	ctx, srv, b := bottest.NewBot(t)
+	r, err := New(Config{
+		DataDir:    t.TempDir(),
+		Log:        slog.Disabled,
+		Bot:        b,
+		MaxPerUser: maxPerUser,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx, srv, r
+}
+
+// lastPM returns the text of the last PM sent by the bot.
+func lastPM(t *testing.T, srv *bottest.Server) string {
+	t.Helper()
+	pms := srv.PMs()
+	if len(pms) == 0 {
+		t.Fatal("no pm sent")
+	}
+	return pms[len(pms)-1].Msg.Message
+}
+
+func TestGCReminderRequiresMembership(t *testing.T) {
+	ctx, srv, r := newTestReminders(t, 0)
+
+	var member, outsider zkidentity.ShortID
+	member[0], outsider[0] = 1, 2
+	gcID := bytes.Repeat([]byte{9}, 32)
+	srv.SetGCs([]*types.ListGCsResponse_GCInfo{{Id: gcID, Name: "dev", NbMembers: 1}})
+	srv.SetGCMembers(gcID, member[:])
+	args := strings.Fields("#dev in 2h standup")
+
+	if err := r.HandleCommand(ctx, "", outsider, "outsider", args); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastPM(t, srv); got != "you are not a member of dev" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+	if got := len(r.List(outsider.String())); got != 0 {
+		t.Fatalf("%d reminders set by an outsider, want 0", got)
+	}
+
+	if err := r.HandleCommand(ctx, "", member, "member", args); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastPM(t, srv); !strings.HasPrefix(got, "reminder 1 set") {
+		t.Fatalf("unexpected reply %q", got)
+	}
+	if got := len(r.List(member.String())); got != 1 {
+		t.Fatalf("%d reminders set by a member, want 1", got)
+	}
+
+	// Asking in the GC itself is allowed.
+	if err := r.HandleCommand(ctx, "dev", outsider, "outsider", args); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(r.List(outsider.String())); got != 1 {
+		t.Fatalf("%d reminders set from the gc, want 1", got)
+	}
+}
+
+func TestMaxPerUser(t *testing.T) {
+	ctx, srv, r := newTestReminders(t, 2)
+
+	var uid zkidentity.ShortID
+	uid[0] = 1
+	args := strings.Fields("me in 2h stretch")
+	for i := 0; i < 3; i++ {
+		if err := r.HandleCommand(ctx, "", uid, "u", args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := lastPM(t, srv); got != "you already have 2 pending reminders" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+	if got := len(r.List(uid.String())); got != 2 {
+		t.Fatalf("%d reminders, want 2", got)
+	}
+}
+
+func TestParseDurationBounds(t *testing.T) {
+	tests := []struct {
+		words []string
+		ok    bool
+	}{
+		{[]string{"2h"}, true},
+		{[]string{"3", "days"}, true},
+		{[]string{"99999999999", "days"}, false},
+		{[]string{"9999999999999d"}, false},
+		{[]string{"100000h"}, false},
+	}
+	for _, tc := range tests {
+		d, _, err := parseDuration(tc.words)
+		if (err == nil) != tc.ok {
+			t.Fatalf("%v: unexpected error %v", tc.words, err)
+		}
+		if err == nil && (d <= 0 || d > maxDuration) {
+			t.Fatalf("%v: duration %v out of range", tc.words, d)
+		}
+	}
+}
+
+func TestListSentByPM(t *testing.T) {
+	ctx, srv, r := newTestReminders(t, 0)
+
+	var uid zkidentity.ShortID
+	uid[0] = 1
+	if err := r.HandleCommand(ctx, "", uid, "u", strings.Fields("me in 2h private thing")); err != nil {
+		t.Fatal(err)
+	}
+	gcms := len(srv.GCMs())
+	if err := r.HandleCommand(ctx, "dev", uid, "u", []string{"list"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.GCMs()); got != gcms {
+		t.Fatalf("list sent to the gc: %v", srv.GCMs()[gcms:])
+	}
+	pms := srv.PMs()
+	last := pms[len(pms)-1]
+	if last.User != uid.String() || !strings.Contains(last.Msg.Message, "private thing") {
+		t.Fatalf("unexpected list %v", last)
+	}
+}
@@ -0,0 +1,422 @@
+package threads
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/decred/slog"
+)
+
+// NotifyMode defines how post authors are told about new comments.
+type NotifyMode int
+
+const (
+	// NotifyNone does not notify post authors.
+	NotifyNone NotifyMode = iota
+
+	// NotifyImmediate sends one PM to the post author per comment.
+	NotifyImmediate
+
+	// NotifyDigest sends a periodic PM with every comment received since
+	// the last digest.
+	NotifyDigest
+)
+
+const defaultDigestInterval = 24 * time.Hour
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Bot bot.API
+
+	NotifyMode     NotifyMode
+	DigestInterval time.Duration
+}
+
+// Comment is a single comment on a post.
+type Comment struct {
+	ID        string `json:"id"`
+	Parent    string `json:"parent,omitempty"`
+	From      string `json:"from"`
+	FromNick  string `json:"from_nick"`
+	Comment   string `json:"comment"`
+	Timestamp int64  `json:"timestamp"`
+
+	// Replies is only filled in threads returned by Tracker.Thread.
+	Replies []*Comment `json:"-"`
+}
+
+// Thread is the comment tree of a post.
+type Thread struct {
+	PostID     string `json:"post_id"`
+	AuthorID   string `json:"author_id,omitempty"`
+	AuthorNick string `json:"author_nick,omitempty"`
+	Hearts     int    `json:"hearts"`
+
+	// HeartedBy are the ids of the users whose heart is counted in
+	// Hearts, so repeated hearts from a user are only counted once.
+	HeartedBy []string `json:"hearted_by,omitempty"`
+
+	// Comments are all comments on the post, in the order they were
+	// received.
+	Comments []*Comment `json:"comments"`
+
+	// Pending are the ids of comments not yet sent in a digest.
+	Pending []string `json:"pending,omitempty"`
+
+	// Roots is only filled in threads returned by Tracker.Thread.
+	Roots []*Comment `json:"-"`
+}
+
+// Tracker rebuilds and persists the comment threads of posts from post
+// status updates.
+type Tracker struct {
+	bot            bot.API
+	log            slog.Logger
+	dir            string
+	notifyMode     NotifyMode
+	digestInterval time.Duration
+
+	mtx     sync.Mutex
+	threads map[string]*Thread
+}
+
+func New(cfg Config) (*Tracker, error) {
+	dir := filepath.Join(cfg.DataDir, "threads")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	digestInterval := cfg.DigestInterval
+	if digestInterval <= 0 {
+		digestInterval = defaultDigestInterval
+	}
+
+	t := &Tracker{
+		bot:            cfg.Bot,
+		log:            cfg.Log,
+		dir:            dir,
+		notifyMode:     cfg.NotifyMode,
+		digestInterval: digestInterval,
+		threads:        make(map[string]*Thread),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var thread Thread
+		if err = json.Unmarshal(raw, &thread); err != nil {
+			return nil, fmt.Errorf("%v: %w", entry.Name(), err)
+		}
+		t.threads[thread.PostID] = &thread
+	}
+
+	return t, nil
+}
+
+// Run sends comment digests to post authors until the context is canceled.
+// It returns immediately when the tracker is not in digest mode.
+func (t *Tracker) Run(ctx context.Context) error {
+	if t.notifyMode != NotifyDigest {
+		return nil
+	}
+
+	ticker := time.NewTicker(t.digestInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			t.sendDigests(ctx)
+		}
+	}
+}
+
+// HandlePost records the author of a post, which is needed to notify them
+// of new comments.
+func (t *Tracker) HandlePost(p *types.ReceivedPost) error {
+	if p.Summary == nil {
+		return nil
+	}
+	postID := hex.EncodeToString(p.Summary.Id)
+
+	defer t.mtx.Unlock()
+	t.mtx.Lock()
+
+	thread := t.thread(postID)
+	thread.AuthorID = hex.EncodeToString(p.Summary.AuthorId)
+	thread.AuthorNick = p.Summary.AuthorNick
+	return t.save(thread)
+}
+
+// HandlePostStatus adds the comment or heart in the status update to the
+// thread of its post.
+func (t *Tracker) HandlePostStatus(ctx context.Context, ps *types.ReceivedPostStatus) error {
+	if ps.Status == nil {
+		return nil
+	}
+	postID := hex.EncodeToString(ps.PostId)
+	attrs := ps.Status.Attributes
+
+	t.mtx.Lock()
+	thread := t.thread(postID)
+
+	from := hex.EncodeToString(ps.StatusFrom)
+	switch {
+	case attrs[rpc.RMPSHeart] == rpc.RMPSHeartYes:
+		if !thread.hearted(from) {
+			thread.HeartedBy = append(thread.HeartedBy, from)
+			thread.Hearts++
+		}
+	case attrs[rpc.RMPSHeart] == rpc.RMPSHeartNo:
+		for i, id := range thread.HeartedBy {
+			if id == from {
+				thread.HeartedBy = append(thread.HeartedBy[:i], thread.HeartedBy[i+1:]...)
+				if thread.Hearts > 0 {
+					thread.Hearts--
+				}
+				break
+			}
+		}
+	}
+
+	var cmt *Comment
+	if text := attrs[rpc.RMPSComment]; text != "" {
+		status := rpc.PostMetadataStatus{
+			Version:    ps.Status.Version,
+			From:       ps.Status.From,
+			Link:       ps.Status.Link,
+			Attributes: attrs,
+		}
+		id := status.Hash()
+		cmt = &Comment{
+			ID:       hex.EncodeToString(id[:]),
+			Parent:   attrs[rpc.RMPParent],
+			From:     from,
+			FromNick: ps.StatusFromNick,
+			Comment:  text,
+		}
+		if ts, err := strconv.ParseInt(attrs[rpc.RMPTimestamp], 16, 64); err == nil {
+			cmt.Timestamp = ts
+		} else {
+			cmt.Timestamp = time.Now().Unix()
+		}
+
+		if thread.comment(cmt.ID) != nil {
+			// Duplicate status update.
+			cmt = nil
+		} else {
+			thread.Comments = append(thread.Comments, cmt)
+			if t.notifyMode == NotifyDigest {
+				thread.Pending = append(thread.Pending, cmt.ID)
+			}
+		}
+	}
+
+	err := t.save(thread)
+	author, authorID := thread.author(), thread.AuthorID
+	t.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if cmt == nil || t.notifyMode != NotifyImmediate || author == "" {
+		return nil
+	}
+	if cmt.From == authorID {
+		// Don't notify authors of their own comments.
+		return nil
+	}
+	msg := fmt.Sprintf("New comment on your post %v from %v: %v",
+		postID, cmt.FromNick, cmt.Comment)
+	return t.bot.SendPM(ctx, author, msg)
+}
+
+// Thread returns a copy of the comment tree of the given post.
+func (t *Tracker) Thread(postID string) (*Thread, error) {
+	defer t.mtx.Unlock()
+	t.mtx.Lock()
+
+	thread, ok := t.threads[postID]
+	if !ok {
+		return nil, fmt.Errorf("no comments for post %v", postID)
+	}
+
+	res := &Thread{
+		PostID:     thread.PostID,
+		AuthorID:   thread.AuthorID,
+		AuthorNick: thread.AuthorNick,
+		Hearts:     thread.Hearts,
+		Comments:   make([]*Comment, 0, len(thread.Comments)),
+	}
+	byID := make(map[string]*Comment, len(thread.Comments))
+	for _, c := range thread.Comments {
+		cmt := *c
+		res.Comments = append(res.Comments, &cmt)
+		byID[cmt.ID] = &cmt
+	}
+	for _, cmt := range res.Comments {
+		parent, ok := byID[cmt.Parent]
+		if !ok {
+			// Top level comment, or reply to a comment that was
+			// never received.
+			res.Roots = append(res.Roots, cmt)
+			continue
+		}
+		parent.Replies = append(parent.Replies, cmt)
+	}
+	return res, nil
+}
+
+// sendDigests sends the pending comments of each thread to its author. The
+// comments stay pending until the digest is sent, so a failed send is
+// retried with the next digest.
+func (t *Tracker) sendDigests(ctx context.Context) {
+	type digest struct {
+		postID  string
+		author  string
+		cmts    []*Comment
+		pending []string
+	}
+
+	t.mtx.Lock()
+	var digests []digest
+	for _, thread := range t.threads {
+		author := thread.author()
+		if len(thread.Pending) == 0 || author == "" {
+			continue
+		}
+		d := digest{
+			postID:  thread.PostID,
+			author:  author,
+			pending: append([]string(nil), thread.Pending...),
+		}
+		for _, id := range thread.Pending {
+			if cmt := thread.comment(id); cmt != nil && cmt.From != thread.AuthorID {
+				cmt := *cmt
+				d.cmts = append(d.cmts, &cmt)
+			}
+		}
+		if len(d.cmts) > 0 {
+			digests = append(digests, d)
+			continue
+		}
+		// Only comments of the author, nothing to send.
+		thread.Pending = nil
+		if err := t.save(thread); err != nil {
+			t.log.Errorf("failed to save thread %v: %v", thread.PostID, err)
+		}
+	}
+	t.mtx.Unlock()
+
+	sort.Slice(digests, func(i, j int) bool {
+		return digests[i].postID < digests[j].postID
+	})
+	for _, d := range digests {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%d new comments on your post %v:\n", len(d.cmts), d.postID)
+		for _, cmt := range d.cmts {
+			fmt.Fprintf(&sb, "<%v> %v\n", cmt.FromNick, cmt.Comment)
+		}
+		if err := t.bot.SendPM(ctx, d.author, sb.String()); err != nil {
+			t.log.Errorf("failed to send digest for post %v: %v", d.postID, err)
+			continue
+		}
+		t.digestSent(d.postID, d.pending)
+	}
+}
+
+// digestSent removes the sent comments from the pending comments of a
+// thread, keeping the ones received while the digest was being sent.
+func (t *Tracker) digestSent(postID string, sent []string) {
+	defer t.mtx.Unlock()
+	t.mtx.Lock()
+
+	thread, ok := t.threads[postID]
+	if !ok {
+		return
+	}
+	done := make(map[string]bool, len(sent))
+	for _, id := range sent {
+		done[id] = true
+	}
+	var pending []string
+	for _, id := range thread.Pending {
+		if !done[id] {
+			pending = append(pending, id)
+		}
+	}
+	thread.Pending = pending
+	if err := t.save(thread); err != nil {
+		t.log.Errorf("failed to save thread %v: %v", thread.PostID, err)
+	}
+}
+
+// thread returns the thread of the given post, creating it if needed. Must
+// be called with the mutex held.
+func (t *Tracker) thread(postID string) *Thread {
+	thread, ok := t.threads[postID]
+	if !ok {
+		thread = &Thread{PostID: postID}
+		t.threads[postID] = thread
+	}
+	return thread
+}
+
+// save writes the thread to disk. Must be called with the mutex held.
+func (t *Tracker) save(thread *Thread) error {
+	raw, err := json.Marshal(thread)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(t.dir, thread.PostID+".json"), raw, 0o600)
+}
+
+func (th *Thread) comment(id string) *Comment {
+	for _, cmt := range th.Comments {
+		if cmt.ID == id {
+			return cmt
+		}
+	}
+	return nil
+}
+
+func (th *Thread) hearted(uid string) bool {
+	for _, id := range th.HeartedBy {
+		if id == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// author returns the user to PM about the thread.
+func (th *Thread) author() string {
+	if th.AuthorID != "" {
+		return th.AuthorID
+	}
+	return th.AuthorNick
+}
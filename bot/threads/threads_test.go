@@ -0,0 +1,165 @@
+package threads
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/decred/slog"
+)
+
+// flakyPM fails to send PMs while fail is set.
+type flakyPM struct {
+	bot.API
+	fail bool
+}
+
+func (b *flakyPM) SendPM(ctx context.Context, nick, msg string) error {
+	if b.fail {
+		return errors.New("user unreachable")
+	}
+	return b.API.SendPM(ctx, nick, msg)
+}
+
+var (
+	postID = []byte{1, 2, 3}
+	author = []byte{9}
+)
+
+func status(from byte, nick string, attrs map[string]string) *types.ReceivedPostStatus {
+	return &types.ReceivedPostStatus{
+		PostId:         postID,
+		StatusFrom:     []byte{from},
+		StatusFromNick: nick,
+		Status:         &types.PostMetadataStatus{Version: 1, Attributes: attrs},
+	}
+}
+
+func comment(from byte, nick, text, parent string, ts int64) *types.ReceivedPostStatus {
+	attrs := map[string]string{
+		rpc.RMPSComment:  text,
+		rpc.RMPTimestamp: strconv.FormatInt(ts, 16),
+	}
+	if parent != "" {
+		attrs[rpc.RMPParent] = parent
+	}
+	return status(from, nick, attrs)
+}
+
+func heart(from byte, yes bool) *types.ReceivedPostStatus {
+	v := rpc.RMPSHeartNo
+	if yes {
+		v = rpc.RMPSHeartYes
+	}
+	return status(from, "", map[string]string{rpc.RMPSHeart: v})
+}
+
+func newTestTracker(t *testing.T, mode NotifyMode) (context.Context, *bottest.Server, *flakyPM, *Tracker, Config) {
+	t.Helper()
+	ctx, srv, b := bottest.NewBot(t)
+	fb := &flakyPM{API: b}
+	cfg := Config{DataDir: t.TempDir(), Log: slog.Disabled, Bot: fb, NotifyMode: mode}
+	tr, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &types.ReceivedPost{Summary: &types.PostSummary{Id: postID, AuthorId: author, AuthorNick: "alice"}}
+	if err := tr.HandlePost(p); err != nil {
+		t.Fatal(err)
+	}
+	return ctx, srv, fb, tr, cfg
+}
+
+func TestThread(t *testing.T) {
+	ctx, srv, _, tr, cfg := newTestTracker(t, NotifyImmediate)
+
+	updates := []*types.ReceivedPostStatus{
+		heart(2, true),
+		heart(2, true),
+		heart(3, true),
+		heart(3, false),
+		comment(2, "bob", "first", "", 1),
+		comment(2, "bob", "first", "", 1),
+		comment(9, "alice", "thanks", "", 2),
+	}
+	for _, ps := range updates {
+		if err := tr.HandlePostStatus(ctx, ps); err != nil {
+			t.Fatal(err)
+		}
+	}
+	th, err := tr.Thread(hex.EncodeToString(postID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if th.Hearts != 1 {
+		t.Fatalf("%d hearts, want 1", th.Hearts)
+	}
+	if len(th.Comments) != 2 {
+		t.Fatalf("%d comments, want 2", len(th.Comments))
+	}
+	if err := tr.HandlePostStatus(ctx, comment(3, "carol", "reply", th.Comments[0].ID, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Threads are reloaded from disk.
+	tr, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	th, err = tr.Thread(hex.EncodeToString(postID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(th.Roots) != 2 || len(th.Roots[0].Replies) != 1 ||
+		th.Roots[0].Replies[0].Comment != "reply" {
+		t.Fatalf("unexpected tree %+v", th.Roots)
+	}
+
+	// The author was told about the comments of others only.
+	pms := srv.PMs()
+	if len(pms) != 2 || pms[0].User != hex.EncodeToString(author) ||
+		!strings.HasSuffix(pms[0].Msg.Message, "from bob: first") {
+		t.Fatalf("unexpected pms %v", pms)
+	}
+}
+
+func TestDigestKeptUntilSent(t *testing.T) {
+	ctx, srv, fb, tr, _ := newTestTracker(t, NotifyDigest)
+
+	for i, text := range []string{"one", "two"} {
+		if err := tr.HandlePostStatus(ctx, comment(2, "bob", text, "", int64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(srv.PMs()); got != 0 {
+		t.Fatalf("%d pms sent before the digest, want 0", got)
+	}
+
+	fb.fail = true
+	tr.sendDigests(ctx)
+	th := tr.threads[hex.EncodeToString(postID)]
+	if len(th.Pending) != 2 {
+		t.Fatalf("%d pending comments after a failed digest, want 2", len(th.Pending))
+	}
+
+	fb.fail = false
+	tr.sendDigests(ctx)
+	pms := srv.PMs()
+	if len(pms) != 1 || !strings.HasPrefix(pms[0].Msg.Message, "2 new comments") {
+		t.Fatalf("unexpected pms %v", pms)
+	}
+	if len(th.Pending) != 0 {
+		t.Fatalf("%d pending comments after the digest, want 0", len(th.Pending))
+	}
+	tr.sendDigests(ctx)
+	if got := len(srv.PMs()); got != 1 {
+		t.Fatalf("%d pms sent, want 1", got)
+	}
+}
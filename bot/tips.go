@@ -0,0 +1,64 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+// TipClaimer is implemented by modules that pay tips and track their
+// progress.
+//
+// Progress events do not identify the tip they belong to, so the bot
+// matches them by user and amount against the tips in flight of every
+// claimer and hands each event to the claimer with the oldest matching tip
+// only. That way one event settles one payout, even when several modules
+// pay the same amount to the same user.
+type TipClaimer interface {
+	// PendingTip returns when the oldest tip to uid for amount that is
+	// still in flight was requested.
+	PendingTip(uid zkidentity.ShortID, amount dcrutil.Amount) (time.Time, bool)
+
+	// HandleTipProgress records the progress of the oldest tip in flight
+	// to the user and for the amount of the event.
+	HandleTipProgress(ctx context.Context, ev *types.TipProgressEvent)
+}
+
+// AddTipClaimer registers a module that tracks the progress of its tips.
+// Claimers must be added before Run is called.
+func (b *Bot) AddTipClaimer(c TipClaimer) {
+	b.tipClaimers = append(b.tipClaimers, c)
+}
+
+// claimTip hands a tip progress event to the claimer with the oldest
+// matching tip in flight. It returns whether the event was claimed.
+func (b *Bot) claimTip(ctx context.Context, ev *types.TipProgressEvent) bool {
+	var uid zkidentity.ShortID
+	if len(ev.Uid) != len(uid) {
+		return false
+	}
+	copy(uid[:], ev.Uid)
+	amount := dcrutil.Amount(ev.AmountMatoms / 1000)
+
+	var (
+		claimer TipClaimer
+		oldest  time.Time
+	)
+	for _, c := range b.tipClaimers {
+		sentAt, ok := c.PendingTip(uid, amount)
+		if !ok {
+			continue
+		}
+		if claimer == nil || sentAt.Before(oldest) {
+			claimer, oldest = c, sentAt
+		}
+	}
+	if claimer == nil {
+		return false
+	}
+	claimer.HandleTipProgress(ctx, ev)
+	return true
+}
@@ -0,0 +1,62 @@
+package bot_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+// claimer has one tip in flight, requested at sentAt.
+type claimer struct {
+	uid    zkidentity.ShortID
+	amount dcrutil.Amount
+	sentAt time.Time
+	events chan int64
+}
+
+func (c *claimer) PendingTip(uid zkidentity.ShortID, amount dcrutil.Amount) (time.Time, bool) {
+	return c.sentAt, uid == c.uid && amount == c.amount
+}
+
+func (c *claimer) HandleTipProgress(_ context.Context, ev *types.TipProgressEvent) {
+	c.events <- ev.AmountMatoms
+}
+
+func TestTipClaims(t *testing.T) {
+	ctx, srv, b := bottest.NewBot(t)
+
+	var alice zkidentity.ShortID
+	alice[0] = 1
+	now := time.Now()
+	newer := &claimer{alice, 1e8, now, make(chan int64, 3)}
+	older := &claimer{alice, 1e8, now.Add(-time.Minute), make(chan int64, 3)}
+	other := &claimer{alice, 2e8, now, make(chan int64, 3)}
+	b.AddTipClaimer(newer)
+	b.AddTipClaimer(older)
+	b.AddTipClaimer(other)
+	go b.Run()
+
+	// Events of tips no module sent are not claimed, events matching
+	// several modules only go to the one with the oldest tip.
+	srv.InjectTipProgress(&types.TipProgressEvent{Uid: alice[:], AmountMatoms: 3e11})
+	srv.InjectTipProgress(&types.TipProgressEvent{Uid: alice[:], AmountMatoms: 1e11, Completed: true})
+	srv.InjectTipProgress(&types.TipProgressEvent{Uid: alice[:], AmountMatoms: 2e11, Completed: true})
+	if err := srv.WaitAcked(ctx, bottest.StreamTipProgress); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*claimer{older, other} {
+		select {
+		case <-c.events:
+		case <-ctx.Done():
+			t.Fatal("tip progress not claimed")
+		}
+	}
+	if got := len(newer.events) + len(older.events) + len(other.events); got != 0 {
+		t.Fatalf("%d extra tip progress events handled", got)
+	}
+}
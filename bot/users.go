@@ -0,0 +1,211 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"github.com/decred/slog"
+)
+
+var (
+	ErrUnknownUser   = errors.New("unknown user")
+	ErrAmbiguousNick = errors.New("nick is used by more than one user")
+)
+
+// User is an entry in the bot's user directory.
+type User struct {
+	ID        string    `json:"id"`
+	Nick      string    `json:"nick"`
+	PrevNicks []string  `json:"prev_nicks,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// UserRef refers to a user either by ID or by nick. References by ID are
+// always preferred since nicks are chosen by users and may collide.
+type UserRef struct {
+	ID   zkidentity.ShortID
+	Nick string
+}
+
+// UserRefID returns a reference to the user with the given ID.
+func UserRefID(id zkidentity.ShortID) UserRef {
+	return UserRef{ID: id}
+}
+
+// UserRefNick returns a reference to the user with the given nick.
+func UserRefNick(nick string) UserRef {
+	return UserRef{Nick: nick}
+}
+
+// ParseUserRef returns a reference by ID if s is a hex encoded user ID and a
+// reference by nick otherwise.
+func ParseUserRef(s string) UserRef {
+	var id zkidentity.ShortID
+	if len(s) == 64 && id.FromString(s) == nil {
+		return UserRef{ID: id}
+	}
+	return UserRef{Nick: s}
+}
+
+func (r UserRef) String() string {
+	if !r.ID.IsEmpty() {
+		return r.ID.String()
+	}
+	return r.Nick
+}
+
+// learnUser records the nick a user was seen with. Renames and nick
+// collisions are logged to log.
+func (b *Bot) learnUser(log slog.Logger, uid []byte, nick string) {
+	var id zkidentity.ShortID
+	if len(uid) != len(id) || nick == "" {
+		return
+	}
+	id.FromBytes(uid)
+	sid := id.String()
+	now := time.Now()
+
+	defer b.usersMtx.Unlock()
+	b.usersMtx.Lock()
+
+	u, ok := b.users[sid]
+	switch {
+	case !ok:
+		u = &User{ID: sid, Nick: nick, FirstSeen: now}
+		b.users[sid] = u
+	case u.Nick != nick:
+		log.Infof("User %v renamed from %q to %q", sid, u.Nick, nick)
+		u.PrevNicks = append(u.PrevNicks, u.Nick)
+		u.Nick = nick
+	default:
+		u.LastSeen = now
+		return
+	}
+	u.LastSeen = now
+
+	for oid, o := range b.users {
+		if oid != sid && o.Nick == nick {
+			log.Warnf("Nick %q is used by both %v and %v", nick, oid, sid)
+		}
+	}
+
+	if err := b.saveUsers(); err != nil {
+		log.Errorf("Unable to save user directory: %v", err)
+	}
+}
+
+// saveUsers writes the user directory to disk. Must be called with usersMtx
+// held.
+func (b *Bot) saveUsers() error {
+	raw, err := json.Marshal(b.users)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(b.usersFile, raw, 0o600)
+}
+
+// ResolveUser returns the ID of the referenced user. Nicks resolve only when
+// exactly one known user currently uses them.
+func (b *Bot) ResolveUser(ref UserRef) (zkidentity.ShortID, error) {
+	if !ref.ID.IsEmpty() {
+		return ref.ID, nil
+	}
+
+	defer b.usersMtx.Unlock()
+	b.usersMtx.Lock()
+
+	var (
+		id    zkidentity.ShortID
+		found bool
+	)
+	for _, u := range b.users {
+		if u.Nick != ref.Nick {
+			continue
+		}
+		if found {
+			return zkidentity.ShortID{}, fmt.Errorf("%w: %q", ErrAmbiguousNick, ref.Nick)
+		}
+		if err := id.FromString(u.ID); err != nil {
+			return zkidentity.ShortID{}, err
+		}
+		found = true
+	}
+	if !found {
+		return id, fmt.Errorf("%w: %q", ErrUnknownUser, ref.Nick)
+	}
+	return id, nil
+}
+
+// LookupUser returns the directory entry of the given user.
+func (b *Bot) LookupUser(id zkidentity.ShortID) (User, bool) {
+	defer b.usersMtx.Unlock()
+	b.usersMtx.Lock()
+
+	u, ok := b.users[id.String()]
+	if !ok {
+		return User{}, false
+	}
+	res := *u
+	res.PrevNicks = append([]string(nil), u.PrevNicks...)
+	return res, true
+}
+
+// Users returns every known user sorted by nick.
+func (b *Bot) Users() []User {
+	defer b.usersMtx.Unlock()
+	b.usersMtx.Lock()
+
+	res := make([]User, 0, len(b.users))
+	for _, u := range b.users {
+		c := *u
+		c.PrevNicks = append([]string(nil), u.PrevNicks...)
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Nick == res[j].Nick {
+			return res[i].ID < res[j].ID
+		}
+		return res[i].Nick < res[j].Nick
+	})
+	return res
+}
+
+// SendPMUser resolves ref and sends msg to the resolved ID, so the message
+// never reaches a different user that shares the nick.
+func (b *Bot) SendPMUser(ctx context.Context, ref UserRef, msg string) error {
+	id, err := b.ResolveUser(ref)
+	if err != nil {
+		return err
+	}
+	return b.SendPM(ctx, id.String(), msg)
+}
+
+// InviteToGCUser resolves ref and invites the resolved ID to gc.
+func (b *Bot) InviteToGCUser(ctx context.Context, gc string, ref UserRef) error {
+	id, err := b.ResolveUser(ref)
+	if err != nil {
+		return err
+	}
+	return b.InviteToGC(ctx, gc, id.String())
+}
+
+// MediateKXUser resolves mediator and target and requests a mediated KX
+// with target through mediator.
+func (b *Bot) MediateKXUser(ctx context.Context, mediator, target UserRef) error {
+	mid, err := b.ResolveUser(mediator)
+	if err != nil {
+		return err
+	}
+	tid, err := b.ResolveUser(target)
+	if err != nil {
+		return err
+	}
+	return b.MediateKX(ctx, mid.String(), tid.String())
+}
@@ -0,0 +1,89 @@
+package bot_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay-bot/bot/bottest"
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+func TestUserDirectory(t *testing.T) {
+	pmChan := make(chan types.ReceivedPM, 1)
+	dataDir := t.TempDir()
+	ctx, srv, b := bottest.NewBot(t, func(cfg *bot.Config) {
+		cfg.DataDir = dataDir
+		cfg.PMChan = pmChan
+	})
+	go b.Run()
+
+	var alice, bob, carol zkidentity.ShortID
+	alice[0], bob[0], carol[0] = 1, 2, 3
+	pms := []struct {
+		uid  zkidentity.ShortID
+		nick string
+	}{
+		{alice, "alice"},
+		{bob, "bob"},
+		{bob, "robert"},  // Rename.
+		{carol, "alice"}, // Nick collision.
+	}
+	for _, pm := range pms {
+		srv.InjectPM(pm.uid[:], pm.nick, "hi")
+		select {
+		case <-pmChan:
+		case <-ctx.Done():
+			t.Fatal("pm not received")
+		}
+	}
+
+	resolve := []struct {
+		ref  bot.UserRef
+		id   zkidentity.ShortID
+		err  error
+		desc string
+	}{
+		{bot.UserRefNick("robert"), bob, nil, "renamed user"},
+		{bot.UserRefNick("bob"), zkidentity.ShortID{}, bot.ErrUnknownUser, "previous nick"},
+		{bot.UserRefNick("alice"), zkidentity.ShortID{}, bot.ErrAmbiguousNick, "shared nick"},
+		{bot.UserRefNick("dave"), zkidentity.ShortID{}, bot.ErrUnknownUser, "unknown nick"},
+		{bot.ParseUserRef(carol.String()), carol, nil, "id"},
+	}
+	for _, r := range resolve {
+		id, err := b.ResolveUser(r.ref)
+		if !errors.Is(err, r.err) || id != r.id {
+			t.Fatalf("%v: resolved to %v, %v", r.desc, id, err)
+		}
+	}
+
+	u, ok := b.LookupUser(bob)
+	if !ok || u.Nick != "robert" || len(u.PrevNicks) != 1 || u.PrevNicks[0] != "bob" {
+		t.Fatalf("unexpected entry of bob %+v", u)
+	}
+
+	// Messages to an ambiguous nick are not sent.
+	if err := b.SendPMUser(ctx, bot.UserRefNick("alice"), "hi"); !errors.Is(err, bot.ErrAmbiguousNick) {
+		t.Fatalf("send to ambiguous nick: %v", err)
+	}
+	if err := b.SendPMUser(ctx, bot.UserRefNick("robert"), "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if sent := srv.PMs(); len(sent) != 1 || sent[0].User != bob.String() {
+		t.Fatalf("unexpected pms %v", sent)
+	}
+
+	// The directory is kept across restarts.
+	b.Close()
+	b2, err := bot.New(srv.BotConfig(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b2.Close()
+	users := b2.Users()
+	if len(users) != 3 || users[0].Nick != "alice" || users[1].Nick != "alice" ||
+		users[2].ID != bob.String() {
+		t.Fatalf("unexpected users after restart %+v", users)
+	}
+}
@@ -0,0 +1,641 @@
+// Package webhook posts bot events as JSON to external HTTP endpoints.
+//
+// Every request carries the headers:
+//
+//	X-Brbot-Event:     the event type
+//	X-Brbot-Delivery:  the delivery ID, stable across retries
+//	X-Brbot-Timestamp: the unix time of the attempt
+//	X-Brbot-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+//
+// The signature is only sent to endpoints with a secret. Receivers should
+// reject requests with a bad signature or a timestamp too far in the past,
+// see Verify.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/slog"
+	"golang.org/x/sync/errgroup"
+)
+
+// Event types.
+const (
+	EventPM   = "pm"
+	EventGCM  = "gcm"
+	EventKX   = "kx"
+	EventTip  = "tip"
+	EventPost = "post"
+)
+
+const (
+	defaultMinBackoff = 5 * time.Second
+	defaultMaxBackoff = 30 * time.Minute
+	defaultTimeout    = 30 * time.Second
+	defaultMaxQueued  = 10000
+	defaultMaxAge     = 7 * 24 * time.Hour
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Endpoint is a URL events are posted to.
+type Endpoint struct {
+	// Name identifies the endpoint in logs and in the outbox. It may
+	// only contain letters, digits, '-' and '_'.
+	Name string
+	URL  string
+
+	// Secret is the key of the HMAC signature. Requests are not signed
+	// when empty.
+	Secret string
+
+	// Events are the event types posted. An empty list posts every
+	// event.
+	Events []string
+
+	// GCs are the aliases of the GCs whose messages are posted. An empty
+	// list posts the messages of every GC.
+	GCs []string
+
+	// MaxQueued is the number of events kept in the outbox. Once it is
+	// full, the oldest events are moved to the failed directory to make
+	// room for new ones. Defaults to 10000.
+	MaxQueued int
+
+	// MaxAge is how long an event is retried before it is moved to the
+	// failed directory. Defaults to 7 days.
+	MaxAge time.Duration
+}
+
+func (ep *Endpoint) wants(event, gc string) bool {
+	if len(ep.Events) > 0 && !contains(ep.Events, event) {
+		return false
+	}
+	if event == EventGCM && len(ep.GCs) > 0 && !contains(ep.GCs, gc) {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+type Config struct {
+	DataDir string
+	Log     slog.Logger
+
+	Endpoints []Endpoint
+
+	// Client sends the requests. A client with a 30 second timeout is
+	// used when nil.
+	Client *http.Client
+
+	// MinBackoff and MaxBackoff bound the delay between attempts to
+	// deliver an event. The delay doubles after every failed attempt.
+	// They default to 5 seconds and 30 minutes.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Payload is the JSON body of a request.
+type Payload struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// PMData is the data of a pm event.
+type PMData struct {
+	UID  string    `json:"uid"`
+	Nick string    `json:"nick"`
+	Msg  string    `json:"msg"`
+	Time time.Time `json:"time"`
+}
+
+// GCMData is the data of a gcm event.
+type GCMData struct {
+	GC   string    `json:"gc"`
+	UID  string    `json:"uid"`
+	Nick string    `json:"nick"`
+	Msg  string    `json:"msg"`
+	Time time.Time `json:"time"`
+}
+
+// KXData is the data of a kx event.
+type KXData struct {
+	UID  string `json:"uid"`
+	Nick string `json:"nick"`
+}
+
+// TipData is the data of a tip event, sent for every attempt to pay a tip.
+type TipData struct {
+	UID       string  `json:"uid"`
+	Nick      string  `json:"nick"`
+	AmountDCR float64 `json:"amount_dcr"`
+	Completed bool    `json:"completed"`
+	Attempt   int32   `json:"attempt"`
+	Error     string  `json:"error,omitempty"`
+	WillRetry bool    `json:"will_retry"`
+}
+
+// PostData is the data of a post event.
+type PostData struct {
+	ID         string    `json:"id"`
+	AuthorID   string    `json:"author_id"`
+	AuthorNick string    `json:"author_nick"`
+	Title      string    `json:"title"`
+	Body       string    `json:"body"`
+	Time       time.Time `json:"time"`
+}
+
+// delivery is an event waiting in the outbox of an endpoint.
+type delivery struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	Created   time.Time       `json:"created"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	Body      json.RawMessage `json:"body"`
+
+	file string
+}
+
+type endpoint struct {
+	Endpoint
+	dir string
+
+	mtx   sync.Mutex
+	queue []*delivery
+	wake  chan struct{}
+}
+
+func (ep *endpoint) head() *delivery {
+	defer ep.mtx.Unlock()
+	ep.mtx.Lock()
+	if len(ep.queue) == 0 {
+		return nil
+	}
+	return ep.queue[0]
+}
+
+func (ep *endpoint) pop() {
+	defer ep.mtx.Unlock()
+	ep.mtx.Lock()
+	ep.queue = ep.queue[1:]
+}
+
+// Dispatcher posts events to the configured endpoints. Events are written to
+// a per endpoint outbox under DataDir/webhooks before being sent, and each
+// endpoint receives its events in order. An event is retried until the
+// endpoint accepts it, unless the endpoint rejects it with a 4xx status, the
+// event is older than the MaxAge of the endpoint or the outbox overflows, in
+// which case it is moved to the failed directory of the endpoint.
+type Dispatcher struct {
+	log        slog.Logger
+	client     *http.Client
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	endpoints  []*endpoint
+}
+
+func New(cfg Config) (*Dispatcher, error) {
+	d := &Dispatcher{
+		log:        cfg.Log,
+		client:     cfg.Client,
+		minBackoff: cfg.MinBackoff,
+		maxBackoff: cfg.MaxBackoff,
+	}
+	if d.client == nil {
+		d.client = &http.Client{Timeout: defaultTimeout}
+	}
+	if d.minBackoff <= 0 {
+		d.minBackoff = defaultMinBackoff
+	}
+	if d.maxBackoff < d.minBackoff {
+		d.maxBackoff = defaultMaxBackoff
+		if d.maxBackoff < d.minBackoff {
+			d.maxBackoff = d.minBackoff
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, cep := range cfg.Endpoints {
+		if !validName.MatchString(cep.Name) {
+			return nil, fmt.Errorf("invalid endpoint name %q", cep.Name)
+		}
+		if names[cep.Name] {
+			return nil, fmt.Errorf("duplicate endpoint name %q", cep.Name)
+		}
+		names[cep.Name] = true
+		if !strings.HasPrefix(cep.URL, "http://") && !strings.HasPrefix(cep.URL, "https://") {
+			return nil, fmt.Errorf("endpoint %v: invalid url %q", cep.Name, cep.URL)
+		}
+
+		if cep.MaxQueued <= 0 {
+			cep.MaxQueued = defaultMaxQueued
+		}
+		if cep.MaxAge <= 0 {
+			cep.MaxAge = defaultMaxAge
+		}
+		ep := &endpoint{
+			Endpoint: cep,
+			dir:      filepath.Join(cfg.DataDir, "webhooks", cep.Name),
+			wake:     make(chan struct{}, 1),
+		}
+		if err := os.MkdirAll(filepath.Join(ep.dir, "outbox"), 0o700); err != nil {
+			return nil, err
+		}
+		queue, err := loadOutbox(filepath.Join(ep.dir, "outbox"))
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %v: %w", cep.Name, err)
+		}
+		ep.queue = queue
+		d.trim(ep)
+		d.endpoints = append(d.endpoints, ep)
+	}
+	return d, nil
+}
+
+func loadOutbox(dir string) ([]*delivery, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	// File names start with the zero padded creation time, so they sort
+	// in the order the events were queued.
+	sort.Strings(files)
+
+	queue := make([]*delivery, 0, len(files))
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var del delivery
+		if err := json.Unmarshal(raw, &del); err != nil {
+			return nil, fmt.Errorf("%v: %w", file, err)
+		}
+		del.file = file
+		queue = append(queue, &del)
+	}
+	return queue, nil
+}
+
+func (del *delivery) save() error {
+	raw, err := json.Marshal(del)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(del.file, raw, 0o600)
+}
+
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// trim moves the oldest events to the failed directory until the outbox of
+// the endpoint holds at most MaxQueued events. The head of the queue may be
+// being delivered, so it is never moved.
+func (d *Dispatcher) trim(ep *endpoint) {
+	defer ep.mtx.Unlock()
+	ep.mtx.Lock()
+
+	for len(ep.queue) > ep.MaxQueued && len(ep.queue) > 1 {
+		del := ep.queue[1]
+		ep.queue = append(ep.queue[:1], ep.queue[2:]...)
+		d.log.Errorf("Outbox of %v is full, dropping %v event %v", ep.Name,
+			del.Event, del.ID)
+		if err := d.moveToFailed(ep, del, errors.New("outbox full")); err != nil {
+			d.log.Errorf("Unable to move dropped event %v: %v", del.file, err)
+		}
+	}
+}
+
+// enqueue adds the event to the outbox of every endpoint that wants it.
+func (d *Dispatcher) enqueue(event, gc string, data interface{}) error {
+	var eps []*endpoint
+	for _, ep := range d.endpoints {
+		if ep.wants(event, gc) {
+			eps = append(eps, ep)
+		}
+	}
+	if len(eps) == 0 {
+		return nil
+	}
+
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	body, err := json.Marshal(&Payload{ID: id, Type: event, Time: now, Data: rawData})
+	if err != nil {
+		return err
+	}
+
+	for _, ep := range eps {
+		del := &delivery{
+			ID:      id,
+			Event:   event,
+			Created: now,
+			Body:    body,
+			file: filepath.Join(ep.dir, "outbox",
+				fmt.Sprintf("%020d-%s.json", now.UnixNano(), id)),
+		}
+		if err := del.save(); err != nil {
+			return fmt.Errorf("endpoint %v: %w", ep.Name, err)
+		}
+
+		ep.mtx.Lock()
+		ep.queue = append(ep.queue, del)
+		ep.mtx.Unlock()
+		d.trim(ep)
+		select {
+		case ep.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) HandlePM(m *types.ReceivedPM) error {
+	if m.Msg == nil {
+		return nil
+	}
+	return d.enqueue(EventPM, "", &PMData{
+		UID:  hex.EncodeToString(m.Uid),
+		Nick: m.Nick,
+		Msg:  m.Msg.Message,
+		Time: time.UnixMilli(m.TimestampMs),
+	})
+}
+
+func (d *Dispatcher) HandleGC(m *types.GCReceivedMsg) error {
+	if m.Msg == nil {
+		return nil
+	}
+	return d.enqueue(EventGCM, m.GcAlias, &GCMData{
+		GC:   m.GcAlias,
+		UID:  hex.EncodeToString(m.Uid),
+		Nick: m.Nick,
+		Msg:  m.Msg.Message,
+		Time: time.UnixMilli(m.TimestampMs),
+	})
+}
+
+func (d *Dispatcher) HandleKX(m *types.KXCompleted) error {
+	return d.enqueue(EventKX, "", &KXData{
+		UID:  hex.EncodeToString(m.Uid),
+		Nick: m.Nick,
+	})
+}
+
+func (d *Dispatcher) HandleTipProgress(ev *types.TipProgressEvent) error {
+	return d.enqueue(EventTip, "", &TipData{
+		UID:       hex.EncodeToString(ev.Uid),
+		Nick:      ev.Nick,
+		AmountDCR: dcrutil.Amount(ev.AmountMatoms / 1000).ToCoin(),
+		Completed: ev.Completed,
+		Attempt:   ev.Attempt,
+		Error:     ev.AttemptErr,
+		WillRetry: ev.WillRetry,
+	})
+}
+
+func (d *Dispatcher) HandlePost(p *types.ReceivedPost) error {
+	if p.Summary == nil || p.Post == nil {
+		return nil
+	}
+	title := p.Post.Attributes[rpc.RMPTitle]
+	if title == "" {
+		title = p.Summary.Title
+	}
+	return d.enqueue(EventPost, "", &PostData{
+		ID:         hex.EncodeToString(p.Summary.Id),
+		AuthorID:   hex.EncodeToString(p.Summary.AuthorId),
+		AuthorNick: p.Summary.AuthorNick,
+		Title:      title,
+		Body:       p.Post.Attributes[rpc.RMPMain],
+		Time:       time.Unix(p.Summary.Date, 0),
+	})
+}
+
+// Pending returns the number of events waiting in the outbox of every
+// endpoint.
+func (d *Dispatcher) Pending() map[string]int {
+	res := make(map[string]int, len(d.endpoints))
+	for _, ep := range d.endpoints {
+		ep.mtx.Lock()
+		res[ep.Name] = len(ep.queue)
+		ep.mtx.Unlock()
+	}
+	return res
+}
+
+// Run delivers the queued events until the context is canceled.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	g, gctx := errgroup.WithContext(ctx)
+	for _, ep := range d.endpoints {
+		ep := ep
+		g.Go(func() error {
+			return d.runEndpoint(gctx, ep)
+		})
+	}
+	return g.Wait()
+}
+
+// permanentError is returned for responses that will not succeed if
+// retried.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (d *Dispatcher) runEndpoint(ctx context.Context, ep *endpoint) error {
+	backoff := d.minBackoff
+	for {
+		del := ep.head()
+		if del == nil {
+			select {
+			case <-ep.wake:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if time.Since(del.Created) > ep.MaxAge {
+			d.log.Errorf("Giving up on %v event %v to %v after %d attempts",
+				del.Event, del.ID, ep.Name, del.Attempts)
+			reason := fmt.Sprintf("expired after %d attempts", del.Attempts)
+			if del.LastError != "" {
+				reason += ": " + del.LastError
+			}
+			if err := d.moveToFailed(ep, del, errors.New(reason)); err != nil {
+				d.log.Errorf("Unable to move expired event %v: %v", del.file, err)
+			}
+			ep.pop()
+			backoff = d.minBackoff
+			continue
+		}
+
+		err := d.deliver(ctx, ep, del)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var permErr permanentError
+		switch {
+		case err == nil:
+			d.log.Debugf("Delivered %v event %v to %v", del.Event, del.ID, ep.Name)
+			if err := os.Remove(del.file); err != nil {
+				d.log.Errorf("Unable to remove delivered event %v: %v", del.file, err)
+			}
+			ep.pop()
+			backoff = d.minBackoff
+			continue
+
+		case errors.As(err, &permErr):
+			d.log.Errorf("Endpoint %v rejected %v event %v: %v", ep.Name,
+				del.Event, del.ID, err)
+			del.Attempts++
+			if err := d.moveToFailed(ep, del, err); err != nil {
+				d.log.Errorf("Unable to move rejected event %v: %v", del.file, err)
+			}
+			ep.pop()
+			continue
+		}
+
+		del.Attempts++
+		del.LastError = err.Error()
+		if err := del.save(); err != nil {
+			d.log.Errorf("Unable to update event %v: %v", del.file, err)
+		}
+		d.log.Warnf("Unable to deliver %v event %v to %v (attempt %d): %v; "+
+			"retrying in %v", del.Event, del.ID, ep.Name, del.Attempts, err, backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if backoff > d.maxBackoff {
+			backoff = d.maxBackoff
+		}
+	}
+}
+
+// moveToFailed records reason as the last error of the event and moves it
+// to the failed directory of the endpoint.
+func (d *Dispatcher) moveToFailed(ep *endpoint, del *delivery, reason error) error {
+	dir := filepath.Join(ep.dir, "failed")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	del.LastError = reason.Error()
+	if err := del.save(); err != nil {
+		return err
+	}
+	return os.Rename(del.file, filepath.Join(dir, filepath.Base(del.file)))
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, ep *endpoint, del *delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL,
+		bytes.NewReader(del.Body))
+	if err != nil {
+		return permanentError{err}
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "brbot-webhook")
+	req.Header.Set("X-Brbot-Event", del.Event)
+	req.Header.Set("X-Brbot-Delivery", del.ID)
+	req.Header.Set("X-Brbot-Timestamp", ts)
+	if ep.Secret != "" {
+		req.Header.Set("X-Brbot-Signature", Sign(ep.Secret, ts, del.Body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+
+	code := resp.StatusCode
+	switch {
+	case code >= 200 && code < 300:
+		return nil
+	case code >= 400 && code < 500 && code != http.StatusRequestTimeout &&
+		code != http.StatusTooManyRequests:
+		return permanentError{fmt.Errorf("status %d: %s", code, respBody)}
+	}
+	return fmt.Errorf("status %d: %s", code, respBody)
+}
+
+// Sign returns the X-Brbot-Signature header of body sent at the unix time
+// ts with secret.
+func Sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a request received from a Dispatcher and
+// that it was sent at most maxAge ago.
+func Verify(secret string, h http.Header, body []byte, maxAge time.Duration) error {
+	ts := h.Get("X-Brbot-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if age := time.Since(time.Unix(sec, 0)); age > maxAge || age < -maxAge {
+		return errors.New("timestamp out of range")
+	}
+	want := Sign(secret, ts, body)
+	if !hmac.Equal([]byte(want), []byte(h.Get("X-Brbot-Signature"))) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/clientrpc/types"
+	"github.com/decred/slog"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	header := func(ts, sig string) http.Header {
+		h := make(http.Header)
+		h.Set("X-Brbot-Timestamp", ts)
+		h.Set("X-Brbot-Signature", sig)
+		return h
+	}
+	if Sign("secret", now, body) != Sign("secret", now, body) {
+		t.Fatal("signature is not deterministic")
+	}
+
+	tests := []struct {
+		desc string
+		h    http.Header
+		body []byte
+		err  string
+	}{
+		{"valid", header(now, Sign("secret", now, body)), body, ""},
+		{"wrong secret", header(now, Sign("other", now, body)), body, "invalid signature"},
+		{"altered body", header(now, Sign("secret", now, body)), []byte(`{"id":"2"}`), "invalid signature"},
+		{"altered timestamp", header(now, Sign("secret", old, body)), body, "invalid signature"},
+		{"old timestamp", header(old, Sign("secret", old, body)), body, "timestamp out of range"},
+		{"missing timestamp", header("", Sign("secret", "", body)), body, "invalid timestamp"},
+	}
+	for _, tc := range tests {
+		err := Verify("secret", tc.h, tc.body, time.Minute)
+		if (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("%v: got %v, want %q", tc.desc, err, tc.err)
+		}
+	}
+}
+
+// receiver is an endpoint answering requests with the queued status codes,
+// then with 200.
+type receiver struct {
+	mtx      sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	defer rc.mtx.Unlock()
+	rc.mtx.Lock()
+	rc.bodies = append(rc.bodies, body)
+	rc.headers = append(rc.headers, r.Header.Clone())
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (rc *receiver) requests() int {
+	defer rc.mtx.Unlock()
+	rc.mtx.Lock()
+	return len(rc.bodies)
+}
+
+func failed(t *testing.T, dataDir, name string) []delivery {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dataDir, "webhooks", name, "failed", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []delivery
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var del delivery
+		if err := json.Unmarshal(raw, &del); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, del)
+	}
+	return res
+}
+
+func waitFor(ctx context.Context, t *testing.T, cond func() bool) {
+	t.Helper()
+	for !cond() {
+		select {
+		case <-ctx.Done():
+			t.Fatal("condition not met")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func pm(msg string) *types.ReceivedPM {
+	return &types.ReceivedPM{Nick: "alice", Msg: &types.RMPrivateMessage{Message: msg}}
+}
+
+func TestDelivery(t *testing.T) {
+	ok := &receiver{statuses: []int{http.StatusInternalServerError}}
+	okSrv := httptest.NewServer(ok)
+	defer okSrv.Close()
+	rejecting := &receiver{statuses: []int{http.StatusBadRequest}}
+	rejectingSrv := httptest.NewServer(rejecting)
+	defer rejectingSrv.Close()
+
+	dataDir := t.TempDir()
+	d, err := New(Config{
+		DataDir: dataDir,
+		Log:     slog.Disabled,
+		Endpoints: []Endpoint{
+			{Name: "ok", URL: okSrv.URL, Secret: "secret"},
+			{Name: "rejecting", URL: rejectingSrv.URL, Events: []string{EventPM}},
+		},
+		MinBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.HandlePM(pm("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.HandleKX(&types.KXCompleted{Nick: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go d.Run(ctx)
+	waitFor(ctx, t, func() bool {
+		p := d.Pending()
+		return p["ok"] == 0 && p["rejecting"] == 0
+	})
+
+	// The failed attempt was retried with the same delivery ID and every
+	// request is signed.
+	if got := ok.requests(); got != 3 {
+		t.Fatalf("%d requests to ok, want 3", got)
+	}
+	if ok.headers[0].Get("X-Brbot-Delivery") != ok.headers[1].Get("X-Brbot-Delivery") {
+		t.Fatal("delivery id changed on retry")
+	}
+	for i, h := range ok.headers {
+		if err := Verify("secret", h, ok.bodies[i], time.Minute); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	var p Payload
+	if err := json.Unmarshal(ok.bodies[2], &p); err != nil || p.Type != EventKX {
+		t.Fatalf("unexpected last payload %+v, %v", p, err)
+	}
+
+	// The rejected event was not retried and is kept in failed.
+	if got := rejecting.requests(); got != 1 {
+		t.Fatalf("%d requests to rejecting, want 1", got)
+	}
+	if rejecting.headers[0].Get("X-Brbot-Signature") != "" {
+		t.Fatal("request signed without a secret")
+	}
+	fails := failed(t, dataDir, "rejecting")
+	if len(fails) != 1 || fails[0].Event != EventPM || fails[0].Attempts != 1 ||
+		fails[0].LastError != "status 400: " {
+		t.Fatalf("unexpected failed events %+v", fails)
+	}
+	if got := failed(t, dataDir, "ok"); len(got) != 0 {
+		t.Fatalf("unexpected failed events %+v", got)
+	}
+}
+
+func TestOutboxLimits(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	dataDir := t.TempDir()
+	cfg := Config{
+		DataDir:   dataDir,
+		Log:       slog.Disabled,
+		Endpoints: []Endpoint{{Name: "ep", URL: srv.URL, MaxQueued: 2}},
+	}
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The oldest events after the head are dropped once the outbox is
+	// full.
+	for _, msg := range []string{"1", "2", "3", "4"} {
+		if err := d.HandlePM(pm(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := d.Pending()["ep"]; got != 2 {
+		t.Fatalf("%d events pending, want 2", got)
+	}
+	fails := failed(t, dataDir, "ep")
+	if len(fails) != 2 || fails[0].LastError != "outbox full" {
+		t.Fatalf("unexpected failed events %+v", fails)
+	}
+
+	// Events past their max age are not sent.
+	cfg.Endpoints[0].MaxAge = time.Nanosecond
+	d, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Pending()["ep"]; got != 2 {
+		t.Fatalf("%d events reloaded, want 2", got)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go d.Run(ctx)
+	waitFor(ctx, t, func() bool { return d.Pending()["ep"] == 0 })
+	if got := rc.requests(); got != 0 {
+		t.Fatalf("%d expired events sent", got)
+	}
+	if fails := failed(t, dataDir, "ep"); len(fails) != 4 ||
+		fails[3].LastError != "expired after 0 attempts" {
+		t.Fatalf("unexpected failed events %+v", fails)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/companyzero/bisonrelay-bot/bot/admin"
+	"github.com/companyzero/bisonrelay-bot/bot/health"
+)
+
+// bridgeInfo is a bridge as returned by the admin API.
+type bridgeInfo struct {
+	GC     string `json:"gc"`
+	Room   string `json:"room"`
+	Paused bool   `json:"paused"`
+}
+
+// bridgeState holds the bridged rooms, which may change while running
+// through the admin API. Both rooms of a paused bridge are in paused.
+type bridgeState struct {
+	mtx     sync.Mutex
+	bridges [][2]string
+	routes  map[string]string
+	paused  map[string]bool
+}
+
+func newBridgeState(cfg *config) *bridgeState {
+	s := &bridgeState{paused: make(map[string]bool)}
+	s.set(cfg)
+	return s
+}
+
+// set replaces the bridges with the ones in cfg and returns the Matrix rooms
+// that were not bridged before. Paused bridges that still exist stay paused.
+func (s *bridgeState) set(cfg *config) []string {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	var added []string
+	for _, bridge := range cfg.Bridges {
+		if _, ok := s.routes[bridge[1]]; !ok {
+			added = append(added, bridge[1])
+		}
+	}
+	for room := range s.paused {
+		if _, ok := cfg.bridges[room]; !ok {
+			delete(s.paused, room)
+		}
+	}
+	s.bridges = cfg.Bridges
+	s.routes = cfg.bridges
+	return added
+}
+
+// route returns the room bridged to fromRoom and whether the bridge is
+// paused. The returned room is empty when fromRoom is not bridged.
+func (s *bridgeState) route(fromRoom string) (string, bool) {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	return s.routes[fromRoom], s.paused[fromRoom]
+}
+
+func (s *bridgeState) list() []bridgeInfo {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	res := make([]bridgeInfo, 0, len(s.bridges))
+	for _, bridge := range s.bridges {
+		res = append(res, bridgeInfo{
+			GC:     bridge[0],
+			Room:   bridge[1],
+			Paused: s.paused[bridge[0]],
+		})
+	}
+	return res
+}
+
+// setPaused pauses or resumes the bridge of room, which may be either the
+// GC or the Matrix room.
+func (s *bridgeState) setPaused(room string, paused bool) error {
+	defer s.mtx.Unlock()
+	s.mtx.Lock()
+
+	for _, bridge := range s.bridges {
+		if bridge[0] != room && bridge[1] != room {
+			continue
+		}
+		for _, r := range bridge {
+			if paused {
+				s.paused[r] = true
+			} else {
+				delete(s.paused, r)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("room %v is not bridged", room)
+}
+
+// joinRooms joins the Matrix rooms and updates chk with the rooms that could
+// not be joined.
+func joinRooms(ctx context.Context, mc *MatrixClient, rooms []string, chk *health.Check) {
+	var notJoined []string
+	for _, room := range rooms {
+		if err := mc.Join(ctx, room); err != nil {
+			notJoined = append(notJoined, room)
+		}
+	}
+	if len(notJoined) > 0 {
+		chk.Fail(fmt.Sprintf("not joined: %v", strings.Join(notJoined, ", ")))
+	} else {
+		chk.Progress(fmt.Sprintf("joined %d rooms", len(rooms)))
+	}
+}
+
+type roomParams struct {
+	Room string `json:"room"`
+}
+
+// registerAdminMethods adds the bridge methods to the admin API. ctx bounds
+// the Matrix calls made when the config is reloaded.
+func registerAdminMethods(ctx context.Context, a *admin.Server, state *bridgeState,
+	mc *MatrixClient, roomsCheck *health.Check) {
+
+	a.Handle("bridges.list", "List the bridges.",
+		func(context.Context, json.RawMessage) (interface{}, error) {
+			return state.list(), nil
+		})
+
+	setPaused := func(paused bool) admin.HandlerFunc {
+		return func(_ context.Context, params json.RawMessage) (interface{}, error) {
+			var p roomParams
+			if err := admin.DecodeParams(params, &p); err != nil {
+				return nil, err
+			}
+			if p.Room == "" {
+				return nil, errors.New("room is required")
+			}
+			return nil, state.setPaused(p.Room, paused)
+		}
+	}
+	a.Handle("bridges.pause", "Stop forwarding messages of a bridge. Params: room (GC or Matrix room).",
+		setPaused(true))
+	a.Handle("bridges.resume", "Resume forwarding messages of a bridge. Params: room (GC or Matrix room).",
+		setPaused(false))
+
+	a.Handle("config.reload", "Reload the bridges from the config file. Other settings require a restart.",
+		func(context.Context, json.RawMessage) (interface{}, error) {
+			cfg, err := loadConfig()
+			if err != nil {
+				return nil, err
+			}
+			added := state.set(cfg)
+			if len(added) > 0 {
+				rooms := make([]string, 0, len(cfg.Bridges))
+				for _, bridge := range cfg.Bridges {
+					rooms = append(rooms, bridge[1])
+				}
+				joinRooms(ctx, mc, rooms, roomsCheck)
+			}
+			return state.list(), nil
+		})
+}
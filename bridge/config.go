@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -26,6 +27,19 @@ type config struct {
 	MatrixToken string
 	MatrixProxy string
 
+	// MetricsAddr is the address to serve Prometheus metrics and the
+	// /healthz and /readyz checks on. Disabled when empty.
+	MetricsAddr string
+
+	// HealthStaleAfter is how long the clientrpc connection and the
+	// Matrix sync may go without progress before they are reported as
+	// failing.
+	HealthStaleAfter time.Duration
+
+	// AdminSocket is the path of the Unix socket serving the admin API
+	// used by brbotctl. Defaults to admin.sock in DataDir.
+	AdminSocket string
+
 	// TODO - add network support
 	Bridges [][2]string
 	bridges map[string]string
@@ -54,10 +68,17 @@ func loadConfig() (*config, error) {
 	cfg.ServerCertPath = cleanAndExpandPath(cfg.ServerCertPath)
 	cfg.ClientCertPath = cleanAndExpandPath(cfg.ClientCertPath)
 	cfg.ClientKeyPath = cleanAndExpandPath(cfg.ClientKeyPath)
+	cfg.AdminSocket = cleanAndExpandPath(cfg.AdminSocket)
 
 	if cfg.DataDir == "" {
 		cfg.DataDir = defaultHomeDir
 	}
+	if cfg.AdminSocket == "" {
+		cfg.AdminSocket = filepath.Join(cfg.DataDir, "admin.sock")
+	}
+	if cfg.HealthStaleAfter <= 0 {
+		cfg.HealthStaleAfter = 2 * time.Minute
+	}
 	if cfg.MatrixPass == "" {
 		str := "%s: matrix password is required"
 		err = fmt.Errorf(str, funcName)
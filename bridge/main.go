@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 
 	"github.com/companyzero/bisonrelay-bot/bot"
+	"github.com/companyzero/bisonrelay-bot/bot/health"
+	"github.com/companyzero/bisonrelay-bot/bot/metrics"
 	"github.com/companyzero/bisonrelay/clientrpc/types"
 	"github.com/decred/slog"
 	"github.com/jrick/logrotate/rotator"
@@ -59,6 +61,15 @@ func realMain() error {
 	gcChan := make(chan types.GCReceivedMsg)
 	mtrxChan := make(chan mtrxMsg)
 
+	reg := metrics.NewRegistry()
+	healthReg := health.NewRegistry()
+	roomsCheck := healthReg.Register("matrix_rooms", false, 0)
+	forwarded := reg.Counter("bridge_forwarded_total",
+		"Messages forwarded per destination room and direction.", "room", "direction")
+	forwardErrors := reg.Counter("bridge_forward_errors_total",
+		"Messages that failed to be forwarded per destination room and direction.",
+		"room", "direction")
+
 	botCfg := bot.Config{
 		DataDir: cfg.DataDir,
 		Log:     botLog,
@@ -70,6 +81,13 @@ func realMain() error {
 
 		GCChan: gcChan,
 		GCLog:  gcLog,
+
+		MetricsAddr:      cfg.MetricsAddr,
+		Metrics:          reg,
+		Health:           healthReg,
+		HealthStaleAfter: cfg.HealthStaleAfter,
+
+		AdminSocket: cfg.AdminSocket,
 	}
 
 	mCfg := MatrixClientConfig{
@@ -79,6 +97,10 @@ func realMain() error {
 		Token:    cfg.MatrixToken,
 		Proxy:    cfg.MatrixProxy,
 		Log:      mtrxLog,
+		Metrics:  reg,
+		Health:   healthReg,
+
+		SyncStaleAfter: cfg.HealthStaleAfter,
 	}
 	mc := NewMatrixClient(mCfg)
 
@@ -90,9 +112,8 @@ func realMain() error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	findRoom := func(fromRoom string) string {
-		return cfg.bridges[fromRoom]
-	}
+	state := newBridgeState(cfg)
+	registerAdminMethods(ctx, bot.Admin(), state, mc, roomsCheck)
 
 	// Launch handler
 	go func() {
@@ -108,26 +129,34 @@ func realMain() error {
 				}
 
 				// send to matrix somehow
-				room := findRoom(pm.GcAlias)
+				room, paused := state.route(pm.GcAlias)
 				if room == "" {
 					gcLog.Errorf("room %v is not bridged", pm.GcAlias)
 					continue
 				}
+				if paused {
+					gcLog.Tracef("bridge of %v is paused", pm.GcAlias)
+					continue
+				}
 				// Upload and post embedded images.
 				origMsg := pm.Msg.Message
 				msg := replaceEmbeds(origMsg, func(embed embeddedArgs) string {
 					err := mc.SendEmbed(ctx, room, embed)
 					if err != nil {
+						forwardErrors.Inc(room, "to_matrix")
 						mc.cfg.Log.Errorf("sendembed: %v", err)
 					}
 					return ""
 				})
 				if msg != "" {
 					msg = fmt.Sprintf("[br] <%v> %v", nick, origMsg)
-					mc.SendMessage(ctx, room, msg)
 				} else {
 					msg = fmt.Sprintf("[br] <%v>", nick)
-					mc.SendMessage(ctx, room, msg)
+				}
+				if err := mc.SendMessage(ctx, room, msg); err != nil {
+					forwardErrors.Inc(room, "to_matrix")
+				} else {
+					forwarded.Inc(room, "to_matrix")
 				}
 			}
 		}
@@ -139,15 +168,22 @@ func realMain() error {
 			case <-ctx.Done():
 				return
 			case m := <-mtrxChan:
-				room := findRoom(m.Room)
+				room, paused := state.route(m.Room)
 				if room == "" {
 					mtrxLog.Errorf("room %v is not bridged", m.Room)
 					continue
 				}
+				if paused {
+					mtrxLog.Tracef("bridge of %v is paused", m.Room)
+					continue
+				}
 
 				msg := fmt.Sprintf("[m] <%v> %v", m.Nick, m.Msg)
 				if err := bot.SendGC(ctx, room, msg); err != nil {
+					forwardErrors.Inc(room, "to_br")
 					gcLog.Errorf("failed to send msg to gc %v: %v", room, err)
+				} else {
+					forwarded.Inc(room, "to_br")
 				}
 			}
 		}
@@ -164,9 +200,11 @@ func realMain() error {
 	// TODO - get token from Login?
 	mc.Login(ctx, mc.cfg.User, mc.cfg.Password)
 
+	rooms := make([]string, 0, len(cfg.Bridges))
 	for _, bridge := range cfg.Bridges {
-		mc.Join(ctx, bridge[1])
+		rooms = append(rooms, bridge[1])
 	}
+	joinRooms(ctx, mc, rooms, roomsCheck)
 
 	mc.Status(ctx, "online")
 
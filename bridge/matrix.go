@@ -20,6 +20,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/companyzero/bisonrelay-bot/bot/health"
+	"github.com/companyzero/bisonrelay-bot/bot/metrics"
 	"github.com/decred/go-socks/socks"
 	"github.com/decred/slog"
 )
@@ -31,6 +33,12 @@ type MatrixClientConfig struct {
 	Token    string
 	Proxy    string
 	Log      slog.Logger
+	Metrics  *metrics.Registry
+	Health   *health.Registry
+
+	// SyncStaleAfter is how long the sync loop may go without a
+	// successful sync before its health check fails.
+	SyncStaleAfter time.Duration
 }
 
 type MatrixClient struct {
@@ -38,6 +46,29 @@ type MatrixClient struct {
 	hc           *http.Client
 	sincePath    string
 	displayNames map[string]string
+	syncLatency  *metrics.Histogram
+	syncCheck    *health.Check
+}
+
+// metricsTransport counts the HTTP responses received from the Matrix
+// server by method and status code.
+type metricsTransport struct {
+	rt        http.RoundTripper
+	responses *metrics.Counter
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := t.rt
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	resp, err := rt.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	t.responses.Inc(req.Method, code)
+	return resp, err
 }
 
 func NewMatrixClient(cfg MatrixClientConfig) *MatrixClient {
@@ -52,38 +83,58 @@ func NewMatrixClient(cfg MatrixClientConfig) *MatrixClient {
 		}
 	}
 
+	reg := cfg.Metrics
+	if reg == nil {
+		reg = metrics.NewRegistry()
+	}
+	hc.Transport = &metricsTransport{
+		rt: hc.Transport,
+		responses: reg.Counter("bridge_matrix_http_responses_total",
+			"HTTP responses from the Matrix server by method and status code.",
+			"method", "code"),
+	}
+
+	healthReg := cfg.Health
+	if healthReg == nil {
+		healthReg = health.NewRegistry()
+	}
+
 	return &MatrixClient{
 		cfg:          cfg,
 		hc:           &hc,
 		sincePath:    filepath.Join(cfg.DataDir, "since.json"),
 		displayNames: make(map[string]string),
+		syncLatency: reg.Histogram("bridge_matrix_sync_duration_seconds",
+			"Duration of Matrix sync requests, including the long poll.", nil),
+		syncCheck: healthReg.Register("matrix_sync", true, cfg.SyncStaleAfter),
 	}
 }
 
-func (m *MatrixClient) Join(ctx context.Context, room string) {
+func (m *MatrixClient) Join(ctx context.Context, room string) error {
 	apiURL := fmt.Sprintf("https://matrix.decred.org:8448/_matrix/client/v3/join/%s", room)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, nil)
 	if err != nil {
 		m.cfg.Log.Errorf("join: failed to create request: %v", err)
-		return
+		return err
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", m.cfg.Token))
 	resp, err := m.hc.Do(req)
 	if err != nil {
 		m.cfg.Log.Errorf("join: request failed: %v", err)
-		return
+		return err
 	}
 	b, err := io.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
 		m.cfg.Log.Errorf("join: failed to read body: %v", err)
-		return
+		return err
 	}
 	if resp.StatusCode != 200 {
 		m.cfg.Log.Errorf("failed to join room %q: %s", room, b)
-		return
+		return fmt.Errorf("failed to join room %q (statuscode:%v)", room, resp.StatusCode)
 	}
 	m.cfg.Log.Infof("joined %v", room)
+	return nil
 }
 
 func (m *MatrixClient) Part(ctx context.Context, room string) {
@@ -613,8 +664,11 @@ func (m *MatrixClient) Sync(ctx context.Context, recvChan chan mtrxMsg) error {
 			continue
 		}
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", m.cfg.Token))
+		start := time.Now()
 		resp, err := m.hc.Do(req)
+		m.syncLatency.ObserveSince(start)
 		if err != nil {
+			m.syncCheck.Fail(err.Error())
 			m.cfg.Log.Errorf("Sync: failed to fetch: %v", err)
 			time.Sleep(2 * time.Second)
 			continue
@@ -625,6 +679,7 @@ func (m *MatrixClient) Sync(ctx context.Context, recvChan chan mtrxMsg) error {
 			return err
 		}
 		if resp.StatusCode != 200 {
+			m.syncCheck.Fail(fmt.Sprintf("statuscode:%v", resp.StatusCode))
 			m.cfg.Log.Errorf("Sync: statuscode:%v %s", resp.StatusCode, b)
 			time.Sleep(5 * time.Second)
 			continue
@@ -706,6 +761,7 @@ func (m *MatrixClient) Sync(ctx context.Context, recvChan chan mtrxMsg) error {
 			panic(err)
 		}
 		since = reply.NextBatch
+		m.syncCheck.Progress("synced")
 	}
 }
 func (m *MatrixClient) Run(ctx context.Context, recvChan chan mtrxMsg) error {